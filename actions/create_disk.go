@@ -1,29 +1,79 @@
 package actions
 
 import (
+	"context"
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/evoila/kubernetes-cpi/config"
 	"github.com/evoila/kubernetes-cpi/cpi"
 	"github.com/evoila/kubernetes-cpi/kubecluster"
 
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	// SelectedNodeAnnotation asks the volume provisioner to create the volume
+	// where the named node can reach it.
+	SelectedNodeAnnotation = "volume.kubernetes.io/selected-node"
+
+	// ZoneAnnotation records the zone the disk was provisioned for.
+	ZoneAnnotation = "bosh.cloudfoundry.org/zone"
+
+	// DiskCIDAnnotation records the CID the director knows a disk by.
+	DiskCIDAnnotation = "bosh.cloudfoundry.org/disk-cid"
+
+	zoneLabel     = "topology.kubernetes.io/zone"
+	betaZoneLabel = "failure-domain.beta.kubernetes.io/zone"
 )
 
 type CreateDiskCloudProperties struct {
-	Context string `json:"context"`
+	Context    string `json:"context"`
+	Namespace  string `json:"namespace,omitempty"`
+	VolumeMode string `json:"volume_mode,omitempty" enum:"Filesystem,Block"`
+}
+
+// StrictFields rejects disk cloud properties the CPI does not know about.
+func (CreateDiskCloudProperties) StrictFields() {}
+
+// Validate checks the disk cloud properties before the claim is created.
+func (c CreateDiskCloudProperties) Validate() error {
+	if c.Namespace != "" {
+		if errs := validation.IsDNS1123Label(c.Namespace); len(errs) != 0 {
+			return cpi.NewFieldError("namespace", "%q is not a valid namespace: %s", c.Namespace, strings.Join(errs, ", "))
+		}
+	}
+	if _, err := kubeVolumeMode(c.VolumeMode); err != nil {
+		return &cpi.FieldError{Path: "volume_mode", Err: err}
+	}
+	return nil
 }
 
 // DiskCreator simply creates a PersistentVolumeClaim. The attach process will
 // turn the claim into a volume mounted into the pod.
+//
+// When a VM CID is provided, the claim is provisioned in the namespace of
+// the VM and for the node and zone the VM's pod is running on so the disk
+// can be attached to it later. Without one, a namespace named in the cloud
+// properties is created the way create_vm creates it.
 type DiskCreator struct {
 	ClientProvider    kubecluster.ClientProvider
 	GUIDGeneratorFunc func() (string, error)
+	Events            *EventRecorder
+	Namespaces        *config.Namespaces
 }
 
-func (d *DiskCreator) CreateDisk(size uint, cloudProps CreateDiskCloudProperties, vmcid cpi.VMCID) (cpi.DiskCID, error) {
+// placement describes where the pod of a VM is running.
+type placement struct {
+	Node string
+	Zone string
+}
+
+func (d *DiskCreator) CreateDisk(ctx context.Context, size uint, cloudProps CreateDiskCloudProperties, vmcid cpi.VMCID) (cpi.DiskCID, error) {
 	diskID, err := d.GUIDGeneratorFunc()
 	if err != nil {
 		return "", err
@@ -34,21 +84,73 @@ func (d *DiskCreator) CreateDisk(size uint, cloudProps CreateDiskCloudProperties
 		return "", err
 	}
 
-	client, err := d.ClientProvider.New(cloudProps.Context)
+	volumeMode, err := kubeVolumeMode(cloudProps.VolumeMode)
+	if err != nil {
+		return "", err
+	}
+
+	namespace := cloudProps.Namespace
+	var vm cpi.CID
+	if vmcid != "" {
+		vm, err = vmcid.Parse()
+		if err != nil {
+			return "", err
+		}
+		if namespace != "" && namespace != vm.Namespace {
+			return "", fmt.Errorf("Kubernetes disk and VM namespaces must be the same: disk: %q, VM: %q", namespace, vm.Namespace)
+		}
+		namespace = vm.Namespace
+	} else if namespace == "" && d.Namespaces != nil && d.Namespaces.Template != "" {
+		// The namespace template is rendered from the environment of a VM,
+		// which create_disk does not get.
+		return "", fmt.Errorf("Disks created without a VM need the namespace cloud property when a namespace template is configured")
+	}
+
+	client, err := newClient(ctx, d.ClientProvider, cloudProps.Context, namespace)
 	if err != nil {
 		return "", err
 	}
 
+	if vmcid == "" && namespace != "" {
+		err = createNamespace(ctx, client.Core(), client.Namespace(), d.Namespaces)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	var place *placement
+	if vmcid != "" {
+		if vm.Context != client.Context() {
+			return "", fmt.Errorf("Kubernetes disk and resource pool contexts must be the same: disk: %q, resource pool: %q", client.Context(), vm.Context)
+		}
+
+		place, err = getPlacement(client, vmObjectsFor(vm).Pod())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	diskCID := diskCIDFor(client, diskID)
+	annotations := map[string]string{DiskCIDAnnotation: string(diskCID)}
+	if place != nil {
+		annotations[SelectedNodeAnnotation] = place.Node
+		if place.Zone != "" {
+			annotations[ZoneAnnotation] = place.Zone
+		}
+	}
+
 	_, err = client.PersistentVolumeClaims().Create(&v1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "disk-" + diskID,
-			Namespace: client.Namespace(),
-			Labels: map[string]string{
+			Name:        "disk-" + diskID,
+			Namespace:   client.Namespace(),
+			Annotations: annotations,
+			Labels: directorLabels(ctx, map[string]string{
 				"bosh.cloudfoundry.org/disk-id": diskID,
-			},
+			}),
 		},
 		Spec: v1.PersistentVolumeClaimSpec{
 			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
+			VolumeMode:  volumeMode,
 			Resources: v1.ResourceRequirements{
 				Requests: v1.ResourceList{
 					v1.ResourceStorage: volumeSize,
@@ -60,17 +162,129 @@ func (d *DiskCreator) CreateDisk(size uint, cloudProps CreateDiskCloudProperties
 		return "", err
 	}
 
-	volume, err := client.PersistentVolumeClaims().Get("disk-"+diskID, metav1.GetOptions{})
+	volume, err := waitForClaimBound(ctx, client, "disk-"+diskID)
 	if err != nil {
+		d.deleteClaim(client, "disk-"+diskID)
 		return "", err
 	}
-	for bound := volume.Status.Phase; bound != "Bound"; bound = volume.Status.Phase {
-		time.Sleep(1 * time.Second)
-		volume, err = client.PersistentVolumeClaims().Get("disk-"+diskID, metav1.GetOptions{})
+
+	if place != nil && place.Zone != "" {
+		err = verifyVolumeZone(client, volume, place.Zone)
 		if err != nil {
+			d.deleteClaim(client, volume.Name)
 			return "", err
 		}
 	}
 
-	return NewDiskCID(client.Context(), diskID), nil
+	if vmcid != "" {
+		d.Events.Record(client, objectReference("PersistentVolumeClaim", volume.ObjectMeta), EventReasonCreated, "Created disk %s for VM %s", diskCID, vmcid)
+	} else {
+		d.Events.Record(client, objectReference("PersistentVolumeClaim", volume.ObjectMeta), EventReasonCreated, "Created disk %s", diskCID)
+	}
+	return diskCID, nil
+}
+
+// deleteClaim removes a claim that will not be handed out. The request
+// context may already be cancelled so a fresh client is used.
+func (d *DiskCreator) deleteClaim(requestClient kubecluster.Client, name string) {
+	client, err := newClient(context.Background(), d.ClientProvider, requestClient.Context(), kubecluster.ExplicitNamespace(requestClient))
+	if err != nil {
+		return
+	}
+
+	client.PersistentVolumeClaims().Delete(name, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+}
+
+// waitForClaimBound polls a claim until it is bound to a volume or the
+// context is done.
+func waitForClaimBound(ctx context.Context, client kubecluster.Client, name string) (claim *v1.PersistentVolumeClaim, err error) {
+	ctx, span := startStep(ctx, "wait_for_claim")
+	start := time.Now()
+	defer func() {
+		observeWait(ctx, PhaseClaimBound, client.Context(), time.Since(start), err)
+		endStep(span, err)
+	}()
+
+	for {
+		claim, err = client.PersistentVolumeClaims().Get(name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		if claim.Status.Phase == v1.ClaimBound {
+			return claim, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("Persistent volume claim %q was not bound: %s", name, ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
+	}
+}
+
+func kubeVolumeMode(mode string) (*v1.PersistentVolumeMode, error) {
+	err := cpi.CheckEnum(CreateDiskCloudProperties{VolumeMode: mode}, "VolumeMode")
+	if err != nil || mode == "" {
+		return nil, err
+	}
+	volumeMode := v1.PersistentVolumeMode(mode)
+	return &volumeMode, nil
+}
+
+// getPlacement returns the node and zone of the VM's pod. A pod that does
+// not exist or has not been scheduled yet provides no placement.
+func getPlacement(client kubecluster.Client, podName string) (*placement, error) {
+	pod, err := client.Pods().Get(podName, metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundStatusError(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if pod.Spec.NodeName == "" {
+		return nil, nil
+	}
+
+	node, err := client.Core().Nodes().Get(pod.Spec.NodeName, metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundStatusError(err) {
+			return &placement{Node: pod.Spec.NodeName}, nil
+		}
+		return nil, err
+	}
+
+	return &placement{Node: node.Name, Zone: zoneOf(node.Labels)}, nil
+}
+
+// verifyVolumeZone makes sure the volume bound to the claim lives in the
+// zone of the VM. Provisioners that ignore the selected node annotation
+// would otherwise hand out disks that can never be attached.
+func verifyVolumeZone(client kubecluster.Client, claim *v1.PersistentVolumeClaim, zone string) error {
+	if claim.Spec.VolumeName == "" {
+		return nil
+	}
+
+	pv, err := client.Core().PersistentVolumes().Get(claim.Spec.VolumeName, metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundStatusError(err) {
+			return nil
+		}
+		return err
+	}
+
+	volumeZone := zoneOf(pv.Labels)
+	if volumeZone != "" && volumeZone != zone {
+		return fmt.Errorf("Persistent volume %q was provisioned in zone %q but the VM is running in zone %q", pv.Name, volumeZone, zone)
+	}
+
+	return nil
+}
+
+func zoneOf(labels map[string]string) string {
+	if zone, ok := labels[zoneLabel]; ok {
+		return zone
+	}
+	return labels[betaZoneLabel]
 }
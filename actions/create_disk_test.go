@@ -1,8 +1,12 @@
 package actions_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"time"
 
+	"code.cloudfoundry.org/clock/fakeclock"
 	"k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -10,6 +14,7 @@ import (
 	"k8s.io/client-go/testing"
 
 	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/config"
 	"github.com/evoila/kubernetes-cpi/cpi"
 	"github.com/evoila/kubernetes-cpi/kubecluster/fakes"
 
@@ -35,7 +40,7 @@ var _ = Describe("CreateDisk", func() {
 		fakeProvider = &fakes.ClientProvider{}
 		fakeProvider.NewReturns(fakeClient, nil)
 
-		vmcid = actions.NewVMCID("bosh", "agent-id")
+		vmcid = cpi.NewVMCID("bosh", "", "agent-id")
 		cloudProps = actions.CreateDiskCloudProperties{
 			Context: "bosh",
 		}
@@ -44,20 +49,29 @@ var _ = Describe("CreateDisk", func() {
 			ClientProvider:    fakeProvider,
 			GUIDGeneratorFunc: func() (string, error) { return "disk-guid", nil },
 		}
+
+		fakeClient.PrependReactor("get", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+			return true, &v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "disk-disk-guid", Namespace: "bosh-namespace"},
+				Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-disk-guid"},
+				Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+			}, nil
+		})
 	})
 
 	It("gets a client for the appropriate context", func() {
-		_, err := diskCreator.CreateDisk(1000, cloudProps, vmcid)
+		_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(fakeProvider.NewCallCount()).To(Equal(1))
-		Expect(fakeProvider.NewArgsForCall(0)).To(Equal("bosh"))
+		_, contextName := fakeProvider.NewArgsForCall(0)
+		Expect(contextName).To(Equal("bosh"))
 	})
 
 	It("creates a persistent volume claim", func() {
-		diskCID, err := diskCreator.CreateDisk(1000, cloudProps, vmcid)
+		diskCID, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
 		Expect(err).NotTo(HaveOccurred())
-		Expect(diskCID).To(Equal(cpi.DiskCID("bosh:disk-guid")))
+		Expect(diskCID).To(Equal(cpi.NewDiskCID("bosh", "", "disk-guid")))
 
 		matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
 		Expect(matches).To(HaveLen(1))
@@ -70,6 +84,9 @@ var _ = Describe("CreateDisk", func() {
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      "disk-disk-guid",
 				Namespace: "bosh-namespace",
+				Annotations: map[string]string{
+					actions.DiskCIDAnnotation: string(diskCID),
+				},
 				Labels: map[string]string{
 					"bosh.cloudfoundry.org/disk-id": "disk-guid",
 				},
@@ -85,13 +102,244 @@ var _ = Describe("CreateDisk", func() {
 		}))
 	})
 
+	It("labels the claim with the director of the request", func() {
+		ctx := cpi.NewContext(context.Background(), cpi.Context{DirectorUUID: "director-uuid"})
+		_, err := diskCreator.CreateDisk(ctx, 1000, cloudProps, vmcid)
+		Expect(err).NotTo(HaveOccurred())
+
+		matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+		Expect(matches).To(HaveLen(1))
+		pvc := matches[0].(testing.CreateAction).GetObject().(*v1.PersistentVolumeClaim)
+		Expect(pvc.Labels).To(HaveKeyWithValue(actions.DirectorUUIDLabel, "director-uuid"))
+	})
+
+	It("records an event against the claim", func() {
+		diskCreator.Events = &actions.EventRecorder{Clock: fakeclock.NewFakeClock(time.Now())}
+
+		_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+		Expect(err).NotTo(HaveOccurred())
+
+		matches := fakeClient.MatchingActions("create", "events")
+		Expect(matches).To(HaveLen(1))
+
+		event := matches[0].(testing.CreateAction).GetObject().(*v1.Event)
+		Expect(event.InvolvedObject.Kind).To(Equal("PersistentVolumeClaim"))
+		Expect(event.InvolvedObject.Name).To(Equal("disk-disk-guid"))
+		Expect(event.Reason).To(Equal(actions.EventReasonCreated))
+		Expect(event.Message).To(Equal(fmt.Sprintf("Created disk %s for VM %s", cpi.NewDiskCID("bosh", "", "disk-guid"), vmcid)))
+	})
+
+	It("reports the time spent waiting for the claim to be bound", func() {
+		var phases, contexts []string
+		ctx := actions.WithWaitObserver(context.Background(), func(phase, contextName string, duration time.Duration, err error) {
+			phases = append(phases, phase)
+			contexts = append(contexts, contextName)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		_, err := diskCreator.CreateDisk(ctx, 1000, cloudProps, vmcid)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(phases).To(Equal([]string{actions.PhaseClaimBound}))
+		Expect(contexts).To(Equal([]string{"bosh"}))
+	})
+
+	Context("when a Block volume mode is requested", func() {
+		BeforeEach(func() {
+			cloudProps.VolumeMode = "Block"
+		})
+
+		It("creates the claim with the volume mode", func() {
+			_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+
+			pvc := matches[0].(testing.CreateAction).GetObject().(*v1.PersistentVolumeClaim)
+			Expect(pvc.Spec.VolumeMode).NotTo(BeNil())
+			Expect(*pvc.Spec.VolumeMode).To(Equal(v1.PersistentVolumeBlock))
+		})
+	})
+
+	Context("when an unsupported volume mode is requested", func() {
+		BeforeEach(func() {
+			cloudProps.VolumeMode = "Tape"
+		})
+
+		It("returns an error", func() {
+			_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+			Expect(err).To(MatchError(`"Tape" is not one of Filesystem, Block`))
+			Expect(fakeClient.MatchingActions("create", "persistentvolumeclaims")).To(HaveLen(0))
+		})
+	})
+
+	Context("when the VM pod has been scheduled", func() {
+		BeforeEach(func() {
+			fakeClient.SetObjects(
+				&v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"},
+					Spec:       v1.PodSpec{NodeName: "node-1"},
+				},
+				&v1.Node{ObjectMeta: metav1.ObjectMeta{
+					Name:   "node-1",
+					Labels: map[string]string{"failure-domain.beta.kubernetes.io/zone": "zone-a"},
+				}},
+			)
+			fakeClient.PrependReactor("get", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, &v1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "disk-disk-guid", Namespace: "bosh-namespace"},
+					Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-disk-guid"},
+					Status:     v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound},
+				}, nil
+			})
+		})
+
+		It("provisions the claim for the node and zone of the pod", func() {
+			_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+
+			pvc := matches[0].(testing.CreateAction).GetObject().(*v1.PersistentVolumeClaim)
+			Expect(pvc.Annotations).To(Equal(map[string]string{
+				"volume.kubernetes.io/selected-node": "node-1",
+				"bosh.cloudfoundry.org/zone":         "zone-a",
+				"bosh.cloudfoundry.org/disk-cid":     string(cpi.NewDiskCID("bosh", "", "disk-guid")),
+			}))
+		})
+
+		Context("when the volume is provisioned in another zone", func() {
+			BeforeEach(func() {
+				fakeClient.PrependReactor("get", "persistentvolumes", func(action testing.Action) (bool, runtime.Object, error) {
+					return true, &v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{
+						Name:   "pv-disk-guid",
+						Labels: map[string]string{"failure-domain.beta.kubernetes.io/zone": "zone-b"},
+					}}, nil
+				})
+			})
+
+			It("deletes the claim and returns an error", func() {
+				_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+				Expect(err).To(MatchError(`Persistent volume "pv-disk-guid" was provisioned in zone "zone-b" but the VM is running in zone "zone-a"`))
+				Expect(fakeClient.MatchingActions("delete", "persistentvolumeclaims")).To(HaveLen(1))
+			})
+		})
+	})
+
+	Context("when the VM context does not match the disk context", func() {
+		BeforeEach(func() {
+			vmcid = cpi.NewVMCID("other", "", "agent-id")
+		})
+
+		It("returns an error before creating the claim", func() {
+			_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+			Expect(err).To(MatchError(`Kubernetes disk and resource pool contexts must be the same: disk: "bosh", resource pool: "other"`))
+			Expect(fakeClient.MatchingActions("create", "persistentvolumeclaims")).To(HaveLen(0))
+		})
+	})
+
+	Context("when the VM is in a namespace of its own", func() {
+		BeforeEach(func() {
+			vmcid = cpi.NewVMCID("bosh", "deployment-ns", "agent-id")
+		})
+
+		It("creates the claim in the namespace of the VM", func() {
+			diskCID, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diskCID).To(Equal(cpi.NewDiskCID("bosh", "deployment-ns", "disk-guid")))
+
+			matches := fakeClient.MatchingActions("create", "persistentvolumeclaims")
+			Expect(matches).To(HaveLen(1))
+			Expect(matches[0].GetNamespace()).To(Equal("deployment-ns"))
+		})
+
+		Context("when the cloud properties name another namespace", func() {
+			BeforeEach(func() {
+				cloudProps.Namespace = "other-ns"
+			})
+
+			It("returns an error before creating the claim", func() {
+				_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+				Expect(err).To(MatchError(`Kubernetes disk and VM namespaces must be the same: disk: "other-ns", VM: "deployment-ns"`))
+				Expect(fakeClient.MatchingActions("create", "persistentvolumeclaims")).To(HaveLen(0))
+			})
+		})
+	})
+
+	Context("when no VM is given", func() {
+		BeforeEach(func() {
+			vmcid = ""
+		})
+
+		Context("when the cloud properties name a namespace", func() {
+			BeforeEach(func() {
+				cloudProps.Namespace = "deployment-ns"
+				diskCreator.Namespaces = &config.Namespaces{PodSecurity: config.PodSecurityPrivileged}
+			})
+
+			It("creates the namespace the way create_vm does", func() {
+				diskCID, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(diskCID).To(Equal(cpi.NewDiskCID("bosh", "deployment-ns", "disk-guid")))
+
+				matches := fakeClient.MatchingActions("create", "namespaces")
+				Expect(matches).To(HaveLen(1))
+				namespace := matches[0].(testing.CreateAction).GetObject().(*v1.Namespace)
+				Expect(namespace.Name).To(Equal("deployment-ns"))
+				Expect(namespace.Labels).To(HaveKeyWithValue(actions.PodSecurityEnforceLabel, config.PodSecurityPrivileged))
+				Expect(namespace.Labels).To(HaveKeyWithValue(actions.ManagedByLabel, actions.EventComponent))
+
+				matches = fakeClient.MatchingActions("create", "persistentvolumeclaims")
+				Expect(matches).To(HaveLen(1))
+				Expect(matches[0].GetNamespace()).To(Equal("deployment-ns"))
+			})
+		})
+
+		Context("when a namespace template is configured", func() {
+			BeforeEach(func() {
+				diskCreator.Namespaces = &config.Namespaces{Template: "bosh-{{.Deployment}}"}
+			})
+
+			It("returns an error before creating the claim", func() {
+				_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+				Expect(err).To(MatchError("Disks created without a VM need the namespace cloud property when a namespace template is configured"))
+				Expect(fakeClient.MatchingActions("create", "persistentvolumeclaims")).To(BeEmpty())
+			})
+		})
+
+		It("creates the claim in the namespace of the context", func() {
+			diskCID, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(diskCID).To(Equal(cpi.NewDiskCID("bosh", "", "disk-guid")))
+			Expect(fakeClient.MatchingActions("create", "namespaces")).To(BeEmpty())
+		})
+	})
+
+	Context("when the request is cancelled while waiting for the claim to be bound", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("get", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, &v1.PersistentVolumeClaim{Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimPending}}, nil
+			})
+		})
+
+		It("deletes the claim and returns an error", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := diskCreator.CreateDisk(ctx, 1000, cloudProps, vmcid)
+			Expect(err).To(MatchError(`Persistent volume claim "disk-disk-guid" was not bound: context canceled`))
+			Expect(fakeClient.MatchingActions("delete", "persistentvolumeclaims")).To(HaveLen(1))
+		})
+	})
+
 	Context("when getting the client fails", func() {
 		BeforeEach(func() {
 			fakeProvider.NewReturns(nil, errors.New("boom"))
 		})
 
 		It("gets a client for the appropriate context", func() {
-			_, err := diskCreator.CreateDisk(1000, cloudProps, vmcid)
+			_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
 			Expect(err).To(MatchError("boom"))
 		})
 	})
@@ -104,9 +352,33 @@ var _ = Describe("CreateDisk", func() {
 		})
 
 		It("returns an error", func() {
-			_, err := diskCreator.CreateDisk(1000, cloudProps, vmcid)
+			_, err := diskCreator.CreateDisk(context.Background(), 1000, cloudProps, vmcid)
 			Expect(err).To(MatchError("create-pvc-welp"))
 			Expect(fakeClient.MatchingActions("create", "persistentvolumeclaims")).To(HaveLen(1))
 		})
 	})
 })
+
+var _ = Describe("CreateDiskCloudProperties", func() {
+	It("accepts the supported volume modes", func() {
+		Expect(actions.CreateDiskCloudProperties{}.Validate()).To(Succeed())
+		Expect(actions.CreateDiskCloudProperties{VolumeMode: "Block"}.Validate()).To(Succeed())
+		Expect(actions.CreateDiskCloudProperties{VolumeMode: "Filesystem"}.Validate()).To(Succeed())
+	})
+
+	It("rejects an unsupported volume mode", func() {
+		err := actions.CreateDiskCloudProperties{VolumeMode: "Tape"}.Validate()
+		Expect(err).To(MatchError(`volume_mode: "Tape" is not one of Filesystem, Block`))
+	})
+
+	It("rejects an invalid namespace", func() {
+		err := actions.CreateDiskCloudProperties{Namespace: "-ns"}.Validate()
+		Expect(err).To(MatchError(ContainSubstring(`namespace: "-ns" is not a valid namespace`)))
+	})
+
+	It("rejects unknown fields when dispatched", func() {
+		req := &cpi.Request{Args: []interface{}{1000, map[string]interface{}{"context": "bosh", "storage_class": "fast"}, ""}}
+		_, err := cpi.Dispatch(req, (&actions.DiskCreator{}).CreateDisk)
+		Expect(err).To(MatchError("Invalid argument 1: storage_class: unknown field"))
+	})
+})
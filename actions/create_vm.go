@@ -1,10 +1,12 @@
 package actions
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"time"
+	"sort"
+	"strings"
 
 	"github.com/evoila/kubernetes-cpi/agent"
 	"github.com/evoila/kubernetes-cpi/config"
@@ -15,17 +17,24 @@ import (
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	core "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
 type VMCreator struct {
 	AgentConfig    *config.Agent
 	ClientProvider kubecluster.ClientProvider
+	Events         *EventRecorder
+	Namespaces     *config.Namespaces
+
+	// ReadableNames names the objects of VMs after their deployment and
+	// instance group instead of their agent ID.
+	ReadableNames bool
 }
 
 type Service struct {
 	Name      string `json:"name"`
-	Type      string `json:"type"`
+	Type      string `json:"type" enum:"ClusterIP,NodePort"`
 	ClusterIP string `json:"cluster_ip"`
 	Ports     []Port `json:"ports"`
 }
@@ -34,7 +43,7 @@ type Port struct {
 	Name     string `json:"name"`
 	NodePort int32  `json:"node_port"`
 	Port     int32  `json:"port"`
-	Protocol string `json:"protocol"`
+	Protocol string `json:"protocol" enum:"TCP,UDP"`
 }
 
 type ResourceName string
@@ -51,13 +60,119 @@ type Resources struct {
 	Requests ResourceList `json:"requests"`
 }
 
+const (
+	DefaultEphemeralDiskSize = "5Gi"
+	DefaultImagePullPolicy   = v1.PullAlways
+)
+
+var DefaultCommand = []string{"/usr/sbin/runsvdir-start"}
+
 type VMCloudProperties struct {
 	Context   string    `json:"context"`
+	Namespace string    `json:"namespace,omitempty"`
+	Profile   string    `json:"profile,omitempty"`
 	Services  []Service `json:"services,omitempty"`
 	Resources Resources `json:"resources,omitempty"`
+
+	EphemeralDiskSize string   `json:"ephemeral_disk_size,omitempty"`
+	ImagePullPolicy   string   `json:"image_pull_policy,omitempty" enum:"Always,IfNotPresent,Never"`
+	Command           []string `json:"command,omitempty"`
+
+	// DisableAntiAffinity stops the pods of an instance group from
+	// preferring to run on different nodes.
+	DisableAntiAffinity bool `json:"disable_anti_affinity,omitempty"`
+}
+
+// StrictFields rejects VM cloud properties the CPI does not know about.
+func (VMCloudProperties) StrictFields() {}
+
+// Validate checks the VM cloud properties before any Kubernetes objects are
+// created.
+func (c VMCloudProperties) Validate() error {
+	if c.Namespace != "" {
+		if errs := validation.IsDNS1123Label(c.Namespace); len(errs) != 0 {
+			return cpi.NewFieldError("namespace", "%q is not a valid namespace: %s", c.Namespace, strings.Join(errs, ", "))
+		}
+	}
+
+	for i, svc := range c.Services {
+		err := svc.validate(fmt.Sprintf("services[%d]", i))
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.Resources.Limits.validate("resources.limits")
+	if err != nil {
+		return err
+	}
+
+	err = c.Resources.Requests.validate("resources.requests")
+	if err != nil {
+		return err
+	}
+
+	if c.EphemeralDiskSize != "" {
+		if _, err := resource.ParseQuantity(c.EphemeralDiskSize); err != nil {
+			return cpi.NewFieldError("ephemeral_disk_size", "%q is not a valid quantity", c.EphemeralDiskSize)
+		}
+	}
+
+	if err := cpi.CheckEnum(c, "ImagePullPolicy"); err != nil {
+		return &cpi.FieldError{Path: "image_pull_policy", Err: err}
+	}
+
+	return nil
+}
+
+func (s Service) validate(path string) error {
+	if errs := validation.IsDNS1035Label(s.Name); len(errs) != 0 {
+		return cpi.NewFieldError(cpi.JoinPath(path, "name"), "%q is not a valid service name: %s", s.Name, strings.Join(errs, ", "))
+	}
+
+	if err := cpi.CheckEnum(s, "Type"); err != nil {
+		return &cpi.FieldError{Path: cpi.JoinPath(path, "type"), Err: err}
+	}
+
+	for i, port := range s.Ports {
+		portPath := fmt.Sprintf("%s.ports[%d]", path, i)
+		if port.Port < 1 || port.Port > 65535 {
+			return cpi.NewFieldError(cpi.JoinPath(portPath, "port"), "%d is not a valid port", port.Port)
+		}
+		if port.NodePort < 0 || port.NodePort > 65535 {
+			return cpi.NewFieldError(cpi.JoinPath(portPath, "node_port"), "%d is not a valid port", port.NodePort)
+		}
+
+		if err := cpi.CheckEnum(port, "Protocol"); err != nil {
+			return &cpi.FieldError{Path: cpi.JoinPath(portPath, "protocol"), Err: err}
+		}
+	}
+
+	return nil
+}
+
+func (r ResourceList) validate(path string) error {
+	var names []string
+	for name := range r {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := kubeResourceName(ResourceName(name)); err != nil {
+			return &cpi.FieldError{Path: cpi.JoinPath(path, name), Err: err}
+		}
+		value := r[ResourceName(name)]
+		if _, err := resource.ParseQuantity(value); err != nil {
+			return cpi.NewFieldError(cpi.JoinPath(path, name), "%q is not a valid quantity", value)
+		}
+	}
+
+	return nil
 }
 
 func (v *VMCreator) Create(
+	ctx context.Context,
 	agentID string,
 	stemcellCID cpi.StemcellCID,
 	cloudProps VMCloudProperties,
@@ -72,46 +187,93 @@ func (v *VMCreator) Create(
 		return "", err
 	}
 
+	namespace, err := vmNamespace(v.Namespaces, cloudProps, env)
+	if err != nil {
+		return "", err
+	}
+
 	// create the client set
-	client, err := v.ClientProvider.New(cloudProps.Context)
+	client, err := newClient(ctx, v.ClientProvider, cloudProps.Context, namespace)
 	if err != nil {
 		return "", err
 	}
 
-	// create the target namespace if it doesn't already exist
-	err = createNamespace(client.Core(), client.Namespace())
+	vm := vmObjects{AgentID: agentID}
+	if v.ReadableNames {
+		vm.Name = readableName(env, agentID)
+	}
+
+	err = v.create(ctx, client, vm, stemcellCID, cloudProps, *network, networks, env)
 	if err != nil {
+		v.cleanup(client, vm)
 		return "", err
 	}
 
+	return vmCIDFor(client, vm), nil
+}
+
+func (v *VMCreator) create(
+	ctx context.Context,
+	client kubecluster.Client,
+	vm vmObjects,
+	stemcellCID cpi.StemcellCID,
+	cloudProps VMCloudProperties,
+	network cpi.Network,
+	networks cpi.Networks,
+	env cpi.Environment,
+) error {
+	// create the target namespace if it doesn't already exist
+	err := createNamespace(ctx, client.Core(), client.Namespace(), v.Namespaces)
+	if err != nil {
+		return err
+	}
+
 	// NOTE: This is a workaround for the fake Clientset. This should be
 	// removed once https://github.com/kubernetes/client-go/issues/48 is
 	// resolved.
 	ns := client.Namespace()
-	instanceSettings, err := v.InstanceSettings(agentID, networks, env)
+	instanceSettings, err := v.InstanceSettings(vm.AgentID, networks, env)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	// create the config map
-	_, err = createConfigMap(client.ConfigMaps(), ns, agentID, instanceSettings)
+	// create the secret holding the agent settings
+	_, span := startStep(ctx, "create_settings")
+	_, err = createSettingsSecret(ctx, client.Secrets(), ns, vm, instanceSettings)
+	endStep(span, err)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	// create the service
-	err = createServices(client.Services(), ns, agentID, cloudProps.Services)
+	_, span = startStep(ctx, "create_services")
+	err = createServices(ctx, client.Services(), ns, vm.AgentID, cloudProps.Services)
+	endStep(span, err)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	// create the pod
-	_, err = createPod(client, ns, agentID, string(stemcellCID), *network, cloudProps.Resources)
+	podCtx, span := startStep(ctx, "create_pod")
+	pod, err := createPod(podCtx, client, ns, vm, string(stemcellCID), network, cloudProps, env)
+	endStep(span, err)
 	if err != nil {
-		return "", err
+		return err
+	}
+
+	v.Events.Record(client, objectReference("Pod", pod.ObjectMeta), EventReasonCreated, "Created VM %s", vmCIDFor(client, vm))
+	return nil
+}
+
+// cleanup removes the objects of a VM that could not be created. The
+// request context may already be cancelled so a fresh client is used.
+func (v *VMCreator) cleanup(requestClient kubecluster.Client, vm vmObjects) {
+	client, err := newClient(context.Background(), v.ClientProvider, requestClient.Context(), kubecluster.ExplicitNamespace(requestClient))
+	if err != nil {
+		return
 	}
 
-	return NewVMCID(client.Context(), agentID), nil
+	deleteVM(client, vm)
 }
 
 func getNetwork(networks cpi.Networks) (*cpi.Network, error) {
@@ -155,48 +317,35 @@ func (v *VMCreator) InstanceSettings(agentID string, networks cpi.Networks, env
 	return settings, nil
 }
 
-func createNamespace(coreClient core.CoreV1Interface, namespace string) error {
-	_, err := coreClient.Namespaces().Get(namespace, metav1.GetOptions{})
-	if err == nil {
-		return nil
-	}
-
-	_, err = coreClient.Namespaces().Create(&v1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{Name: namespace},
-	})
-	if err == nil {
-		return nil
-	}
-
-	if statusError, ok := err.(*kubeerrors.StatusError); ok {
-		if statusError.Status().Reason == metav1.StatusReasonAlreadyExists {
-			return nil
-		}
-	}
-	return err
-}
-
-func createConfigMap(configMapService core.ConfigMapInterface, ns, agentID string, instanceSettings *agent.Settings) (*v1.ConfigMap, error) {
+// createSettingsSecret stores the agent settings in a secret. The settings
+// carry the blobstore and message bus credentials so they must not be kept
+// in a ConfigMap.
+func createSettingsSecret(ctx context.Context, secretService core.SecretInterface, ns string, vm vmObjects, instanceSettings *agent.Settings) (*v1.Secret, error) {
 	instanceJSON, err := json.Marshal(instanceSettings)
 	if err != nil {
 		return nil, err
 	}
 
-	return configMapService.Create(&v1.ConfigMap{
+	return secretService.Create(newSettingsSecret(ctx, ns, vm, instanceJSON))
+}
+
+func newSettingsSecret(ctx context.Context, ns string, vm vmObjects, instanceJSON []byte) *v1.Secret {
+	return &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "agent-" + agentID,
+			Name:      vm.Settings(),
 			Namespace: ns,
-			Labels: map[string]string{
-				"bosh.cloudfoundry.org/agent-id": agentID,
-			},
+			Labels: directorLabels(ctx, map[string]string{
+				"bosh.cloudfoundry.org/agent-id": vm.AgentID,
+			}),
 		},
-		Data: map[string]string{
-			"instance_settings": string(instanceJSON),
+		Type: v1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			"instance_settings": instanceJSON,
 		},
-	})
+	}
 }
 
-func createServices(serviceClient core.ServiceInterface, ns, agentID string, services []Service) error {
+func createServices(ctx context.Context, serviceClient core.ServiceInterface, ns, agentID string, services []Service) error {
 	for _, svc := range services {
 		serviceType := v1.ServiceTypeClusterIP
 		if svc.Type == "NodePort" {
@@ -218,9 +367,9 @@ func createServices(serviceClient core.ServiceInterface, ns, agentID string, ser
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      svc.Name,
 				Namespace: ns,
-				Labels: map[string]string{
+				Labels: directorLabels(ctx, map[string]string{
 					"bosh.cloudfoundry.org/agent-id": agentID,
-				},
+				}),
 			},
 			Spec: v1.ServiceSpec{
 				Type:      serviceType,
@@ -241,7 +390,7 @@ func createServices(serviceClient core.ServiceInterface, ns, agentID string, ser
 	return nil
 }
 
-func createPod(client kubecluster.Client, ns, agentID, image string, network cpi.Network, resources Resources) (*v1.Pod, error) {
+func createPod(ctx context.Context, client kubecluster.Client, ns string, vm vmObjects, image string, network cpi.Network, cloudProps VMCloudProperties, env cpi.Environment) (*v1.Pod, error) {
 	podClient := client.Pods()
 	trueValue := true
 	rootUID := int64(0)
@@ -251,32 +400,54 @@ func createPod(client kubecluster.Client, ns, agentID, image string, network cpi
 		annotations["bosh.cloudfoundry.org/ip-address"] = network.IP
 	}
 
-	resourceReqs, err := getPodResourceRequirements(resources)
+	resourceReqs, err := getPodResourceRequirements(cloudProps.Resources)
 	if err != nil {
 		return nil, err
 	}
 
-	volumeName, err := createVarVcapVolume(agentID, client)
+	pullPolicy := v1.PullPolicy(cloudProps.ImagePullPolicy)
+	if pullPolicy == "" {
+		pullPolicy = DefaultImagePullPolicy
+	}
+
+	command := cloudProps.Command
+	if len(command) == 0 {
+		command = DefaultCommand
+	}
+
+	ephemeralDiskSize := cloudProps.EphemeralDiskSize
+	if ephemeralDiskSize == "" {
+		ephemeralDiskSize = DefaultEphemeralDiskSize
+	}
+
+	labels := instanceLabels(env, vm)
+	labels["bosh.cloudfoundry.org/agent-id"] = vm.AgentID
+
+	var affinity *v1.Affinity
+	if !cloudProps.DisableAntiAffinity {
+		affinity = instanceGroupAntiAffinity(labels)
+	}
+
+	volumeName, err := createVarVcapVolume(ctx, vm, ephemeralDiskSize, client)
 	if err != nil {
 		return nil, err
 	}
 
 	return podClient.Create(&v1.Pod{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        "agent-" + agentID,
+			Name:        vm.Pod(),
 			Namespace:   ns,
 			Annotations: annotations,
-			Labels: map[string]string{
-				"bosh.cloudfoundry.org/agent-id": agentID,
-			},
+			Labels:      directorLabels(ctx, labels),
 		},
 		Spec: v1.PodSpec{
-			Hostname: agentID,
+			Hostname: vm.AgentID,
+			Affinity: affinity,
 			Containers: []v1.Container{{
 				Name:            "bosh-job",
 				Image:           image,
-				ImagePullPolicy: v1.PullAlways,
-				Command:         []string{"/usr/sbin/runsvdir-start"},
+				ImagePullPolicy: pullPolicy,
+				Command:         command,
 				Args:            []string{},
 				Resources:       resourceReqs,
 				SecurityContext: &v1.SecurityContext{
@@ -294,18 +465,8 @@ func createPod(client kubecluster.Client, ns, agentID, image string, network cpi
 				}},
 			}},
 			Volumes: []v1.Volume{{
-				Name: "bosh-config",
-				VolumeSource: v1.VolumeSource{
-					ConfigMap: &v1.ConfigMapVolumeSource{
-						LocalObjectReference: v1.LocalObjectReference{
-							Name: "agent-" + agentID,
-						},
-						Items: []v1.KeyToPath{{
-							Key:  "instance_settings",
-							Path: "instance_settings.json",
-						}},
-					},
-				},
+				Name:         "bosh-config",
+				VolumeSource: settingsVolumeSource(vm),
 			}, {
 				Name: "var-vcap",
 				VolumeSource: v1.VolumeSource{
@@ -318,18 +479,30 @@ func createPod(client kubecluster.Client, ns, agentID, image string, network cpi
 	})
 }
 
-func createVarVcapVolume(agentID string, client kubecluster.Client) (string, error) {
-	volumeSize, err := resource.ParseQuantity("5Gi")
+func settingsVolumeSource(vm vmObjects) v1.VolumeSource {
+	return v1.VolumeSource{
+		Secret: &v1.SecretVolumeSource{
+			SecretName: vm.Settings(),
+			Items: []v1.KeyToPath{{
+				Key:  "instance_settings",
+				Path: "instance_settings.json",
+			}},
+		},
+	}
+}
+
+func createVarVcapVolume(ctx context.Context, vm vmObjects, size string, client kubecluster.Client) (string, error) {
+	volumeSize, err := resource.ParseQuantity(size)
 	if err != nil {
 		return "", err
 	}
 	_, err = client.PersistentVolumeClaims().Create(&v1.PersistentVolumeClaim{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      "var-vcap-" + agentID,
+			Name:      vm.VarVcap(),
 			Namespace: client.Namespace(),
-			Labels: map[string]string{
-				"bosh.cloudfoundry.org/var-vcap-id": agentID,
-			},
+			Labels: directorLabels(ctx, map[string]string{
+				"bosh.cloudfoundry.org/var-vcap-id": vm.AgentID,
+			}),
 		},
 		Spec: v1.PersistentVolumeClaimSpec{
 			AccessModes: []v1.PersistentVolumeAccessMode{v1.ReadWriteOnce},
@@ -340,20 +513,20 @@ func createVarVcapVolume(agentID string, client kubecluster.Client) (string, err
 			},
 		},
 	})
-
-	volume, err := client.PersistentVolumeClaims().Get("var-vcap-"+agentID, metav1.GetOptions{})
+	// A retried create_vm finds the claim of the previous attempt.
+	if statusError, ok := err.(*kubeerrors.StatusError); ok && statusError.Status().Reason == metav1.StatusReasonAlreadyExists {
+		err = nil
+	}
 	if err != nil {
 		return "", err
 	}
-	for bound := volume.Status.Phase; bound != "Bound"; bound = volume.Status.Phase {
-		time.Sleep(1 * time.Second)
-		volume, err = client.PersistentVolumeClaims().Get("var-vcap-"+agentID, metav1.GetOptions{})
-		if err != nil {
-			return "", err
-		}
+
+	_, err = waitForClaimBound(ctx, client, vm.VarVcap())
+	if err != nil {
+		return "", err
 	}
 
-	return "var-vcap-" + agentID, err
+	return vm.VarVcap(), nil
 }
 
 func getPodResourceRequirements(resources Resources) (v1.ResourceRequirements, error) {
@@ -1,6 +1,7 @@
 package actions_test
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 
@@ -35,8 +36,18 @@ var _ = Describe("CreateVM", func() {
 		vmCreator *actions.VMCreator
 	)
 
+	// Claims are bound as soon as they are created.
+	bindClaims := func(client *fakes.Client) {
+		client.PrependReactor("create", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+			claim := action.(testing.CreateAction).GetObject().(*v1.PersistentVolumeClaim)
+			claim.Status.Phase = v1.ClaimBound
+			return false, nil, nil
+		})
+	}
+
 	BeforeEach(func() {
 		fakeClient = fakes.NewClient()
+		bindClaims(fakeClient)
 		fakeClient.ContextReturns("bosh")
 		fakeClient.NamespaceReturns("bosh-namespace")
 
@@ -81,17 +92,18 @@ var _ = Describe("CreateVM", func() {
 		})
 
 		It("returns a VM Cloud ID", func() {
-			vmcid, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+			vmcid, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 			Expect(err).NotTo(HaveOccurred())
-			Expect(vmcid).To(Equal(actions.NewVMCID("bosh", agentID)))
+			Expect(vmcid).To(Equal(cpi.NewVMCID("bosh", "", agentID)))
 		})
 
 		It("gets a client with the context from the cloud properties", func() {
-			_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+			_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(fakeProvider.NewCallCount()).To(Equal(1))
-			Expect(fakeProvider.NewArgsForCall(0)).To(Equal("bosh"))
+			_, contextName := fakeProvider.NewArgsForCall(0)
+			Expect(contextName).To(Equal("bosh"))
 		})
 
 		Context("when getting the client fails", func() {
@@ -100,13 +112,13 @@ var _ = Describe("CreateVM", func() {
 			})
 
 			It("gets a client for the appropriate context", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 				Expect(err).To(MatchError("boom"))
 			})
 		})
 
 		It("creates the target namespace", func() {
-			_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+			_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "namespaces")
@@ -121,13 +133,14 @@ var _ = Describe("CreateVM", func() {
 				fakeClient = fakes.NewClient(
 					&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bosh-namespace"}},
 				)
+				bindClaims(fakeClient)
 				fakeClient.ContextReturns("bosh")
 				fakeClient.NamespaceReturns("bosh-namespace")
 				fakeProvider.NewReturns(fakeClient, nil)
 			})
 
 			It("skips namespace creation", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 				Expect(err).NotTo(HaveOccurred())
 
 				Expect(fakeClient.MatchingActions("get", "namespaces")).To(HaveLen(1))
@@ -144,7 +157,7 @@ var _ = Describe("CreateVM", func() {
 			})
 
 			It("keeps calm and carries on", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 				Expect(err).NotTo(HaveOccurred())
 
 				Expect(fakeClient.MatchingActions("get", "namespaces")).To(HaveLen(1))
@@ -160,19 +173,349 @@ var _ = Describe("CreateVM", func() {
 			})
 
 			It("returns an error", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 				Expect(err).To(MatchError("namespace-welp"))
 				Expect(fakeClient.MatchingActions("create", "namespaces")).To(HaveLen(1))
 			})
 		})
 
+		Context("when the environment names the deployment and instance group", func() {
+			BeforeEach(func() {
+				env = cpi.Environment{
+					"bosh": map[string]interface{}{
+						"group":  "director-cf-database",
+						"groups": []interface{}{"director", "cf", "database"},
+					},
+				}
+			})
+
+			It("labels the pod with its deployment and instance group", func() {
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				Expect(err).NotTo(HaveOccurred())
+
+				pod := fakeClient.MatchingActions("create", "pods")[0].(testing.CreateAction).GetObject().(*v1.Pod)
+				Expect(pod.Labels).To(Equal(map[string]string{
+					"bosh.cloudfoundry.org/agent-id":       agentID,
+					"bosh.cloudfoundry.org/deployment":     "cf",
+					"bosh.cloudfoundry.org/instance-group": "database",
+					"app.kubernetes.io/name":               "database",
+					"app.kubernetes.io/instance":           "agent-" + agentID,
+					"app.kubernetes.io/part-of":            "cf",
+					"app.kubernetes.io/managed-by":         "bosh-kubernetes-cpi",
+				}))
+			})
+
+			It("prefers to spread the instance group across nodes", func() {
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				Expect(err).NotTo(HaveOccurred())
+
+				pod := fakeClient.MatchingActions("create", "pods")[0].(testing.CreateAction).GetObject().(*v1.Pod)
+				Expect(pod.Spec.Affinity).To(Equal(&v1.Affinity{
+					PodAntiAffinity: &v1.PodAntiAffinity{
+						PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{{
+							Weight: 100,
+							PodAffinityTerm: v1.PodAffinityTerm{
+								LabelSelector: &metav1.LabelSelector{
+									MatchLabels: map[string]string{
+										"bosh.cloudfoundry.org/deployment":     "cf",
+										"bosh.cloudfoundry.org/instance-group": "database",
+									},
+								},
+								TopologyKey: "kubernetes.io/hostname",
+							},
+						}},
+					},
+				}))
+			})
+
+			Context("when anti-affinity is disabled in the cloud properties", func() {
+				BeforeEach(func() {
+					cloudProps.DisableAntiAffinity = true
+				})
+
+				It("leaves scheduling alone", func() {
+					_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					Expect(err).NotTo(HaveOccurred())
+
+					pod := fakeClient.MatchingActions("create", "pods")[0].(testing.CreateAction).GetObject().(*v1.Pod)
+					Expect(pod.Spec.Affinity).To(BeNil())
+					Expect(pod.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/instance-group", "database"))
+				})
+			})
+
+			Context("when a name is not a valid label value", func() {
+				BeforeEach(func() {
+					env["bosh"].(map[string]interface{})["groups"] = []interface{}{"director", "cf", "-database"}
+				})
+
+				It("leaves out its labels and the anti-affinity", func() {
+					_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					Expect(err).NotTo(HaveOccurred())
+
+					pod := fakeClient.MatchingActions("create", "pods")[0].(testing.CreateAction).GetObject().(*v1.Pod)
+					Expect(pod.Labels).NotTo(HaveKey("bosh.cloudfoundry.org/instance-group"))
+					Expect(pod.Labels).NotTo(HaveKey("app.kubernetes.io/name"))
+					Expect(pod.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/deployment", "cf"))
+					Expect(pod.Spec.Affinity).To(BeNil())
+				})
+			})
+		})
+
+		Context("when readable names are enabled", func() {
+			BeforeEach(func() {
+				vmCreator.ReadableNames = true
+				agentID = "1A2B3C4D-5e6f-7890-abcd-ef0123456789"
+				env = cpi.Environment{
+					"bosh": map[string]interface{}{
+						"group":  "director-cf-database",
+						"groups": []interface{}{"director", "cf", "Database_Z1"},
+					},
+				}
+			})
+
+			It("names the objects of the VM after its deployment and instance group", func() {
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				Expect(err).NotTo(HaveOccurred())
+
+				pod := fakeClient.MatchingActions("create", "pods")[0].(testing.CreateAction).GetObject().(*v1.Pod)
+				Expect(pod.Name).To(Equal("cf-database-z1-1a2b3c4d"))
+				Expect(pod.Spec.Hostname).To(Equal(agentID))
+				Expect(pod.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/agent-id", agentID))
+
+				secret := fakeClient.MatchingActions("create", "secrets")[0].(testing.CreateAction).GetObject().(*v1.Secret)
+				Expect(secret.Name).To(Equal("cf-database-z1-1a2b3c4d"))
+
+				claim := fakeClient.MatchingActions("create", "persistentvolumeclaims")[0].(testing.CreateAction).GetObject().(*v1.PersistentVolumeClaim)
+				Expect(claim.Name).To(Equal("cf-database-z1-1a2b3c4d-var-vcap"))
+			})
+
+			It("records the name in the VM CID", func() {
+				vmcid, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				Expect(err).NotTo(HaveOccurred())
+
+				cid, err := vmcid.Parse()
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cid.ID).To(Equal(agentID))
+				Expect(cid.Name).To(Equal("cf-database-z1-1a2b3c4d"))
+			})
+
+			Context("when the environment does not name the instance group", func() {
+				BeforeEach(func() {
+					env = cpi.Environment{}
+				})
+
+				It("names the objects after the agent ID", func() {
+					vmcid, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(vmcid).To(Equal(cpi.NewVMCID("bosh", "", agentID)))
+
+					pod := fakeClient.MatchingActions("create", "pods")[0].(testing.CreateAction).GetObject().(*v1.Pod)
+					Expect(pod.Name).To(Equal("agent-" + agentID))
+				})
+			})
+		})
+
+		Context("when the VM is placed in a namespace of its own", func() {
+			BeforeEach(func() {
+				env = cpi.Environment{
+					"bosh": map[string]interface{}{
+						"group":  "director-My_Deployment-web",
+						"groups": []interface{}{"director", "My_Deployment", "web"},
+					},
+				}
+			})
+
+			Context("when the cloud properties name the namespace", func() {
+				BeforeEach(func() {
+					cloudProps.Namespace = "deployment-ns"
+					vmCreator.Namespaces = &config.Namespaces{Template: "bosh-{{.Deployment}}"}
+				})
+
+				It("encodes the namespace in the VM CID", func() {
+					vmcid, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(vmcid).To(Equal(cpi.NewVMCID("bosh", "deployment-ns", agentID)))
+
+					cid, err := vmcid.Parse()
+					Expect(err).NotTo(HaveOccurred())
+					Expect(cid.Context).To(Equal("bosh"))
+					Expect(cid.Namespace).To(Equal("deployment-ns"))
+					Expect(cid.ID).To(Equal(agentID))
+				})
+
+				It("creates the namespace and the VM objects in it", func() {
+					_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					Expect(err).NotTo(HaveOccurred())
+
+					matches := fakeClient.MatchingActions("create", "namespaces")
+					Expect(matches).To(HaveLen(1))
+					Expect(matches[0].(testing.CreateAction).GetObject().(*v1.Namespace).Name).To(Equal("deployment-ns"))
+
+					for _, resource := range []string{"secrets", "persistentvolumeclaims", "pods"} {
+						matches := fakeClient.MatchingActions("create", resource)
+						Expect(matches).To(HaveLen(1))
+						Expect(matches[0].GetNamespace()).To(Equal("deployment-ns"))
+					}
+				})
+			})
+
+			Context("when a namespace template is configured", func() {
+				BeforeEach(func() {
+					vmCreator.Namespaces = &config.Namespaces{Template: "bosh-{{.Deployment}}"}
+				})
+
+				It("renders the namespace from the bosh environment", func() {
+					vmcid, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(vmcid).To(Equal(cpi.NewVMCID("bosh", "bosh-my-deployment", agentID)))
+					Expect(fakeClient.MatchingActions("create", "pods")[0].GetNamespace()).To(Equal("bosh-my-deployment"))
+				})
+
+				Context("when the template does not render a namespace", func() {
+					BeforeEach(func() {
+						vmCreator.Namespaces.Template = "{{.InstanceGroup}}"
+						env = cpi.Environment{}
+					})
+
+					It("returns an error before creating anything", func() {
+						_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+						Expect(err).To(MatchError(ContainSubstring(`namespace template rendered "", which is not a valid namespace`)))
+						Expect(fakeProvider.NewCallCount()).To(Equal(0))
+					})
+				})
+			})
+
+			Context("when the namespace policy is configured", func() {
+				BeforeEach(func() {
+					vmCreator.Namespaces = &config.Namespaces{
+						Template:      "bosh-{{.Deployment}}",
+						Labels:        map[string]string{"team": "bosh"},
+						PodSecurity:   "privileged",
+						ResourceQuota: map[string]string{"requests.cpu": "8", "pods": "20"},
+						LimitRange: config.LimitRange{
+							Default:        map[string]string{"memory": "1Gi"},
+							DefaultRequest: map[string]string{"cpu": "100m"},
+						},
+					}
+				})
+
+				It("creates the namespace with its labels, quota and limit range", func() {
+					_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					Expect(err).NotTo(HaveOccurred())
+
+					namespace := fakeClient.MatchingActions("create", "namespaces")[0].(testing.CreateAction).GetObject().(*v1.Namespace)
+					Expect(namespace.Labels).To(Equal(map[string]string{
+						"team":                          "bosh",
+						actions.PodSecurityEnforceLabel: "privileged",
+						actions.ManagedByLabel:          actions.EventComponent,
+					}))
+
+					quotas := fakeClient.MatchingActions("create", "resourcequotas")
+					Expect(quotas).To(HaveLen(1))
+					quota := quotas[0].(testing.CreateAction).GetObject().(*v1.ResourceQuota)
+					Expect(quota.Name).To(Equal(actions.NamespacePolicyName))
+					Expect(quota.Namespace).To(Equal("bosh-my-deployment"))
+					Expect(quota.Spec.Hard).To(Equal(v1.ResourceList{
+						v1.ResourceRequestsCPU: resource.MustParse("8"),
+						v1.ResourcePods:        resource.MustParse("20"),
+					}))
+
+					limits := fakeClient.MatchingActions("create", "limitranges")
+					Expect(limits).To(HaveLen(1))
+					limitRange := limits[0].(testing.CreateAction).GetObject().(*v1.LimitRange)
+					Expect(limitRange.Namespace).To(Equal("bosh-my-deployment"))
+					Expect(limitRange.Spec.Limits).To(Equal([]v1.LimitRangeItem{{
+						Type:           v1.LimitTypeContainer,
+						Default:        v1.ResourceList{v1.ResourceMemory: resource.MustParse("1Gi")},
+						DefaultRequest: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")},
+					}}))
+				})
+
+				It("labels the namespace, quota and limit range with the director UUID", func() {
+					ctx := cpi.NewContext(context.Background(), cpi.Context{DirectorUUID: "director-uuid"})
+					_, err := vmCreator.Create(ctx, agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					Expect(err).NotTo(HaveOccurred())
+
+					namespace := fakeClient.MatchingActions("create", "namespaces")[0].(testing.CreateAction).GetObject().(*v1.Namespace)
+					Expect(namespace.Labels).To(HaveKeyWithValue(actions.DirectorUUIDLabel, "director-uuid"))
+
+					quota := fakeClient.MatchingActions("create", "resourcequotas")[0].(testing.CreateAction).GetObject().(*v1.ResourceQuota)
+					Expect(quota.Labels).To(HaveKeyWithValue(actions.DirectorUUIDLabel, "director-uuid"))
+
+					limitRange := fakeClient.MatchingActions("create", "limitranges")[0].(testing.CreateAction).GetObject().(*v1.LimitRange)
+					Expect(limitRange.Labels).To(HaveKeyWithValue(actions.DirectorUUIDLabel, "director-uuid"))
+				})
+
+				Context("when the namespace already exists", func() {
+					BeforeEach(func() {
+						_, err := fakeClient.Core().Namespaces().Create(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "bosh-my-deployment"}})
+						Expect(err).NotTo(HaveOccurred())
+						fakeClient.ClearActions()
+					})
+
+					It("leaves it alone", func() {
+						_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+						Expect(err).NotTo(HaveOccurred())
+
+						Expect(fakeClient.MatchingActions("create", "namespaces")).To(BeEmpty())
+						Expect(fakeClient.MatchingActions("create", "resourcequotas")).To(BeEmpty())
+						Expect(fakeClient.MatchingActions("create", "limitranges")).To(BeEmpty())
+					})
+				})
+
+				Context("when the CPI created the namespace without its policy", func() {
+					BeforeEach(func() {
+						_, err := fakeClient.Core().Namespaces().Create(&v1.Namespace{ObjectMeta: metav1.ObjectMeta{
+							Name:   "bosh-my-deployment",
+							Labels: map[string]string{actions.ManagedByLabel: actions.EventComponent},
+						}})
+						Expect(err).NotTo(HaveOccurred())
+						_, err = fakeClient.Core().ResourceQuotas("bosh-my-deployment").Create(&v1.ResourceQuota{
+							ObjectMeta: metav1.ObjectMeta{Name: actions.NamespacePolicyName, Namespace: "bosh-my-deployment"},
+							Spec:       v1.ResourceQuotaSpec{Hard: v1.ResourceList{v1.ResourcePods: resource.MustParse("5")}},
+						})
+						Expect(err).NotTo(HaveOccurred())
+						fakeClient.ClearActions()
+					})
+
+					It("creates the missing limit range and updates the quota", func() {
+						_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+						Expect(err).NotTo(HaveOccurred())
+
+						Expect(fakeClient.MatchingActions("create", "namespaces")).To(BeEmpty())
+						Expect(fakeClient.MatchingActions("create", "limitranges")).To(HaveLen(1))
+
+						quotas := fakeClient.MatchingActions("update", "resourcequotas")
+						Expect(quotas).To(HaveLen(1))
+						quota := quotas[0].(testing.UpdateAction).GetObject().(*v1.ResourceQuota)
+						Expect(quota.Spec.Hard).To(Equal(v1.ResourceList{
+							v1.ResourceRequestsCPU: resource.MustParse("8"),
+							v1.ResourcePods:        resource.MustParse("20"),
+						}))
+					})
+				})
+
+				Context("when a quantity is invalid", func() {
+					BeforeEach(func() {
+						vmCreator.Namespaces.ResourceQuota["pods"] = "many"
+					})
+
+					It("returns an error without creating the namespace", func() {
+						_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+						Expect(err).To(MatchError(`namespaces.resource_quota.pods: "many" is not a valid quantity`))
+						Expect(fakeClient.MatchingActions("create", "namespaces")).To(BeEmpty())
+					})
+				})
+			})
+		})
+
 		Context("when no networks are defined", func() {
 			BeforeEach(func() {
 				networks = cpi.Networks{}
 			})
 
 			It("returns an error", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 				Expect(err).To(MatchError("a network is required"))
 			})
 		})
@@ -202,16 +545,16 @@ var _ = Describe("CreateVM", func() {
 			})
 
 			It("returns an error", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 				Expect(err).To(MatchError("multiple networks not supported"))
 			})
 		})
 
-		It("creates the config map for agent settings", func() {
-			_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+		It("creates the secret for agent settings", func() {
+			_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 			Expect(err).NotTo(HaveOccurred())
 
-			matches := fakeClient.MatchingActions("create", "configmaps")
+			matches := fakeClient.MatchingActions("create", "secrets")
 			Expect(matches).To(HaveLen(1))
 
 			instanceSettings, err := vmCreator.InstanceSettings(agentID, networks, env)
@@ -219,23 +562,58 @@ var _ = Describe("CreateVM", func() {
 			instanceJSON, err := json.Marshal(instanceSettings)
 			Expect(err).NotTo(HaveOccurred())
 
-			configMap := matches[0].(testing.CreateAction).GetObject().(*v1.ConfigMap)
-			Expect(configMap.Name).To(Equal("agent-" + agentID))
-			Expect(configMap.Labels["bosh.cloudfoundry.org/agent-id"]).To(Equal(agentID))
-			Expect(configMap.Data["instance_settings"]).To(MatchJSON(instanceJSON))
+			secret := matches[0].(testing.CreateAction).GetObject().(*v1.Secret)
+			Expect(secret.Name).To(Equal("agent-" + agentID))
+			Expect(secret.Labels["bosh.cloudfoundry.org/agent-id"]).To(Equal(agentID))
+			Expect(secret.Data["instance_settings"]).To(MatchJSON(instanceJSON))
+			Expect(fakeClient.MatchingActions("create", "configmaps")).To(BeEmpty())
 		})
 
-		Context("when the config map create fails", func() {
+		Context("when the secret create fails", func() {
 			BeforeEach(func() {
-				fakeClient.PrependReactor("create", "configmaps", func(action testing.Action) (bool, runtime.Object, error) {
-					return true, nil, errors.New("configmap-welp")
+				fakeClient.PrependReactor("create", "secrets", func(action testing.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("secret-welp")
 				})
 			})
 
 			It("returns an error", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
-				Expect(err).To(MatchError("configmap-welp"))
-				Expect(fakeClient.MatchingActions("create", "configmaps")).To(HaveLen(1))
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				Expect(err).To(MatchError("secret-welp"))
+				Expect(fakeClient.MatchingActions("create", "secrets")).To(HaveLen(1))
+			})
+		})
+
+		Context("when the var/vcap claim create fails", func() {
+			BeforeEach(func() {
+				fakeClient.PrependReactor("create", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("claim-welp")
+				})
+			})
+
+			It("returns the error without waiting for the claim", func() {
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				Expect(err).To(MatchError("claim-welp"))
+				Expect(fakeClient.MatchingActions("get", "persistentvolumeclaims")).To(BeEmpty())
+				Expect(fakeClient.MatchingActions("create", "pods")).To(BeEmpty())
+			})
+		})
+
+		Context("when the var/vcap claim already exists", func() {
+			BeforeEach(func() {
+				fakeClient.PrependReactor("create", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+					claim := action.(testing.CreateAction).GetObject().(*v1.PersistentVolumeClaim)
+					gr := schema.GroupResource{Group: "", Resource: "persistentvolumeclaims"}
+					return true, nil, kubeerrors.NewAlreadyExists(gr, claim.Name)
+				})
+				fakeClient.PrependReactor("get", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+					return true, &v1.PersistentVolumeClaim{Status: v1.PersistentVolumeClaimStatus{Phase: v1.ClaimBound}}, nil
+				})
+			})
+
+			It("uses the existing claim", func() {
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(fakeClient.MatchingActions("create", "pods")).To(HaveLen(1))
 			})
 		})
 
@@ -261,7 +639,7 @@ var _ = Describe("CreateVM", func() {
 			})
 
 			It("creates the services", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 				Expect(err).NotTo(HaveOccurred())
 
 				matches := fakeClient.MatchingActions("create", "services")
@@ -296,7 +674,7 @@ var _ = Describe("CreateVM", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 					Expect(err).To(MatchError("service-welp"))
 					Expect(fakeClient.MatchingActions("create", "services")).To(HaveLen(1))
 				})
@@ -304,7 +682,7 @@ var _ = Describe("CreateVM", func() {
 		})
 
 		It("creates a pod", func() {
-			_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+			_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -334,8 +712,9 @@ var _ = Describe("CreateVM", func() {
 						MountPath: "/var/vcap/bosh/instance_settings.json",
 						SubPath:   "instance_settings.json",
 					}, {
-						Name:      "bosh-ephemeral",
-						MountPath: "/var/vcap/data",
+						Name:      "var-vcap",
+						MountPath: "/var/vcap",
+						SubPath:   "vcap",
 					}},
 				}))
 
@@ -343,10 +722,8 @@ var _ = Describe("CreateVM", func() {
 				v1.Volume{
 					Name: "bosh-config",
 					VolumeSource: v1.VolumeSource{
-						ConfigMap: &v1.ConfigMapVolumeSource{
-							LocalObjectReference: v1.LocalObjectReference{
-								Name: "agent-" + agentID,
-							},
+						Secret: &v1.SecretVolumeSource{
+							SecretName: "agent-" + agentID,
 							Items: []v1.KeyToPath{{
 								Key:  "instance_settings",
 								Path: "instance_settings.json",
@@ -355,13 +732,42 @@ var _ = Describe("CreateVM", func() {
 					},
 				},
 				v1.Volume{
-					Name: "bosh-ephemeral",
+					Name: "var-vcap",
 					VolumeSource: v1.VolumeSource{
-						EmptyDir: &v1.EmptyDirVolumeSource{},
+						PersistentVolumeClaim: &v1.PersistentVolumeClaimVolumeSource{
+							ClaimName: "var-vcap-" + agentID,
+						},
 					},
 				}))
 		})
 
+		Context("when the container settings are present in the cloud properties", func() {
+			BeforeEach(func() {
+				cloudProps.ImagePullPolicy = "IfNotPresent"
+				cloudProps.Command = []string{"/sbin/init"}
+				cloudProps.EphemeralDiskSize = "20Gi"
+			})
+
+			It("uses them in place of the defaults", func() {
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				Expect(err).NotTo(HaveOccurred())
+
+				matches := fakeClient.MatchingActions("create", "pods")
+				Expect(matches).To(HaveLen(1))
+
+				pod := matches[0].(testing.CreateAction).GetObject().(*v1.Pod)
+				Expect(pod.Spec.Containers[0].ImagePullPolicy).To(Equal(v1.PullIfNotPresent))
+				Expect(pod.Spec.Containers[0].Command).To(Equal([]string{"/sbin/init"}))
+
+				matches = fakeClient.MatchingActions("create", "persistentvolumeclaims")
+				Expect(matches).To(HaveLen(1))
+
+				pvc := matches[0].(testing.CreateAction).GetObject().(*v1.PersistentVolumeClaim)
+				Expect(pvc.Name).To(Equal("var-vcap-" + agentID))
+				Expect(pvc.Spec.Resources.Requests[v1.ResourceStorage]).To(Equal(resource.MustParse("20Gi")))
+			})
+		})
+
 		Context("when the network contains an IP", func() {
 			BeforeEach(func() {
 				networks = cpi.Networks{
@@ -380,7 +786,7 @@ var _ = Describe("CreateVM", func() {
 			})
 
 			It("annotates the pod with the IP address information", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 				Expect(err).NotTo(HaveOccurred())
 
 				matches := fakeClient.MatchingActions("create", "pods")
@@ -406,7 +812,7 @@ var _ = Describe("CreateVM", func() {
 			})
 
 			It("sets resource limts and requests on the Pod", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 				Expect(err).NotTo(HaveOccurred())
 
 				matches := fakeClient.MatchingActions("create", "pods")
@@ -434,7 +840,7 @@ var _ = Describe("CreateVM", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 					Expect(err).To(MatchError(ContainSubstring("quantities must match the regular expression")))
 				})
 			})
@@ -448,7 +854,7 @@ var _ = Describe("CreateVM", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 					Expect(err).To(MatchError(ContainSubstring("quantities must match the regular expression")))
 				})
 			})
@@ -461,7 +867,7 @@ var _ = Describe("CreateVM", func() {
 				})
 
 				It("returns an error", func() {
-					_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+					_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 					Expect(err).To(MatchError("goo is not a supported resource type"))
 				})
 			})
@@ -475,10 +881,19 @@ var _ = Describe("CreateVM", func() {
 			})
 
 			It("returns an error", func() {
-				_, err := vmCreator.Create(agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
 				Expect(err).To(MatchError("pods-welp"))
 				Expect(fakeClient.MatchingActions("create", "pods")).To(HaveLen(1))
 			})
+
+			It("removes the objects that were already created", func() {
+				_, err := vmCreator.Create(context.Background(), agentID, stemcellCID, cloudProps, networks, diskCIDs, env)
+				Expect(err).To(HaveOccurred())
+
+				Expect(fakeProvider.NewCallCount()).To(Equal(2))
+				Expect(fakeClient.MatchingActions("delete", "secrets")).To(HaveLen(1))
+				Expect(fakeClient.MatchingActions("delete", "persistentvolumeclaims")).To(HaveLen(1))
+			})
 		})
 	})
 
@@ -553,3 +968,101 @@ var _ = Describe("CreateVM", func() {
 		})
 	})
 })
+
+var _ = Describe("VMCloudProperties", func() {
+	var cloudProps actions.VMCloudProperties
+
+	BeforeEach(func() {
+		cloudProps = actions.VMCloudProperties{
+			Context: "bosh",
+			Services: []actions.Service{{
+				Name: "director",
+				Type: "NodePort",
+				Ports: []actions.Port{
+					{Name: "agent", Protocol: "TCP", Port: 6868, NodePort: 32068},
+				},
+			}},
+			Resources: actions.Resources{
+				Limits:   actions.ResourceList{actions.ResourceMemory: "1Gi"},
+				Requests: actions.ResourceList{actions.ResourceCPU: "500m"},
+			},
+			EphemeralDiskSize: "10Gi",
+			ImagePullPolicy:   "IfNotPresent",
+		}
+	})
+
+	It("accepts valid properties", func() {
+		Expect(cloudProps.Validate()).To(Succeed())
+	})
+
+	It("rejects invalid service names", func() {
+		cloudProps.Services[0].Name = "Director_1"
+		Expect(cloudProps.Validate()).To(MatchError(ContainSubstring(`services[0].name: "Director_1" is not a valid service name`)))
+	})
+
+	It("rejects unsupported service types", func() {
+		cloudProps.Services[0].Type = "LoadBalancer"
+		Expect(cloudProps.Validate()).To(MatchError(`services[0].type: "LoadBalancer" is not one of ClusterIP, NodePort`))
+	})
+
+	It("rejects invalid ports", func() {
+		cloudProps.Services[0].Ports[0].Port = 0
+		Expect(cloudProps.Validate()).To(MatchError("services[0].ports[0].port: 0 is not a valid port"))
+	})
+
+	It("rejects unsupported protocols", func() {
+		cloudProps.Services[0].Ports[0].Protocol = "SCTP"
+		Expect(cloudProps.Validate()).To(MatchError(`services[0].ports[0].protocol: "SCTP" is not one of TCP, UDP`))
+	})
+
+	It("rejects unsupported resources", func() {
+		cloudProps.Resources.Limits["gpu"] = "1"
+		Expect(cloudProps.Validate()).To(MatchError("resources.limits.gpu: gpu is not a supported resource type"))
+	})
+
+	It("rejects invalid quantities", func() {
+		cloudProps.Resources.Requests[actions.ResourceCPU] = "lots"
+		Expect(cloudProps.Validate()).To(MatchError(`resources.requests.cpu: "lots" is not a valid quantity`))
+	})
+
+	It("rejects an invalid ephemeral disk size", func() {
+		cloudProps.EphemeralDiskSize = "big"
+		Expect(cloudProps.Validate()).To(MatchError(`ephemeral_disk_size: "big" is not a valid quantity`))
+	})
+
+	It("rejects invalid namespaces", func() {
+		cloudProps.Namespace = "My_Namespace"
+		Expect(cloudProps.Validate()).To(MatchError(ContainSubstring(`namespace: "My_Namespace" is not a valid namespace`)))
+	})
+
+	It("rejects unsupported image pull policies", func() {
+		cloudProps.ImagePullPolicy = "Sometimes"
+		Expect(cloudProps.Validate()).To(MatchError(`image_pull_policy: "Sometimes" is not one of Always, IfNotPresent, Never`))
+	})
+
+	Context("when dispatched", func() {
+		var req *cpi.Request
+
+		BeforeEach(func() {
+			req = &cpi.Request{Args: []interface{}{
+				"agent-id",
+				"stemcell-id",
+				map[string]interface{}{
+					"context": "bosh",
+					"resources": map[string]interface{}{
+						"limits":  map[string]interface{}{"memory": "1Gi"},
+						"requets": map[string]interface{}{"cpu": "1"},
+					},
+				},
+				map[string]interface{}{},
+				[]interface{}{},
+				map[string]interface{}{},
+			}}
+		})
+
+		It("rejects unknown fields with their path", func() {
+			_, err := cpi.Dispatch(req, (&actions.VMCreator{}).Create)
+			Expect(err).To(MatchError("Invalid argument 2: resources.requets: unknown field"))
+		})
+	})
+})
@@ -1,22 +1,36 @@
 package actions
 
 import (
+	"context"
+
 	"github.com/evoila/kubernetes-cpi/cpi"
 	"github.com/evoila/kubernetes-cpi/kubecluster"
 
+	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type DiskDeleter struct {
 	ClientProvider kubecluster.ClientProvider
+	Events         *EventRecorder
 }
 
-func (d *DiskDeleter) DeleteDisk(diskCID cpi.DiskCID) error {
-	context, diskID := ParseDiskCID(diskCID)
-	client, err := d.ClientProvider.New(context)
+func (d *DiskDeleter) DeleteDisk(ctx context.Context, diskCID cpi.DiskCID) error {
+	cid, err := diskCID.Parse()
+	if err != nil {
+		return err
+	}
+	diskID := cid.ID
+	client, err := newClient(ctx, d.ClientProvider, cid.Context, cid.Namespace)
+	if err != nil {
+		return err
+	}
+
+	err = client.PersistentVolumeClaims().Delete("disk-"+diskID, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
 	if err != nil {
 		return err
 	}
 
-	return client.PersistentVolumeClaims().Delete("disk-"+diskID, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+	d.Events.Record(client, v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Name: "disk-" + diskID}, EventReasonDeleted, "Deleted disk %s", diskCID)
+	return nil
 }
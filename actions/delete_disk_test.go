@@ -1,6 +1,7 @@
 package actions_test
 
 import (
+	"context"
 	"errors"
 
 	"k8s.io/api/core/v1"
@@ -26,7 +27,7 @@ var _ = Describe("DeleteDisk", func() {
 	)
 
 	BeforeEach(func() {
-		diskCID = actions.NewDiskCID("bosh", "disk-id")
+		diskCID = cpi.NewDiskCID("bosh", "", "disk-id")
 
 		fakeClient = fakes.NewClient(&v1.PersistentVolumeClaim{
 			ObjectMeta: metav1.ObjectMeta{
@@ -55,15 +56,16 @@ var _ = Describe("DeleteDisk", func() {
 	})
 
 	It("gets a client for the appropriate context", func() {
-		err := diskDeleter.DeleteDisk(diskCID)
+		err := diskDeleter.DeleteDisk(context.Background(), diskCID)
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(fakeProvider.NewCallCount()).To(Equal(1))
-		Expect(fakeProvider.NewArgsForCall(0)).To(Equal("bosh"))
+		_, contextName := fakeProvider.NewArgsForCall(0)
+		Expect(contextName).To(Equal("bosh"))
 	})
 
 	It("deletes the persistent volume claim", func() {
-		err := diskDeleter.DeleteDisk(diskCID)
+		err := diskDeleter.DeleteDisk(context.Background(), diskCID)
 		Expect(err).NotTo(HaveOccurred())
 
 		matches := fakeClient.MatchingActions("delete", "persistentvolumeclaims")
@@ -79,7 +81,7 @@ var _ = Describe("DeleteDisk", func() {
 		})
 
 		It("gets a client for the appropriate context", func() {
-			err := diskDeleter.DeleteDisk(diskCID)
+			err := diskDeleter.DeleteDisk(context.Background(), diskCID)
 			Expect(err).To(MatchError("boom"))
 		})
 	})
@@ -92,7 +94,7 @@ var _ = Describe("DeleteDisk", func() {
 		})
 
 		It("returns an error", func() {
-			err := diskDeleter.DeleteDisk(diskCID)
+			err := diskDeleter.DeleteDisk(context.Background(), diskCID)
 			Expect(err).To(MatchError("pvc-welp"))
 			Expect(fakeClient.MatchingActions("delete", "persistentvolumeclaims")).To(HaveLen(1))
 		})
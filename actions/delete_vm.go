@@ -1,9 +1,12 @@
 package actions
 
 import (
+	"context"
+
 	"github.com/evoila/kubernetes-cpi/cpi"
 	"github.com/evoila/kubernetes-cpi/kubecluster"
 
+	"k8s.io/api/core/v1"
 	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
@@ -12,32 +15,54 @@ import (
 
 type VMDeleter struct {
 	ClientProvider kubecluster.ClientProvider
+	Events         *EventRecorder
 }
 
-func (v *VMDeleter) Delete(vmcid cpi.VMCID) error {
-	context, agentID := ParseVMCID(vmcid)
+func (v *VMDeleter) Delete(ctx context.Context, vmcid cpi.VMCID) error {
+	cid, err := vmcid.Parse()
+	if err != nil {
+		return err
+	}
+	vm := vmObjectsFor(cid)
 
-	client, err := v.ClientProvider.New(context)
+	client, err := newClient(ctx, v.ClientProvider, cid.Context, cid.Namespace)
 	if err != nil {
 		return err
 	}
 
-	err = deletePod(client.Pods(), agentID)
+	err = deleteVM(client, vm)
+	if err != nil {
+		return err
+	}
+
+	v.Events.Record(client, v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Name: vm.Pod()}, EventReasonDeleted, "Deleted VM %s", vmcid)
+	return nil
+}
+
+// deleteVM removes the pod of a VM and the objects created with it.
+func deleteVM(client kubecluster.Client, vm vmObjects) error {
+	err := deletePod(client.Pods(), vm.Pod())
 	if err != nil {
 		return err
 	}
 
-	err = deleteServices(client.Services(), agentID)
+	err = deleteServices(client.Services(), vm.AgentID)
 	if err != nil {
 		return err
 	}
 
-	err = deleteConfigMap(client.ConfigMaps(), agentID)
+	err = deleteSecret(client.Secrets(), vm.Settings())
 	if err != nil {
 		return err
 	}
 
-	err = deletePersistentVolumeClaim(client.PersistentVolumeClaims(), agentID)
+	// VMs created before the settings moved to a secret still have a config map
+	err = deleteConfigMap(client.ConfigMaps(), vm.Settings())
+	if err != nil {
+		return err
+	}
+
+	err = deletePersistentVolumeClaim(client.PersistentVolumeClaims(), vm.VarVcap())
 	if err != nil {
 		return err
 	}
@@ -45,12 +70,16 @@ func (v *VMDeleter) Delete(vmcid cpi.VMCID) error {
 	return nil
 }
 
-func deletePersistentVolumeClaim(volumeService core.PersistentVolumeClaimInterface, agentID string) error {
-	return volumeService.Delete("var-vcap-"+agentID, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+func deletePersistentVolumeClaim(volumeService core.PersistentVolumeClaimInterface, name string) error {
+	err := volumeService.Delete(name, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+	if isNotFoundStatusError(err) {
+		return nil
+	}
+	return err
 }
 
-func deleteConfigMap(configMapService core.ConfigMapInterface, agentID string) error {
-	err := configMapService.Delete("agent-"+agentID, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+func deleteConfigMap(configMapService core.ConfigMapInterface, name string) error {
+	err := configMapService.Delete(name, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
 	if statusError, ok := err.(*kubeerrors.StatusError); ok {
 		if statusError.Status().Reason == metav1.StatusReasonNotFound {
 			return nil
@@ -59,6 +88,14 @@ func deleteConfigMap(configMapService core.ConfigMapInterface, agentID string) e
 	return err
 }
 
+func deleteSecret(secretService core.SecretInterface, name string) error {
+	err := secretService.Delete(name, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+	if isNotFoundStatusError(err) {
+		return nil
+	}
+	return err
+}
+
 func deleteServices(serviceClient core.ServiceInterface, agentID string) error {
 	agentSelector, err := labels.Parse("bosh.cloudfoundry.org/agent-id=" + agentID)
 	if err != nil {
@@ -80,8 +117,8 @@ func deleteServices(serviceClient core.ServiceInterface, agentID string) error {
 	return nil
 }
 
-func deletePod(podClient core.PodInterface, agentID string) error {
-	err := podClient.Delete("agent-"+agentID, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+func deletePod(podClient core.PodInterface, name string) error {
+	err := podClient.Delete(name, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
 	if statusError, ok := err.(*kubeerrors.StatusError); ok {
 		if statusError.Status().Reason == metav1.StatusReasonNotFound {
 			return nil
@@ -1,6 +1,7 @@
 package actions_test
 
 import (
+	"context"
 	"errors"
 
 	"github.com/evoila/kubernetes-cpi/actions"
@@ -12,7 +13,6 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/testing"
 )
 
@@ -35,7 +35,7 @@ var _ = Describe("DeleteVM", func() {
 		fakeProvider.NewReturns(fakeClient, nil)
 
 		agentID = "agent-id"
-		vmcid = actions.NewVMCID("bosh", agentID)
+		vmcid = cpi.NewVMCID("bosh", "", agentID)
 
 		services := []v1.Service{{
 			ObjectMeta: metav1.ObjectMeta{
@@ -47,8 +47,9 @@ var _ = Describe("DeleteVM", func() {
 			},
 		}}
 
-		fakeClient.Clientset = *fake.NewSimpleClientset(
+		fakeClient.SetObjects(
 			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"}},
+			&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"}},
 			&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"}},
 			&v1.ServiceList{Items: services},
 		)
@@ -57,11 +58,12 @@ var _ = Describe("DeleteVM", func() {
 	})
 
 	It("gets a client for the appropriate context", func() {
-		err := vmDeleter.Delete(vmcid)
+		err := vmDeleter.Delete(context.Background(), vmcid)
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(fakeProvider.NewCallCount()).To(Equal(1))
-		Expect(fakeProvider.NewArgsForCall(0)).To(Equal("bosh"))
+		_, contextName := fakeProvider.NewArgsForCall(0)
+		Expect(contextName).To(Equal("bosh"))
 	})
 
 	Context("when getting the client fails", func() {
@@ -70,13 +72,13 @@ var _ = Describe("DeleteVM", func() {
 		})
 
 		It("gets a client for the appropriate context", func() {
-			err := vmDeleter.Delete(vmcid)
+			err := vmDeleter.Delete(context.Background(), vmcid)
 			Expect(err).To(MatchError("boom"))
 		})
 	})
 
 	It("deletes the pod", func() {
-		err := vmDeleter.Delete(vmcid)
+		err := vmDeleter.Delete(context.Background(), vmcid)
 		Expect(err).NotTo(HaveOccurred())
 
 		matches := fakeClient.MatchingActions("delete", "pods")
@@ -86,8 +88,23 @@ var _ = Describe("DeleteVM", func() {
 		Expect(matches[0].(testing.DeleteAction).GetNamespace()).To(Equal("bosh-namespace"))
 	})
 
+	Context("when the VM CID names the objects of the VM", func() {
+		BeforeEach(func() {
+			vmcid = cpi.VMCID(cpi.CID{Context: "bosh", Kind: cpi.KindPod, ID: agentID, Name: "cf-database-agentid"}.String())
+		})
+
+		It("deletes the objects by that name", func() {
+			err := vmDeleter.Delete(context.Background(), vmcid)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeClient.MatchingActions("delete", "pods")[0].(testing.DeleteAction).GetName()).To(Equal("cf-database-agentid"))
+			Expect(fakeClient.MatchingActions("delete", "secrets")[0].(testing.DeleteAction).GetName()).To(Equal("cf-database-agentid"))
+			Expect(fakeClient.MatchingActions("delete", "persistentvolumeclaims")[0].(testing.DeleteAction).GetName()).To(Equal("cf-database-agentid-var-vcap"))
+		})
+	})
+
 	It("deletes services labeled with the agent ID", func() {
-		err := vmDeleter.Delete(vmcid)
+		err := vmDeleter.Delete(context.Background(), vmcid)
 		Expect(err).NotTo(HaveOccurred())
 
 		selector, err := labels.Parse("bosh.cloudfoundry.org/agent-id=" + agentID)
@@ -105,8 +122,19 @@ var _ = Describe("DeleteVM", func() {
 		Expect(matches[0].(testing.DeleteAction).GetNamespace()).To(Equal("bosh-namespace"))
 	})
 
+	It("deletes the settings secret", func() {
+		err := vmDeleter.Delete(context.Background(), vmcid)
+		Expect(err).NotTo(HaveOccurred())
+
+		matches := fakeClient.MatchingActions("delete", "secrets")
+		Expect(matches).To(HaveLen(1))
+
+		Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("agent-" + agentID))
+		Expect(matches[0].(testing.DeleteAction).GetNamespace()).To(Equal("bosh-namespace"))
+	})
+
 	It("deletes the config map", func() {
-		err := vmDeleter.Delete(vmcid)
+		err := vmDeleter.Delete(context.Background(), vmcid)
 		Expect(err).NotTo(HaveOccurred())
 
 		matches := fakeClient.MatchingActions("delete", "configmaps")
@@ -118,19 +146,21 @@ var _ = Describe("DeleteVM", func() {
 
 	Context("when objects have already been deleted", func() {
 		BeforeEach(func() {
-			err := vmDeleter.Delete(vmcid)
+			err := vmDeleter.Delete(context.Background(), vmcid)
 			Expect(err).NotTo(HaveOccurred())
 		})
 
 		It("continues with the delete process", func() {
-			err := vmDeleter.Delete(vmcid)
+			err := vmDeleter.Delete(context.Background(), vmcid)
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(fakeClient.Actions()).To(HaveLen(7))
+			Expect(fakeClient.Actions()).To(HaveLen(11))
 			Expect(fakeClient.MatchingActions("delete", "pods")).To(HaveLen(2))
 			Expect(fakeClient.MatchingActions("list", "services")).To(HaveLen(2))
 			Expect(fakeClient.MatchingActions("delete", "services")).To(HaveLen(1))
+			Expect(fakeClient.MatchingActions("delete", "secrets")).To(HaveLen(2))
 			Expect(fakeClient.MatchingActions("delete", "configmaps")).To(HaveLen(2))
+			Expect(fakeClient.MatchingActions("delete", "persistentvolumeclaims")).To(HaveLen(2))
 		})
 	})
 
@@ -142,12 +172,26 @@ var _ = Describe("DeleteVM", func() {
 		})
 
 		It("returns an error", func() {
-			err := vmDeleter.Delete(vmcid)
+			err := vmDeleter.Delete(context.Background(), vmcid)
 			Expect(err).To(MatchError("pods-welp"))
 			Expect(fakeClient.MatchingActions("delete", "pods")).To(HaveLen(1))
 		})
 	})
 
+	Context("when deleting the settings secret fails", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("delete", "secrets", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("secrets-welp")
+			})
+		})
+
+		It("returns an error", func() {
+			err := vmDeleter.Delete(context.Background(), vmcid)
+			Expect(err).To(MatchError("secrets-welp"))
+			Expect(fakeClient.MatchingActions("delete", "secrets")).To(HaveLen(1))
+		})
+	})
+
 	Context("when deleting the config map fails", func() {
 		BeforeEach(func() {
 			fakeClient.PrependReactor("delete", "configmaps", func(action testing.Action) (bool, runtime.Object, error) {
@@ -156,7 +200,7 @@ var _ = Describe("DeleteVM", func() {
 		})
 
 		It("returns an error", func() {
-			err := vmDeleter.Delete(vmcid)
+			err := vmDeleter.Delete(context.Background(), vmcid)
 			Expect(err).To(MatchError("configmaps-welp"))
 			Expect(fakeClient.MatchingActions("delete", "configmaps")).To(HaveLen(1))
 		})
@@ -164,11 +208,11 @@ var _ = Describe("DeleteVM", func() {
 
 	Context("when building the agent selector fails", func() {
 		BeforeEach(func() {
-			vmcid = actions.NewVMCID("bosh", "**invalid**")
+			vmcid = cpi.NewVMCID("bosh", "", "**invalid**")
 		})
 
 		It("returns an error", func() {
-			err := vmDeleter.Delete(vmcid)
+			err := vmDeleter.Delete(context.Background(), vmcid)
 			Expect(err).To(MatchError(ContainSubstring("invalid label value")))
 		})
 	})
@@ -181,7 +225,7 @@ var _ = Describe("DeleteVM", func() {
 		})
 
 		It("returns an error", func() {
-			err := vmDeleter.Delete(vmcid)
+			err := vmDeleter.Delete(context.Background(), vmcid)
 			Expect(err).To(MatchError("services-welp"))
 			Expect(fakeClient.MatchingActions("delete", "services")).To(HaveLen(1))
 		})
@@ -0,0 +1,86 @@
+package actions
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/kubecluster"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// DirectorUUIDLabel holds the UUID of the director that created an object.
+// Directors sharing a namespace only see their own VMs and disks.
+const DirectorUUIDLabel = "bosh.cloudfoundry.org/director-uuid"
+
+// directorUUID returns the UUID of the director that sent the request. It
+// is empty when the request was not dispatched with one.
+func directorUUID(ctx context.Context) string {
+	requestContext, _ := cpi.FromContext(ctx)
+	return requestContext.DirectorUUID
+}
+
+// directorLabels adds the director label of the request to labels.
+func directorLabels(ctx context.Context, labels map[string]string) map[string]string {
+	if uuid := directorUUID(ctx); uuid != "" {
+		labels[DirectorUUIDLabel] = uuid
+	}
+	return labels
+}
+
+// ownedByDirector reports whether an object belongs to the director of the
+// request and whether it has to be adopted first. Objects created before
+// they were labelled belong to no director unless adoptUnlabeled is set.
+func ownedByDirector(ctx context.Context, meta metav1.ObjectMeta, adoptUnlabeled bool) (owned, adopt bool) {
+	uuid := directorUUID(ctx)
+	if uuid == "" {
+		return true, false
+	}
+
+	switch meta.Labels[DirectorUUIDLabel] {
+	case uuid:
+		return true, false
+	case "":
+		return adoptUnlabeled, adoptUnlabeled
+	default:
+		return false, false
+	}
+}
+
+// directorLabelPatch is a merge patch that sets the director label.
+func directorLabelPatch(ctx context.Context) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{DirectorUUIDLabel: directorUUID(ctx)},
+		},
+	})
+}
+
+// adoptVM labels the pod, settings, services and ephemeral disk of a VM
+// created before objects were labelled with the director of the request.
+func adoptVM(ctx context.Context, client kubecluster.Client, vm vmObjects) error {
+	patch, err := directorLabelPatch(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.Pods().Patch(vm.Pod(), types.MergePatchType, patch)
+	if err != nil {
+		return err
+	}
+
+	return patchVMObjects(client, vm, patch)
+}
+
+// adoptClaim labels the claim of a disk created before objects were
+// labelled with the director of the request.
+func adoptClaim(ctx context.Context, client kubecluster.Client, name string) error {
+	patch, err := directorLabelPatch(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PersistentVolumeClaims().Patch(name, types.MergePatchType, patch)
+	return err
+}
@@ -0,0 +1,97 @@
+package actions
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/evoila/kubernetes-cpi/kubecluster"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EventComponent is the source of the events recorded by the CPI.
+const EventComponent = "bosh-kubernetes-cpi"
+
+// Reasons of the events recorded by the CPI.
+const (
+	EventReasonCreated         = "BoshCreated"
+	EventReasonDeleted         = "BoshDeleted"
+	EventReasonDiskAttached    = "BoshDiskAttached"
+	EventReasonDiskDetached    = "BoshDiskDetached"
+	EventReasonSettingsUpdated = "BoshSettingsUpdated"
+	EventReasonMetadataUpdated = "BoshMetadataUpdated"
+)
+
+// EventRecorder records Kubernetes Events about the objects the CPI
+// manages, so what BOSH did to a namespace is visible without access to the
+// director. Events are best effort; failing to record one never fails an
+// operation. A nil recorder records nothing.
+type EventRecorder struct {
+	Clock clock.Clock
+}
+
+// Record records a Normal event about an object.
+func (r *EventRecorder) Record(client kubecluster.Client, object v1.ObjectReference, reason, messageFmt string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+
+	now := metav1.NewTime(r.Clock.Now())
+	if object.Namespace == "" {
+		object.Namespace = client.Namespace()
+	}
+
+	client.Events().Create(&v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: object.Name + ".",
+			Namespace:    object.Namespace,
+		},
+		InvolvedObject: object,
+		Reason:         reason,
+		Message:        fmt.Sprintf(messageFmt, args...),
+		Source:         v1.EventSource{Component: EventComponent},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           v1.EventTypeNormal,
+	})
+}
+
+// RecordClaim records an event about the named claim. The claim is looked
+// up so the event is shown by kubectl describe.
+func (r *EventRecorder) RecordClaim(client kubecluster.Client, name, reason, messageFmt string, args ...interface{}) {
+	if r == nil {
+		return
+	}
+
+	object := v1.ObjectReference{Kind: "PersistentVolumeClaim", APIVersion: "v1", Name: name}
+	if claim, err := client.PersistentVolumeClaims().Get(name, metav1.GetOptions{}); err == nil {
+		object = objectReference("PersistentVolumeClaim", claim.ObjectMeta)
+	}
+	r.Record(client, object, reason, messageFmt, args...)
+}
+
+// objectReference refers to an object by kind and metadata. Events that
+// carry the UID of an object are shown by kubectl describe.
+func objectReference(kind string, meta metav1.ObjectMeta) v1.ObjectReference {
+	return v1.ObjectReference{
+		Kind:            kind,
+		APIVersion:      "v1",
+		Name:            meta.Name,
+		Namespace:       meta.Namespace,
+		UID:             meta.UID,
+		ResourceVersion: meta.ResourceVersion,
+	}
+}
+
+// metadataKeys lists the sorted keys of metadata for an event message.
+func metadataKeys(metadata map[string]string) string {
+	var keys []string
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
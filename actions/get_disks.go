@@ -1,6 +1,7 @@
 package actions
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/evoila/kubernetes-cpi/cpi"
@@ -13,16 +14,25 @@ import (
 
 type DiskGetter struct {
 	ClientProvider kubecluster.ClientProvider
+
+	// AdoptUnlabeled makes VMs and disks created before objects were
+	// labelled with their director visible. They are labelled when they
+	// are found.
+	AdoptUnlabeled bool
 }
 
-func (d *DiskGetter) GetDisks(vmcid cpi.VMCID) ([]cpi.DiskCID, error) {
-	context, agentID := ParseVMCID(vmcid)
-	client, err := d.ClientProvider.New(context)
+func (d *DiskGetter) GetDisks(ctx context.Context, vmcid cpi.VMCID) ([]cpi.DiskCID, error) {
+	cid, err := vmcid.Parse()
+	if err != nil {
+		return nil, err
+	}
+	vm := vmObjectsFor(cid)
+	client, err := newClient(ctx, d.ClientProvider, cid.Context, cid.Namespace)
 	if err != nil {
 		return nil, err
 	}
 
-	pod, err := client.Pods().Get("agent-"+agentID, metav1.GetOptions{})
+	pod, err := client.Pods().Get(vm.Pod(), metav1.GetOptions{})
 	if err != nil {
 		if statusError, ok := err.(*errors.StatusError); ok {
 			if statusError.Status().Code == http.StatusNotFound {
@@ -32,6 +42,17 @@ func (d *DiskGetter) GetDisks(vmcid cpi.VMCID) ([]cpi.DiskCID, error) {
 		return nil, err
 	}
 
+	owned, adopt := ownedByDirector(ctx, pod.ObjectMeta, d.AdoptUnlabeled)
+	if !owned {
+		return []cpi.DiskCID{}, nil
+	}
+	if adopt {
+		err = adoptVM(ctx, client, vm)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	diskIDs := []cpi.DiskCID{}
 	for _, v := range pod.Spec.Volumes {
 		pvc, err := getPVClaim(client.PersistentVolumeClaims(), v.VolumeSource)
@@ -43,8 +64,19 @@ func (d *DiskGetter) GetDisks(vmcid cpi.VMCID) ([]cpi.DiskCID, error) {
 			continue
 		}
 
+		owned, adopt := ownedByDirector(ctx, pvc.ObjectMeta, d.AdoptUnlabeled)
+		if !owned {
+			continue
+		}
+		if adopt {
+			err = adoptClaim(ctx, client, pvc.Name)
+			if err != nil {
+				return nil, err
+			}
+		}
+
 		if diskID, ok := pvc.Labels["bosh.cloudfoundry.org/disk-id"]; ok {
-			diskIDs = append(diskIDs, NewDiskCID(context, diskID))
+			diskIDs = append(diskIDs, claimDiskCID(client, pvc, diskID))
 		}
 	}
 
@@ -64,3 +96,25 @@ func isNotFoundStatusError(err error) bool {
 	}
 	return false
 }
+
+func isForbiddenStatusError(err error) bool {
+	if statusErr, ok := err.(*errors.StatusError); ok {
+		return statusErr.Status().Code == http.StatusForbidden
+	}
+	return false
+}
+
+// claimDiskCID returns the CID the director knows the disk of a claim by.
+// Claims created before CIDs were versioned do not record it; they are all
+// in the namespace of their context.
+func claimDiskCID(client kubecluster.Client, pvc *v1.PersistentVolumeClaim, diskID string) cpi.DiskCID {
+	if diskCID, ok := pvc.Annotations[DiskCIDAnnotation]; ok {
+		return cpi.DiskCID(diskCID)
+	}
+
+	namespace := kubecluster.ExplicitNamespace(client)
+	if namespace != "" {
+		return cpi.NewDiskCID(client.Context(), namespace, diskID)
+	}
+	return cpi.DiskCID(cpi.CID{Context: client.Context(), ID: diskID}.LegacyString())
+}
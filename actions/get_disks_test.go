@@ -1,6 +1,7 @@
 package actions_test
 
 import (
+	"context"
 	"errors"
 
 	"github.com/evoila/kubernetes-cpi/actions"
@@ -83,6 +84,7 @@ var _ = Describe("DiskGetter", func() {
 				}},
 			},
 		)
+		fakeClient.ContextReturns("context-name")
 		fakeClient.NamespaceReturns("bosh-namespace")
 
 		fakeProvider = &fakes.ClientProvider{}
@@ -94,15 +96,16 @@ var _ = Describe("DiskGetter", func() {
 	})
 
 	It("gets a client with the context from the DiskCID", func() {
-		_, err := diskGetter.GetDisks(cpi.VMCID("context-name:agentID"))
+		_, err := diskGetter.GetDisks(context.Background(), cpi.VMCID("context-name:agentID"))
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(fakeProvider.NewCallCount()).To(Equal(1))
-		Expect(fakeProvider.NewArgsForCall(0)).To(Equal("context-name"))
+		_, contextName := fakeProvider.NewArgsForCall(0)
+		Expect(contextName).To(Equal("context-name"))
 	})
 
 	It("retrieves the pod by name", func() {
-		_, err := diskGetter.GetDisks(cpi.VMCID("context-name:agentID"))
+		_, err := diskGetter.GetDisks(context.Background(), cpi.VMCID("context-name:agentID"))
 		Expect(err).NotTo(HaveOccurred())
 
 		matches := fakeClient.MatchingActions("get", "pods")
@@ -111,7 +114,7 @@ var _ = Describe("DiskGetter", func() {
 	})
 
 	It("retrieves pv claims", func() {
-		_, err := diskGetter.GetDisks(cpi.VMCID("context-name:agentID"))
+		_, err := diskGetter.GetDisks(context.Background(), cpi.VMCID("context-name:agentID"))
 		Expect(err).NotTo(HaveOccurred())
 
 		matches := fakeClient.MatchingActions("get", "persistentvolumeclaims")
@@ -122,7 +125,7 @@ var _ = Describe("DiskGetter", func() {
 	})
 
 	It("returns cloud IDs of claimed disks", func() {
-		disks, err := diskGetter.GetDisks(cpi.VMCID("context-name:agentID"))
+		disks, err := diskGetter.GetDisks(context.Background(), cpi.VMCID("context-name:agentID"))
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(disks).To(ConsistOf(
@@ -131,9 +134,37 @@ var _ = Describe("DiskGetter", func() {
 		))
 	})
 
+	Context("when a claim records the CID of its disk", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("get", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+				if action.(testing.GetAction).GetName() != "disk-diskID-1" {
+					return false, nil, nil
+				}
+				return true, &v1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "disk-diskID-1",
+						Namespace:   "bosh-namespace",
+						Labels:      map[string]string{"bosh.cloudfoundry.org/disk-id": "diskID-1"},
+						Annotations: map[string]string{actions.DiskCIDAnnotation: string(cpi.NewDiskCID("context-name", "", "diskID-1"))},
+					},
+				}, nil
+			})
+		})
+
+		It("returns the recorded CID", func() {
+			disks, err := diskGetter.GetDisks(context.Background(), cpi.VMCID("context-name:agentID"))
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(disks).To(ConsistOf(
+				cpi.NewDiskCID("context-name", "", "diskID-1"),
+				cpi.DiskCID("context-name:diskID-2-label-value"),
+			))
+		})
+	})
+
 	Context("when the pod isn't found", func() {
 		It("returns an empty list", func() {
-			disks, err := diskGetter.GetDisks(cpi.VMCID("context-name:missing"))
+			disks, err := diskGetter.GetDisks(context.Background(), cpi.VMCID("context-name:missing"))
 			Expect(err).NotTo(HaveOccurred())
 			Expect(disks).NotTo(BeNil())
 			Expect(disks).To(BeEmpty())
@@ -148,7 +179,7 @@ var _ = Describe("DiskGetter", func() {
 		})
 
 		It("returns an error", func() {
-			_, err := diskGetter.GetDisks(cpi.VMCID("context-name:agentID"))
+			_, err := diskGetter.GetDisks(context.Background(), cpi.VMCID("context-name:agentID"))
 			Expect(err).To(MatchError("get-pod-welp"))
 		})
 	})
@@ -164,7 +195,7 @@ var _ = Describe("DiskGetter", func() {
 		})
 
 		It("it ignores the error", func() {
-			disks, err := diskGetter.GetDisks(cpi.VMCID("context-name:agentID"))
+			disks, err := diskGetter.GetDisks(context.Background(), cpi.VMCID("context-name:agentID"))
 			Expect(err).NotTo(HaveOccurred())
 			Expect(disks).To(ConsistOf(cpi.DiskCID("context-name:diskID-2-label-value")))
 		})
@@ -178,7 +209,7 @@ var _ = Describe("DiskGetter", func() {
 		})
 
 		It("returns an error", func() {
-			_, err := diskGetter.GetDisks(cpi.VMCID("context-name:agentID"))
+			_, err := diskGetter.GetDisks(context.Background(), cpi.VMCID("context-name:agentID"))
 			Expect(err).To(MatchError("get-pvc-welp"))
 		})
 	})
@@ -1,6 +1,8 @@
 package actions
 
 import (
+	"context"
+
 	"github.com/evoila/kubernetes-cpi/cpi"
 	"github.com/evoila/kubernetes-cpi/kubecluster"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -9,16 +11,25 @@ import (
 
 type DiskFinder struct {
 	ClientProvider kubecluster.ClientProvider
+
+	// AdoptUnlabeled makes disks created before claims were labelled with
+	// their director visible. They are labelled when they are found.
+	AdoptUnlabeled bool
 }
 
-func (d *DiskFinder) HasDisk(diskCID cpi.DiskCID) (bool, error) {
-	context, diskID := ParseDiskCID(diskCID)
+func (d *DiskFinder) HasDisk(ctx context.Context, diskCID cpi.DiskCID) (bool, error) {
+	cid, err := diskCID.Parse()
+	if err != nil {
+		return false, err
+	}
+	diskID := cid.ID
+
 	diskSelector, err := labels.Parse("bosh.cloudfoundry.org/disk-id=" + diskID)
 	if err != nil {
 		return false, err
 	}
 
-	client, err := d.ClientProvider.New(context)
+	client, err := newClient(ctx, d.ClientProvider, cid.Context, cid.Namespace)
 	if err != nil {
 		return false, err
 	}
@@ -29,5 +40,20 @@ func (d *DiskFinder) HasDisk(diskCID cpi.DiskCID) (bool, error) {
 		return false, err
 	}
 
-	return len(pvcList.Items) > 0, nil
+	for _, pvc := range pvcList.Items {
+		owned, adopt := ownedByDirector(ctx, pvc.ObjectMeta, d.AdoptUnlabeled)
+		if !owned {
+			continue
+		}
+
+		if adopt {
+			err = adoptClaim(ctx, client, pvc.Name)
+			if err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	}
+
+	return false, nil
 }
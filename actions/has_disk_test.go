@@ -1,6 +1,7 @@
 package actions_test
 
 import (
+	"context"
 	"errors"
 
 	"github.com/evoila/kubernetes-cpi/actions"
@@ -45,15 +46,16 @@ var _ = Describe("HasDisk", func() {
 	})
 
 	It("gets a client with the context from the DiskCID", func() {
-		_, err := diskFinder.HasDisk(cpi.DiskCID("context-name:diskID-1"))
+		_, err := diskFinder.HasDisk(context.Background(), cpi.DiskCID("context-name:diskID-1"))
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(fakeProvider.NewCallCount()).To(Equal(1))
-		Expect(fakeProvider.NewArgsForCall(0)).To(Equal("context-name"))
+		_, contextName := fakeProvider.NewArgsForCall(0)
+		Expect(contextName).To(Equal("context-name"))
 	})
 
 	It("lists disks labled with the disk ID", func() {
-		_, err := diskFinder.HasDisk(cpi.DiskCID("context-name:diskID-1"))
+		_, err := diskFinder.HasDisk(context.Background(), cpi.DiskCID("context-name:diskID-1"))
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(fakeClient.Actions()).To(HaveLen(1))
@@ -62,13 +64,13 @@ var _ = Describe("HasDisk", func() {
 	})
 
 	It("returns true when the disk is found", func() {
-		found, err := diskFinder.HasDisk(cpi.DiskCID("context-name:diskID-1"))
+		found, err := diskFinder.HasDisk(context.Background(), cpi.DiskCID("context-name:diskID-1"))
 		Expect(err).NotTo(HaveOccurred())
 		Expect(found).To(BeTrue())
 	})
 
 	It("returns false when the disk is found", func() {
-		found, err := diskFinder.HasDisk(cpi.DiskCID("context-name:missing"))
+		found, err := diskFinder.HasDisk(context.Background(), cpi.DiskCID("context-name:missing"))
 		Expect(err).NotTo(HaveOccurred())
 		Expect(found).To(BeFalse())
 	})
@@ -79,15 +81,52 @@ var _ = Describe("HasDisk", func() {
 		})
 
 		It("returns an error", func() {
-			_, err := diskFinder.HasDisk(cpi.DiskCID("context-name:missing"))
+			_, err := diskFinder.HasDisk(context.Background(), cpi.DiskCID("context-name:missing"))
 			Expect(err).To(MatchError("welp"))
 		})
 	})
 
 	Context("when the label can't be parsed", func() {
 		It("returns an error", func() {
-			_, err := diskFinder.HasDisk(cpi.DiskCID("context-name:%&^*****@*^"))
+			_, err := diskFinder.HasDisk(context.Background(), cpi.DiskCID("context-name:%&^*****@*^"))
 			Expect(err).To(HaveOccurred())
 		})
 	})
+
+	Context("when the request names a director", func() {
+		var ctx context.Context
+
+		BeforeEach(func() {
+			ctx = cpi.NewContext(context.Background(), cpi.Context{DirectorUUID: "director-1"})
+
+			fakeClient = fakes.NewClient(
+				&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+					Name:      "disk-theirs",
+					Namespace: "bosh-namespace",
+					Labels:    map[string]string{"bosh.cloudfoundry.org/disk-id": "theirs", actions.DirectorUUIDLabel: "director-2"},
+				}},
+				&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{
+					Name:      "disk-old",
+					Namespace: "bosh-namespace",
+					Labels:    map[string]string{"bosh.cloudfoundry.org/disk-id": "old"},
+				}},
+			)
+			fakeClient.NamespaceReturns("bosh-namespace")
+			fakeProvider.NewReturns(fakeClient, nil)
+		})
+
+		It("does not find the disks of other directors", func() {
+			Expect(diskFinder.HasDisk(ctx, cpi.DiskCID("context-name:theirs"))).To(BeFalse())
+			Expect(diskFinder.HasDisk(ctx, cpi.DiskCID("context-name:old"))).To(BeFalse())
+		})
+
+		It("finds and labels unlabeled disks when they are adopted", func() {
+			diskFinder.AdoptUnlabeled = true
+			Expect(diskFinder.HasDisk(ctx, cpi.DiskCID("context-name:old"))).To(BeTrue())
+
+			pvc, err := fakeClient.PersistentVolumeClaims().Get("disk-old", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pvc.Labels).To(HaveKeyWithValue(actions.DirectorUUIDLabel, "director-1"))
+		})
+	})
 })
@@ -1,6 +1,8 @@
 package actions
 
 import (
+	"context"
+
 	"github.com/evoila/kubernetes-cpi/cpi"
 	"github.com/evoila/kubernetes-cpi/kubecluster"
 	v1 "k8s.io/api/core/v1"
@@ -10,21 +12,30 @@ import (
 
 type VMFinder struct {
 	ClientProvider kubecluster.ClientProvider
+
+	// AdoptUnlabeled makes VMs created before objects were labelled with
+	// their director visible. They are labelled when they are found.
+	AdoptUnlabeled bool
 }
 
-func (f *VMFinder) HasVM(vmcid cpi.VMCID) (bool, error) {
-	_, pod, err := f.FindVM(vmcid)
+func (f *VMFinder) HasVM(ctx context.Context, vmcid cpi.VMCID) (bool, error) {
+	_, pod, err := f.FindVM(ctx, vmcid)
 	return pod != nil, err
 }
 
-func (f *VMFinder) FindVM(vmcid cpi.VMCID) (string, *v1.Pod, error) {
-	context, agentID := ParseVMCID(vmcid)
-	agentSelector, err := labels.Parse("bosh.cloudfoundry.org/agent-id=" + agentID)
+func (f *VMFinder) FindVM(ctx context.Context, vmcid cpi.VMCID) (string, *v1.Pod, error) {
+	cid, err := vmcid.Parse()
 	if err != nil {
 		return "", nil, err
 	}
+	vm := vmObjectsFor(cid)
 
-	client, err := f.ClientProvider.New(context)
+	agentSelector, err := labels.Parse("bosh.cloudfoundry.org/agent-id=" + vm.AgentID)
+	if err != nil {
+		return "", nil, err
+	}
+
+	client, err := newClient(ctx, f.ClientProvider, cid.Context, cid.Namespace)
 	if err != nil {
 		return "", nil, err
 	}
@@ -35,8 +46,20 @@ func (f *VMFinder) FindVM(vmcid cpi.VMCID) (string, *v1.Pod, error) {
 		return "", nil, err
 	}
 
-	if len(podList.Items) > 0 {
-		return context, &podList.Items[0], nil
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		owned, adopt := ownedByDirector(ctx, pod.ObjectMeta, f.AdoptUnlabeled)
+		if !owned {
+			continue
+		}
+
+		if adopt {
+			err = adoptVM(ctx, client, vm)
+			if err != nil {
+				return "", nil, err
+			}
+		}
+		return cid.Context, pod, nil
 	}
 
 	return "", nil, nil
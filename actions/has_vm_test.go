@@ -1,6 +1,7 @@
 package actions_test
 
 import (
+	"context"
 	"errors"
 
 	"github.com/evoila/kubernetes-cpi/actions"
@@ -43,21 +44,22 @@ var _ = Describe("VMFinder", func() {
 
 	Describe("HasVM", func() {
 		It("gets a client with the context from the VMCID", func() {
-			_, err := vmFinder.HasVM(cpi.VMCID("context-name:agentID"))
+			_, err := vmFinder.HasVM(context.Background(), cpi.VMCID("context-name:agentID"))
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(fakeProvider.NewCallCount()).To(Equal(1))
-			Expect(fakeProvider.NewArgsForCall(0)).To(Equal("context-name"))
+			_, contextName := fakeProvider.NewArgsForCall(0)
+			Expect(contextName).To(Equal("context-name"))
 		})
 
 		It("returns true when the pod is found", func() {
-			found, err := vmFinder.HasVM(cpi.VMCID("context-name:agentID"))
+			found, err := vmFinder.HasVM(context.Background(), cpi.VMCID("context-name:agentID"))
 			Expect(err).NotTo(HaveOccurred())
 			Expect(found).To(BeTrue())
 		})
 
 		It("returns false when the pod is not found", func() {
-			found, err := vmFinder.HasVM(cpi.VMCID("context-name:missing"))
+			found, err := vmFinder.HasVM(context.Background(), cpi.VMCID("context-name:missing"))
 			Expect(err).NotTo(HaveOccurred())
 			Expect(found).To(BeFalse())
 		})
@@ -68,7 +70,7 @@ var _ = Describe("VMFinder", func() {
 			})
 
 			It("returns an error", func() {
-				_, err := vmFinder.HasVM(cpi.VMCID("context-name:agentID"))
+				_, err := vmFinder.HasVM(context.Background(), cpi.VMCID("context-name:agentID"))
 				Expect(err).To(MatchError("welp"))
 			})
 		})
@@ -76,15 +78,16 @@ var _ = Describe("VMFinder", func() {
 
 	Describe("FindVM", func() {
 		It("uses the client for the context in the VMCID", func() {
-			_, _, err := vmFinder.FindVM(cpi.VMCID("context-name:agentID"))
+			_, _, err := vmFinder.FindVM(context.Background(), cpi.VMCID("context-name:agentID"))
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(fakeProvider.NewCallCount()).To(Equal(1))
-			Expect(fakeProvider.NewArgsForCall(0)).To(Equal("context-name"))
+			_, contextName := fakeProvider.NewArgsForCall(0)
+			Expect(contextName).To(Equal("context-name"))
 		})
 
 		It("selects pods labeled with the agentID in the VMCID", func() {
-			_, _, err := vmFinder.FindVM(cpi.VMCID("context-name:agentID"))
+			_, _, err := vmFinder.FindVM(context.Background(), cpi.VMCID("context-name:agentID"))
 			Expect(err).NotTo(HaveOccurred())
 
 			Expect(fakeClient.Actions()).To(HaveLen(1))
@@ -93,10 +96,10 @@ var _ = Describe("VMFinder", func() {
 		})
 
 		It("returns the context name and matching pod", func() {
-			context, pod, err := vmFinder.FindVM(cpi.VMCID("context-name:agentID"))
+			contextName, pod, err := vmFinder.FindVM(context.Background(), cpi.VMCID("context-name:agentID"))
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(context).To(Equal("context-name"))
+			Expect(contextName).To(Equal("context-name"))
 
 			Expect(pod).NotTo(BeNil())
 			Expect(pod.Name).To(Equal("agent-agentID"))
@@ -108,14 +111,22 @@ var _ = Describe("VMFinder", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, err := vmFinder.FindVM(cpi.VMCID("context-name:agentID"))
+				_, _, err := vmFinder.FindVM(context.Background(), cpi.VMCID("context-name:agentID"))
 				Expect(err).To(MatchError("welp"))
 			})
 		})
 
+		Context("when the VM CID is malformed", func() {
+			It("returns an error without getting a client", func() {
+				_, _, err := vmFinder.FindVM(context.Background(), cpi.VMCID("agentID"))
+				Expect(err).To(MatchError(`invalid VM CID "agentID": unknown format`))
+				Expect(fakeProvider.NewCallCount()).To(Equal(0))
+			})
+		})
+
 		Context("when the label can't be parsed", func() {
 			It("returns an error", func() {
-				_, _, err := vmFinder.FindVM(cpi.VMCID("context-name:%&^*****@*^"))
+				_, _, err := vmFinder.FindVM(context.Background(), cpi.VMCID("context-name:%&^*****@*^"))
 				Expect(err).To(HaveOccurred())
 			})
 		})
@@ -128,10 +139,62 @@ var _ = Describe("VMFinder", func() {
 			})
 
 			It("returns an error", func() {
-				_, _, err := vmFinder.FindVM(cpi.VMCID("context-name:agentID"))
+				_, _, err := vmFinder.FindVM(context.Background(), cpi.VMCID("context-name:agentID"))
 				Expect(err).To(MatchError("welp"))
 				Expect(fakeClient.Actions()).To(HaveLen(1))
 			})
 		})
 	})
+
+	Context("when the request names a director", func() {
+		var ctx context.Context
+
+		BeforeEach(func() {
+			ctx = cpi.NewContext(context.Background(), cpi.Context{DirectorUUID: "director-1"})
+
+			fakeClient = fakes.NewClient(
+				&v1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Name:      "agent-mine",
+					Namespace: "bosh-namespace",
+					Labels:    map[string]string{"bosh.cloudfoundry.org/agent-id": "mine", actions.DirectorUUIDLabel: "director-1"},
+				}},
+				&v1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Name:      "agent-theirs",
+					Namespace: "bosh-namespace",
+					Labels:    map[string]string{"bosh.cloudfoundry.org/agent-id": "theirs", actions.DirectorUUIDLabel: "director-2"},
+				}},
+				&v1.Pod{ObjectMeta: metav1.ObjectMeta{
+					Name:      "agent-old",
+					Namespace: "bosh-namespace",
+					Labels:    map[string]string{"bosh.cloudfoundry.org/agent-id": "old"},
+				}},
+			)
+			fakeClient.NamespaceReturns("bosh-namespace")
+			fakeProvider.NewReturns(fakeClient, nil)
+		})
+
+		It("only finds the VMs of the director", func() {
+			Expect(vmFinder.HasVM(ctx, cpi.VMCID("context-name:mine"))).To(BeTrue())
+			Expect(vmFinder.HasVM(ctx, cpi.VMCID("context-name:theirs"))).To(BeFalse())
+			Expect(vmFinder.HasVM(ctx, cpi.VMCID("context-name:old"))).To(BeFalse())
+		})
+
+		Context("when unlabeled objects are adopted", func() {
+			BeforeEach(func() {
+				vmFinder.AdoptUnlabeled = true
+			})
+
+			It("finds and labels VMs created before the label", func() {
+				Expect(vmFinder.HasVM(ctx, cpi.VMCID("context-name:old"))).To(BeTrue())
+
+				pod, err := fakeClient.Pods().Get("agent-old", metav1.GetOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(pod.Labels).To(HaveKeyWithValue(actions.DirectorUUIDLabel, "director-1"))
+			})
+
+			It("still ignores the VMs of other directors", func() {
+				Expect(vmFinder.HasVM(ctx, cpi.VMCID("context-name:theirs"))).To(BeFalse())
+			})
+		})
+	})
 })
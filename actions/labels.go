@@ -0,0 +1,77 @@
+package actions
+
+import (
+	"github.com/evoila/kubernetes-cpi/cpi"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// Labels identifying the deployment and instance group of the pod of a VM.
+const (
+	DeploymentLabel    = "bosh.cloudfoundry.org/deployment"
+	InstanceGroupLabel = "bosh.cloudfoundry.org/instance-group"
+
+	AppNameLabel      = "app.kubernetes.io/name"
+	AppInstanceLabel  = "app.kubernetes.io/instance"
+	AppPartOfLabel    = "app.kubernetes.io/part-of"
+	AppManagedByLabel = "app.kubernetes.io/managed-by"
+)
+
+// HostnameTopologyKey spreads the pods of an instance group across nodes.
+const HostnameTopologyKey = "kubernetes.io/hostname"
+
+// instanceLabels returns the labels of the pod of a VM that identify its
+// deployment and instance group. Names that are not valid label values are
+// left out.
+func instanceLabels(env cpi.Environment, vm vmObjects) map[string]string {
+	identity := boshIdentity(env)
+
+	labels := map[string]string{
+		AppManagedByLabel: EventComponent,
+		AppInstanceLabel:  vm.Pod(),
+	}
+	addLabelValue(labels, DeploymentLabel, identity.Deployment)
+	addLabelValue(labels, AppPartOfLabel, identity.Deployment)
+	addLabelValue(labels, InstanceGroupLabel, identity.InstanceGroup)
+	addLabelValue(labels, AppNameLabel, identity.InstanceGroup)
+	return labels
+}
+
+func addLabelValue(labels map[string]string, key, value string) {
+	if value != "" && len(validation.IsValidLabelValue(value)) == 0 {
+		labels[key] = value
+	}
+}
+
+// instanceGroupAntiAffinity prefers to schedule the pods of an instance
+// group on different nodes. Pods that do not know their instance group get
+// no affinity.
+func instanceGroupAntiAffinity(labels map[string]string) *v1.Affinity {
+	deployment, ok := labels[DeploymentLabel]
+	if !ok {
+		return nil
+	}
+	group, ok := labels[InstanceGroupLabel]
+	if !ok {
+		return nil
+	}
+
+	return &v1.Affinity{
+		PodAntiAffinity: &v1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{{
+				Weight: 100,
+				PodAffinityTerm: v1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{
+						MatchLabels: map[string]string{
+							DeploymentLabel:    deployment,
+							InstanceGroupLabel: group,
+						},
+					},
+					TopologyKey: HostnameTopologyKey,
+				},
+			}},
+		},
+	}
+}
@@ -0,0 +1,59 @@
+package actions
+
+import (
+	"encoding/json"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// MetadataPrefix prefixes the label and annotation keys of BOSH metadata.
+const MetadataPrefix = "bosh.cloudfoundry.org/"
+
+// reservedMetadataKeys are the labels and annotations the CPI sets itself
+// under MetadataPrefix. Metadata of the same name would overwrite them, or
+// remove them when its value is not a valid label value.
+var reservedMetadataKeys = map[string]bool{
+	DeploymentLabel:                     true,
+	InstanceGroupLabel:                  true,
+	DirectorUUIDLabel:                   true,
+	DiskCIDAnnotation:                   true,
+	ZoneAnnotation:                      true,
+	"bosh.cloudfoundry.org/agent-id":    true,
+	"bosh.cloudfoundry.org/disk-id":     true,
+	"bosh.cloudfoundry.org/var-vcap-id": true,
+	"bosh.cloudfoundry.org/ip-address":  true,
+}
+
+// metadataPatch returns a merge patch that applies BOSH metadata to an
+// object. Values that are valid label values become labels; others, like
+// timestamps or long names, become annotations. The key is removed from the
+// other map so a value that changes kind does not leave a stale copy behind.
+// Keys that are not valid names, or that name a label or annotation of the
+// CPI, are dropped.
+func metadataPatch(metadata map[string]string) ([]byte, error) {
+	labels := map[string]interface{}{}
+	annotations := map[string]interface{}{}
+
+	for k, v := range metadata {
+		key := MetadataPrefix + strings.ToLower(k)
+		if len(validation.IsQualifiedName(key)) != 0 || reservedMetadataKeys[key] {
+			continue
+		}
+
+		if len(validation.IsValidLabelValue(v)) == 0 {
+			labels[key] = v
+			annotations[key] = nil
+		} else {
+			annotations[key] = v
+			labels[key] = nil
+		}
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels":      labels,
+			"annotations": annotations,
+		},
+	})
+}
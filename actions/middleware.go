@@ -0,0 +1,176 @@
+package actions
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/redact"
+)
+
+// LogRequests writes every request and response as JSON to w. Passwords,
+// tokens and other credentials are redacted.
+func LogRequests(w io.Writer) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			logJSON(w, "request", redactRequest(req))
+			resp, err := next(ctx, req)
+			if err != nil {
+				logJSON(w, "error", err.Error())
+			} else {
+				logJSON(w, "response", redactResponse(resp))
+			}
+			return resp, err
+		}
+	}
+}
+
+func redactRequest(req *cpi.Request) *cpi.Request {
+	redacted := *req
+	if args, ok := redact.Value(req.Args).([]interface{}); ok {
+		redacted.Args = args
+	}
+	return &redacted
+}
+
+func redactResponse(resp *cpi.Response) *cpi.Response {
+	if resp == nil {
+		return nil
+	}
+	redacted := *resp
+	redacted.Result = redact.Value(resp.Result)
+	return &redacted
+}
+
+func logJSON(w io.Writer, stem string, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		payload, _ = json.Marshal(err.Error())
+	}
+	fmt.Fprintf(w, `{ "%s": %s }%c`, stem, payload, '\n')
+}
+
+// TraceEntry is the line written by Trace for every request.
+type TraceEntry struct {
+	Time     time.Time   `json:"time"`
+	Method   string      `json:"method"`
+	Duration float64     `json:"duration_seconds"`
+	Request  interface{} `json:"request"`
+	Response interface{} `json:"response,omitempty"`
+	Error    string      `json:"error,omitempty"`
+}
+
+// Trace writes a TraceEntry for every request to w as a line of JSON. The
+// request and response are redacted, so the trace can be shared.
+func Trace(w io.Writer, clk clock.Clock) Middleware {
+	var mutex sync.Mutex
+	encoder := json.NewEncoder(w)
+
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			start := clk.Now()
+			resp, err := next(ctx, req)
+
+			entry := TraceEntry{
+				Time:     start.UTC(),
+				Method:   req.Method,
+				Duration: clk.Since(start).Seconds(),
+				Request:  redactRequest(req),
+			}
+			if resp != nil {
+				entry.Response = redactResponse(resp)
+			}
+			if err != nil {
+				entry.Error = err.Error()
+			}
+
+			mutex.Lock()
+			encoder.Encode(entry)
+			mutex.Unlock()
+
+			return resp, err
+		}
+	}
+}
+
+// Timing reports how long each request took to observe.
+func Timing(clk clock.Clock, observe func(method string, duration time.Duration, err error)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			start := clk.Now()
+			resp, err := next(ctx, req)
+			if err == nil && resp != nil && resp.Error != nil {
+				observe(req.Method, clk.Since(start), fmt.Errorf("%s", resp.Error.Message))
+			} else {
+				observe(req.Method, clk.Since(start), err)
+			}
+			return resp, err
+		}
+	}
+}
+
+// TimeoutError is returned when a request does not complete in time.
+type TimeoutError struct {
+	Method  string
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s did not complete within %s", e.Method, e.Timeout)
+}
+
+// Timeout cancels the context of requests that take longer than timeout
+// and reports them as a TimeoutError, also when the action turned the
+// cancellation into an error response. It waits for the handler to return so
+// cleanup of a half finished operation is not cut short. A timeout of zero
+// disables the limit.
+func Timeout(timeout time.Duration) Middleware {
+	return func(next Handler) Handler {
+		if timeout <= 0 {
+			return next
+		}
+
+		return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+
+			resp, err := next(ctx, req)
+			failed := err != nil || (resp != nil && resp.Error != nil)
+			if failed && ctx.Err() == context.DeadlineExceeded {
+				return nil, &TimeoutError{Method: req.Method, Timeout: timeout}
+			}
+			return resp, err
+		}
+	}
+}
+
+// PanicError is returned when a handler panics.
+type PanicError struct {
+	Method string
+	Value  interface{}
+	Stack  []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("%s panicked: %v", e.Method, e.Value)
+}
+
+// Recover turns a panic in a handler into an error.
+func Recover() Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *cpi.Request) (resp *cpi.Response, err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					resp, err = nil, &PanicError{Method: req.Method, Value: r, Stack: debug.Stack()}
+				}
+			}()
+			return next(ctx, req)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package actions_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/cpi"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Middleware", func() {
+	var (
+		req       *cpi.Request
+		fakeClock *fakeclock.FakeClock
+	)
+
+	BeforeEach(func() {
+		req = &cpi.Request{Method: "has_vm", Args: []interface{}{"bosh:1234"}}
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+	})
+
+	Describe("LogRequests", func() {
+		It("writes the request and the response", func() {
+			buf := &bytes.Buffer{}
+			handler := actions.LogRequests(buf)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				return &cpi.Response{Result: true}, nil
+			})
+
+			_, err := handler(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buf.String()).To(ContainSubstring(`{ "request": {"method":"has_vm","arguments":["bosh:1234"]`))
+			Expect(buf.String()).To(ContainSubstring(`{ "response": {"result":true,"error":null,"log":""} }`))
+		})
+
+		It("redacts credentials", func() {
+			req.Method = "create_vm"
+			req.Args = []interface{}{"agent-id", "stemcell-id", map[string]interface{}{}, map[string]interface{}{}, []string{}, map[string]interface{}{
+				"bosh": map[string]interface{}{"password": "hashed-password"},
+			}}
+
+			buf := &bytes.Buffer{}
+			handler := actions.LogRequests(buf)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				return &cpi.Response{Result: "bosh:1234"}, nil
+			})
+
+			_, err := handler(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buf.String()).To(ContainSubstring(`{"bosh":{"password":"[REDACTED]"}}`))
+			Expect(buf.String()).NotTo(ContainSubstring("hashed-password"))
+		})
+	})
+
+	Describe("Trace", func() {
+		It("writes a redacted line of JSON for every request", func() {
+			req.Args = []interface{}{"bosh:1234", map[string]interface{}{"token": "secret"}}
+
+			buf := &bytes.Buffer{}
+			handler := actions.Trace(buf, fakeClock)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				fakeClock.Increment(1500 * time.Millisecond)
+				return &cpi.Response{Result: true}, nil
+			})
+
+			_, err := handler(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+			_, err = handler(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			Expect(lines).To(HaveLen(2))
+
+			var entry actions.TraceEntry
+			Expect(json.Unmarshal([]byte(lines[0]), &entry)).To(Succeed())
+			Expect(entry.Method).To(Equal("has_vm"))
+			Expect(entry.Duration).To(Equal(1.5))
+			Expect(entry.Time).To(BeTemporally("~", fakeClock.Now(), 3*time.Second))
+			Expect(entry.Request).To(HaveKeyWithValue("arguments", []interface{}{"bosh:1234", map[string]interface{}{"token": "[REDACTED]"}}))
+			Expect(entry.Response).To(HaveKeyWithValue("result", true))
+			Expect(entry.Error).To(BeEmpty())
+		})
+
+		It("records errors", func() {
+			buf := &bytes.Buffer{}
+			handler := actions.Trace(buf, fakeClock)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				return nil, errors.New("welp")
+			})
+
+			handler(context.Background(), req)
+
+			var entry actions.TraceEntry
+			Expect(json.Unmarshal(buf.Bytes(), &entry)).To(Succeed())
+			Expect(entry.Error).To(Equal("welp"))
+			Expect(entry.Response).To(BeNil())
+		})
+	})
+
+	Describe("Timing", func() {
+		It("reports the duration of the request", func() {
+			var method string
+			var duration time.Duration
+			handler := actions.Timing(fakeClock, func(m string, d time.Duration, err error) {
+				method, duration = m, d
+			})(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				fakeClock.Increment(3 * time.Second)
+				return &cpi.Response{}, nil
+			})
+
+			_, err := handler(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(method).To(Equal("has_vm"))
+			Expect(duration).To(Equal(3 * time.Second))
+		})
+
+		It("reports errors in the response", func() {
+			var reported error
+			handler := actions.Timing(fakeClock, func(m string, d time.Duration, err error) {
+				reported = err
+			})(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				return cpi.NewErrorResponse(errors.New("welp")), nil
+			})
+
+			handler(context.Background(), req)
+			Expect(reported).To(MatchError("welp"))
+		})
+	})
+
+	Describe("Timeout", func() {
+		It("fails and cancels requests that do not complete in time", func() {
+			var cleanedUp bool
+			handler := actions.Timeout(10 * time.Millisecond)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				<-ctx.Done()
+				cleanedUp = true
+				return nil, ctx.Err()
+			})
+
+			_, err := handler(context.Background(), req)
+			Expect(err).To(MatchError("has_vm did not complete within 10ms"))
+			Expect(cleanedUp).To(BeTrue())
+		})
+
+		It("reports error responses after the deadline as timeouts", func() {
+			handler := actions.Timeout(10 * time.Millisecond)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				<-ctx.Done()
+				return &cpi.Response{Error: &cpi.ResponseError{Type: "Bosh::Clouds::CloudError", Message: ctx.Err().Error()}}, nil
+			})
+
+			_, err := handler(context.Background(), req)
+			Expect(err).To(MatchError("has_vm did not complete within 10ms"))
+		})
+
+		It("returns the result of requests that complete after the deadline", func() {
+			handler := actions.Timeout(10 * time.Millisecond)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				<-ctx.Done()
+				return &cpi.Response{Result: "bosh:1234"}, nil
+			})
+
+			resp, err := handler(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(Equal("bosh:1234"))
+		})
+
+		It("does not report cancelled requests as timeouts", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			handler := actions.Timeout(time.Minute)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				return nil, ctx.Err()
+			})
+
+			_, err := handler(ctx, req)
+			Expect(err).To(Equal(context.Canceled))
+		})
+
+		It("passes requests through when the timeout is zero", func() {
+			handler := actions.Timeout(0)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				return &cpi.Response{Result: true}, nil
+			})
+
+			resp, err := handler(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(BeTrue())
+		})
+	})
+
+	Describe("Recover", func() {
+		It("turns a panic into an error", func() {
+			handler := actions.Recover()(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				panic("boom")
+			})
+
+			_, err := handler(context.Background(), req)
+			Expect(err).To(MatchError("has_vm panicked: boom"))
+		})
+	})
+})
@@ -0,0 +1,120 @@
+package actions
+
+import (
+	"strings"
+
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/kubecluster"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// readableNameSuffixLength is the number of characters of the agent ID that
+// keep readable names unique.
+const readableNameSuffixLength = 8
+
+// vmObjects names the Kubernetes objects of a VM. VMs without a readable
+// name use names derived from their agent ID.
+type vmObjects struct {
+	AgentID string
+	Name    string
+}
+
+// vmObjectsFor returns the objects of the VM a CID refers to.
+func vmObjectsFor(cid cpi.CID) vmObjects {
+	return vmObjects{AgentID: cid.ID, Name: cid.Name}
+}
+
+// Pod is the name of the pod of the VM.
+func (o vmObjects) Pod() string {
+	if o.Name != "" {
+		return o.Name
+	}
+	return "agent-" + o.AgentID
+}
+
+// Settings is the name of the secret holding the agent settings. VMs
+// created before the settings moved to a secret have a config map of the
+// same name.
+func (o vmObjects) Settings() string {
+	return o.Pod()
+}
+
+// VarVcap is the name of the claim of the ephemeral disk.
+func (o vmObjects) VarVcap() string {
+	if o.Name != "" {
+		return o.Name + "-var-vcap"
+	}
+	return "var-vcap-" + o.AgentID
+}
+
+// patchVMObjects applies a merge patch to the settings, ephemeral disk and
+// services of a VM. Objects the VM does not have are skipped.
+func patchVMObjects(client kubecluster.Client, vm vmObjects, patch []byte) error {
+	_, err := client.Secrets().Patch(vm.Settings(), types.MergePatchType, patch)
+	if err != nil && !isNotFoundStatusError(err) {
+		return err
+	}
+
+	_, err = client.ConfigMaps().Patch(vm.Settings(), types.MergePatchType, patch)
+	if err != nil && !isNotFoundStatusError(err) {
+		return err
+	}
+
+	_, err = client.PersistentVolumeClaims().Patch(vm.VarVcap(), types.MergePatchType, patch)
+	if err != nil && !isNotFoundStatusError(err) {
+		return err
+	}
+
+	services, err := client.Services().List(metav1.ListOptions{LabelSelector: "bosh.cloudfoundry.org/agent-id=" + vm.AgentID})
+	if err != nil {
+		return err
+	}
+	for _, service := range services.Items {
+		_, err = client.Services().Patch(service.Name, types.MergePatchType, patch)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readableName returns a name for the objects of a VM made of its
+// deployment and instance group and the start of its agent ID. It is empty
+// when the environment does not name both.
+func readableName(env cpi.Environment, agentID string) string {
+	data := boshIdentity(env)
+	if data.Deployment == "" || data.InstanceGroup == "" {
+		return ""
+	}
+
+	suffix := sanitizeDNSLabel(strings.Replace(agentID, "-", "", -1))
+	if len(suffix) > readableNameSuffixLength {
+		suffix = suffix[:readableNameSuffixLength]
+	}
+
+	// Leave room for the suffix of the var-vcap claim so every name is a
+	// valid label.
+	prefix := sanitizeDNSLabel(data.Deployment + "-" + data.InstanceGroup)
+	maxPrefix := validation.DNS1123LabelMaxLength - len("-var-vcap") - len(suffix) - 1
+	if len(prefix) > maxPrefix {
+		prefix = strings.TrimRight(prefix[:maxPrefix], "-")
+	}
+	if prefix == "" || suffix == "" {
+		return ""
+	}
+	return prefix + "-" + suffix
+}
+
+// vmCIDFor returns the CID of a VM created with client.
+func vmCIDFor(client kubecluster.Client, vm vmObjects) cpi.VMCID {
+	return cpi.VMCID(cpi.CID{
+		Context:   client.Context(),
+		Namespace: kubecluster.ExplicitNamespace(client),
+		Kind:      cpi.KindPod,
+		ID:        vm.AgentID,
+		Name:      vm.Name,
+	}.String())
+}
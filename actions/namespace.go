@@ -0,0 +1,308 @@
+package actions
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/evoila/kubernetes-cpi/config"
+	"github.com/evoila/kubernetes-cpi/cpi"
+
+	v1 "k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	core "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// PodSecurityEnforceLabel selects the Pod Security Admission level of a
+// namespace.
+const PodSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+
+// ManagedByLabel marks the namespaces the CPI created. Its value is
+// EventComponent.
+const ManagedByLabel = "app.kubernetes.io/managed-by"
+
+// NamespacePolicyName names the quota and limit range the CPI creates in
+// new namespaces.
+const NamespacePolicyName = "bosh"
+
+// boshEnv holds the parts of the "bosh" section of a VM environment the
+// CPI uses.
+type boshEnv struct {
+	Group  string   `json:"group"`
+	Groups []string `json:"groups"`
+}
+
+// parseBoshEnv returns the "bosh" section of env. Environments without one,
+// or with one of an unexpected shape, return an empty section.
+func parseBoshEnv(env cpi.Environment) boshEnv {
+	var parsed struct {
+		Bosh boshEnv `json:"bosh"`
+	}
+	if err := cpi.Remarshal(env, &parsed); err != nil {
+		return boshEnv{}
+	}
+	return parsed.Bosh
+}
+
+// NamespaceTemplateData is available to the namespace template.
+type NamespaceTemplateData struct {
+	Group         string
+	Director      string
+	Deployment    string
+	InstanceGroup string
+}
+
+// boshIdentity returns the group, director, deployment and instance group
+// names of a VM from its environment.
+func boshIdentity(env cpi.Environment) NamespaceTemplateData {
+	bosh := parseBoshEnv(env)
+	data := NamespaceTemplateData{Group: bosh.Group}
+	for i, name := range bosh.Groups {
+		switch i {
+		case 0:
+			data.Director = name
+		case 1:
+			data.Deployment = name
+		case 2:
+			data.InstanceGroup = name
+		}
+	}
+	return data
+}
+
+// vmNamespace returns the namespace of a new VM. It is empty when the VM
+// belongs in the namespace of its context.
+func vmNamespace(conf *config.Namespaces, cloudProps VMCloudProperties, env cpi.Environment) (string, error) {
+	if cloudProps.Namespace != "" {
+		return cloudProps.Namespace, nil
+	}
+	if conf == nil || conf.Template == "" {
+		return "", nil
+	}
+
+	tmpl, err := template.New("namespace").Option("missingkey=error").Parse(conf.Template)
+	if err != nil {
+		return "", fmt.Errorf("parsing namespace template: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, boshIdentity(env)); err != nil {
+		return "", fmt.Errorf("rendering namespace template: %s", err)
+	}
+
+	namespace := sanitizeDNSLabel(buf.String())
+	if errs := validation.IsDNS1123Label(namespace); len(errs) != 0 {
+		return "", fmt.Errorf("namespace template rendered %q, which is not a valid namespace: %s", buf.String(), strings.Join(errs, ", "))
+	}
+	return namespace, nil
+}
+
+// sanitizeDNSLabel turns a string into a DNS-1123 label by lowercasing it
+// and replacing anything but letters, digits and dashes.
+func sanitizeDNSLabel(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r - 'A' + 'a'
+		default:
+			return '-'
+		}
+	}, s)
+
+	if len(s) > validation.DNS1123LabelMaxLength {
+		s = s[:validation.DNS1123LabelMaxLength]
+	}
+	return strings.Trim(s, "-")
+}
+
+// createNamespace makes sure namespace exists. Namespaces created here get
+// the labels, pod security level, quota and limit range of conf and are
+// marked as managed by the CPI. The quota and limit range of a managed
+// namespace are reconciled with conf every time, so a namespace left behind
+// by a failed create_vm gets them on the next attempt. Namespaces the CPI
+// did not create are left alone.
+func createNamespace(ctx context.Context, coreClient core.CoreV1Interface, namespace string, conf *config.Namespaces) error {
+	if conf == nil {
+		conf = &config.Namespaces{}
+	}
+
+	// Build everything up front so a bad configuration does not leave a
+	// namespace without its policy behind.
+	quota, err := newResourceQuota(ctx, namespace, conf.ResourceQuota)
+	if err != nil {
+		return err
+	}
+	limits, err := newLimitRange(ctx, namespace, conf.LimitRange)
+	if err != nil {
+		return err
+	}
+
+	existing, err := coreClient.Namespaces().Get(namespace, metav1.GetOptions{})
+	switch {
+	case err == nil:
+		if existing.Labels[ManagedByLabel] != EventComponent {
+			return nil
+		}
+	case isNotFoundStatusError(err):
+		err = createManagedNamespace(ctx, coreClient, namespace, conf)
+		if err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	if quota != nil {
+		if err := applyResourceQuota(coreClient.ResourceQuotas(namespace), quota); err != nil {
+			return err
+		}
+	}
+	if limits != nil {
+		if err := applyLimitRange(coreClient.LimitRanges(namespace), limits); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// createManagedNamespace creates a namespace labelled as managed by the
+// CPI. A namespace created concurrently by another request is fine.
+func createManagedNamespace(ctx context.Context, coreClient core.CoreV1Interface, namespace string, conf *config.Namespaces) error {
+	labels := map[string]string{}
+	for k, v := range conf.Labels {
+		labels[k] = v
+	}
+	if conf.PodSecurity != "" {
+		labels[PodSecurityEnforceLabel] = conf.PodSecurity
+	}
+	labels[ManagedByLabel] = EventComponent
+
+	_, err := coreClient.Namespaces().Create(&v1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: namespace, Labels: directorLabels(ctx, labels)},
+	})
+	if statusError, ok := err.(*kubeerrors.StatusError); ok {
+		if statusError.Status().Reason == metav1.StatusReasonAlreadyExists {
+			return nil
+		}
+	}
+	return err
+}
+
+// applyResourceQuota creates quota or updates the existing quota of the
+// same name to match it.
+func applyResourceQuota(quotaClient core.ResourceQuotaInterface, quota *v1.ResourceQuota) error {
+	existing, err := quotaClient.Get(quota.Name, metav1.GetOptions{})
+	if isNotFoundStatusError(err) {
+		_, err = quotaClient.Create(quota)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	existing.Labels = mergeLabels(existing.Labels, quota.Labels)
+	existing.Spec = quota.Spec
+	_, err = quotaClient.Update(existing)
+	return err
+}
+
+// applyLimitRange creates limits or updates the existing limit range of
+// the same name to match it.
+func applyLimitRange(limitClient core.LimitRangeInterface, limits *v1.LimitRange) error {
+	existing, err := limitClient.Get(limits.Name, metav1.GetOptions{})
+	if isNotFoundStatusError(err) {
+		_, err = limitClient.Create(limits)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	existing.Labels = mergeLabels(existing.Labels, limits.Labels)
+	existing.Spec = limits.Spec
+	_, err = limitClient.Update(existing)
+	return err
+}
+
+func mergeLabels(labels, overlay map[string]string) map[string]string {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	for k, v := range overlay {
+		labels[k] = v
+	}
+	return labels
+}
+
+func newResourceQuota(ctx context.Context, namespace string, hard map[string]string) (*v1.ResourceQuota, error) {
+	if len(hard) == 0 {
+		return nil, nil
+	}
+
+	list, err := namespaceResourceList("resource_quota", hard)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.ResourceQuota{
+		ObjectMeta: metav1.ObjectMeta{Name: NamespacePolicyName, Namespace: namespace, Labels: directorLabels(ctx, map[string]string{})},
+		Spec:       v1.ResourceQuotaSpec{Hard: list},
+	}, nil
+}
+
+func newLimitRange(ctx context.Context, namespace string, limits config.LimitRange) (*v1.LimitRange, error) {
+	if limits.IsEmpty() {
+		return nil, nil
+	}
+
+	item := v1.LimitRangeItem{Type: v1.LimitTypeContainer}
+	var err error
+	if item.Default, err = namespaceResourceList("limit_range.default", limits.Default); err != nil {
+		return nil, err
+	}
+	if item.DefaultRequest, err = namespaceResourceList("limit_range.default_request", limits.DefaultRequest); err != nil {
+		return nil, err
+	}
+	if item.Max, err = namespaceResourceList("limit_range.max", limits.Max); err != nil {
+		return nil, err
+	}
+	if item.Min, err = namespaceResourceList("limit_range.min", limits.Min); err != nil {
+		return nil, err
+	}
+
+	return &v1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: NamespacePolicyName, Namespace: namespace, Labels: directorLabels(ctx, map[string]string{})},
+		Spec:       v1.LimitRangeSpec{Limits: []v1.LimitRangeItem{item}},
+	}, nil
+}
+
+func namespaceResourceList(path string, values map[string]string) (v1.ResourceList, error) {
+	if len(values) == 0 {
+		return nil, nil
+	}
+
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	list := v1.ResourceList{}
+	for _, name := range names {
+		quantity, err := resource.ParseQuantity(values[name])
+		if err != nil {
+			return nil, fmt.Errorf("namespaces.%s.%s: %q is not a valid quantity", path, name, values[name])
+		}
+		list[v1.ResourceName(name)] = quantity
+	}
+	return list, nil
+}
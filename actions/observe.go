@@ -0,0 +1,116 @@
+package actions
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/evoila/kubernetes-cpi/kubecluster"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Wait phases reported to a WaitObserver.
+const (
+	PhaseClaimBound = "claim_bound"
+	PhasePodReady   = "pod_ready"
+)
+
+// WaitObserver is told how long an action waited for Kubernetes in a phase
+// and whether the wait failed.
+type WaitObserver func(phase, contextName string, duration time.Duration, err error)
+
+type waitObserverKey struct{}
+
+// WithWaitObserver returns a context that reports the waits of the actions
+// handling a request to observe.
+func WithWaitObserver(ctx context.Context, observe WaitObserver) context.Context {
+	return context.WithValue(ctx, waitObserverKey{}, observe)
+}
+
+func observeWait(ctx context.Context, phase, contextName string, duration time.Duration, err error) {
+	if observe, ok := ctx.Value(waitObserverKey{}).(WaitObserver); ok && observe != nil {
+		observe(phase, contextName, duration, err)
+	}
+}
+
+// RequestInfo collects what the actions learn about a request while it is
+// handled, for middleware that reports on it afterwards.
+type RequestInfo struct {
+	mutex       sync.Mutex
+	contextName string
+}
+
+type requestInfoKey struct{}
+
+// WithRequestInfo returns a context the actions record the RequestInfo of
+// a request in. The RequestInfo already carried by ctx is shared.
+func WithRequestInfo(ctx context.Context) (context.Context, *RequestInfo) {
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		return ctx, info
+	}
+
+	info := &RequestInfo{}
+	return context.WithValue(ctx, requestInfoKey{}, info), info
+}
+
+// ContextName is the Kubernetes context the request was served by. It is
+// empty when no client was created.
+func (i *RequestInfo) ContextName() string {
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	return i.contextName
+}
+
+// observingProvider records the context of every client created for a
+// request in its RequestInfo.
+type observingProvider struct {
+	kubecluster.ClientProvider
+}
+
+func (p observingProvider) New(ctx context.Context, contextName string) (kubecluster.Client, error) {
+	client, err := p.ClientProvider.New(ctx, contextName)
+	if err != nil {
+		return nil, err
+	}
+
+	if info, ok := ctx.Value(requestInfoKey{}).(*RequestInfo); ok {
+		info.mutex.Lock()
+		info.contextName = client.Context()
+		info.mutex.Unlock()
+	}
+	return client, nil
+}
+
+var tracer = otel.Tracer("github.com/evoila/kubernetes-cpi/actions")
+
+// startStep starts the span of a step of an action. The span is a no-op
+// unless a tracer provider has been installed.
+func startStep(ctx context.Context, name string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name)
+}
+
+// endStep ends the span of a step and records its error.
+func endStep(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// withoutCancel returns a context that carries the values of ctx, such as
+// its span, but is never cancelled.
+func withoutCancel(ctx context.Context) context.Context {
+	return detachedContext{ctx}
+}
+
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}               { return nil }
+func (detachedContext) Err() error                          { return nil }
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
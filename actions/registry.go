@@ -0,0 +1,203 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/evoila/kubernetes-cpi/config"
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/kubecluster"
+)
+
+// Handler serves a single CPI request. Work done for the request stops when
+// ctx is done.
+type Handler func(ctx context.Context, req *cpi.Request) (*cpi.Response, error)
+
+// Middleware wraps a handler with additional behavior.
+type Middleware func(next Handler) Handler
+
+// Dependencies holds everything the actions are built from. One container
+// is shared by all handlers of a registry.
+type Dependencies struct {
+	AgentConfig       *config.Agent
+	CPIConfig         *config.CPI
+	ClientProvider    kubecluster.ClientProvider
+	Clock             clock.Clock
+	GUIDGeneratorFunc func() (string, error)
+}
+
+// Registry maps CPI method names to handlers. Requests are passed through
+// the registered middleware before they reach the handler.
+type Registry struct {
+	handlers   map[string]Handler
+	middleware []Middleware
+}
+
+func NewRegistry() *Registry {
+	return &Registry{handlers: map[string]Handler{}}
+}
+
+// Register dispatches requests for the method to an action function. See
+// cpi.Dispatch for the functions that are supported.
+func (r *Registry) Register(method string, actionFunc interface{}) {
+	r.RegisterHandler(method, DispatchHandler(actionFunc))
+}
+
+// RegisterHandler serves requests for the method with a handler. A handler
+// registered earlier for the same method is replaced.
+func (r *Registry) RegisterHandler(method string, handler Handler) {
+	r.handlers[method] = handler
+}
+
+// Use appends middleware to the chain. The first middleware is the
+// outermost.
+func (r *Registry) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
+}
+
+// Methods returns the sorted names of the registered methods.
+func (r *Registry) Methods() []string {
+	var methods []string
+	for method := range r.handlers {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Handle serves a request with the handler registered for its method.
+func (r *Registry) Handle(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+	handler, ok := r.handlers[req.Method]
+	if !ok {
+		handler = func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			return nil, fmt.Errorf("Unexpected method: %q", req.Method)
+		}
+	}
+
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+
+	return handler(ctx, req)
+}
+
+// DispatchHandler returns a handler that calls an action function with the
+// request arguments.
+func DispatchHandler(actionFunc interface{}) Handler {
+	return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+		return cpi.DispatchContext(ctx, req, actionFunc)
+	}
+}
+
+// ErrorHandler returns a handler that always responds with err.
+func ErrorHandler(err error) Handler {
+	return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+		return cpi.NewErrorResponse(err), nil
+	}
+}
+
+// ResolveCloudProperties returns a handler that replaces the argument at
+// index with the result of resolve before calling next. It is used to apply
+// the defaults and profiles of the CPI configuration.
+func ResolveCloudProperties(index int, resolve func(interface{}) (map[string]interface{}, error), next Handler) Handler {
+	return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+		if len(req.Args) > index {
+			cloudProps, err := resolve(req.Args[index])
+			if err != nil {
+				return nil, &cpi.ArgumentError{Index: index, Err: err}
+			}
+			req.Args[index] = cloudProps
+		}
+		return next(ctx, req)
+	}
+}
+
+// NewDefaultRegistry returns a registry with every CPI method registered.
+func NewDefaultRegistry(deps *Dependencies) *Registry {
+	cpiConf := deps.CPIConfig
+	if cpiConf == nil {
+		cpiConf = config.DefaultCPI()
+	}
+
+	guidGenerator := deps.GUIDGeneratorFunc
+	if guidGenerator == nil {
+		guidGenerator = CreateGUID
+	}
+
+	clk := deps.Clock
+	if clk == nil {
+		clk = clock.NewClock()
+	}
+
+	// Clients are recorded in the RequestInfo of the request they are
+	// created for.
+	var clientProvider kubecluster.ClientProvider
+	if deps.ClientProvider != nil {
+		clientProvider = observingProvider{deps.ClientProvider}
+	}
+
+	var events *EventRecorder
+	if !cpiConf.DisableEvents {
+		events = &EventRecorder{Clock: clk}
+	}
+
+	vmCreator := &VMCreator{
+		AgentConfig:    deps.AgentConfig,
+		ClientProvider: clientProvider,
+		Events:         events,
+		Namespaces:     &cpiConf.Namespaces,
+		ReadableNames:  cpiConf.ReadablePodNames,
+	}
+	vmDeleter := &VMDeleter{ClientProvider: clientProvider, Events: events}
+	vmFinder := &VMFinder{ClientProvider: clientProvider, AdoptUnlabeled: cpiConf.AdoptUnlabeledObjects}
+	vmMetadataSetter := &VMMetadataSetter{ClientProvider: clientProvider, Events: events}
+	diskCreator := &DiskCreator{
+		ClientProvider:    clientProvider,
+		GUIDGeneratorFunc: guidGenerator,
+		Events:            events,
+		Namespaces:        &cpiConf.Namespaces,
+	}
+	diskFinder := &DiskFinder{ClientProvider: clientProvider, AdoptUnlabeled: cpiConf.AdoptUnlabeledObjects}
+	diskDeleter := &DiskDeleter{ClientProvider: clientProvider, Events: events}
+	diskGetter := &DiskGetter{ClientProvider: clientProvider, AdoptUnlabeled: cpiConf.AdoptUnlabeledObjects}
+	diskMetadataSetter := &DiskMetadataSetter{ClientProvider: clientProvider, Events: events}
+	volumeManager := &VolumeManager{
+		ClientProvider:    clientProvider,
+		Events:            events,
+		Clock:             clk,
+		PodReadyTimeout:   cpiConf.PodReadyTimeout.Duration,
+		PostRecreateDelay: cpiConf.PostRecreateDelay.Duration,
+	}
+
+	r := NewRegistry()
+
+	// Stemcell management
+	r.Register("create_stemcell", CreateStemcell)
+	r.Register("delete_stemcell", DeleteStemcell)
+	r.Register("info", Info)
+
+	// VM management
+	r.RegisterHandler("create_vm", ResolveCloudProperties(2, cpiConf.VMCloudProperties, DispatchHandler(vmCreator.Create)))
+	r.Register("delete_vm", vmDeleter.Delete)
+	r.Register("has_vm", vmFinder.HasVM)
+	r.Register("set_vm_metadata", vmMetadataSetter.SetVMMetadata)
+
+	// Disk management
+	r.RegisterHandler("create_disk", ResolveCloudProperties(1, cpiConf.DiskCloudProperties, DispatchHandler(diskCreator.CreateDisk)))
+	r.Register("attach_disk", volumeManager.AttachDisk)
+	r.Register("has_disk", diskFinder.HasDisk)
+	r.Register("delete_disk", diskDeleter.DeleteDisk)
+	r.Register("detach_disk", volumeManager.DetachDisk)
+	r.Register("get_disks", diskGetter.GetDisks)
+	r.Register("set_disk_metadata", diskMetadataSetter.SetDiskMetadata)
+
+	// Not implemented
+	r.RegisterHandler("configure_networks", ErrorHandler(&cpi.NotSupportedError{}))
+	r.RegisterHandler("reboot_vm", ErrorHandler(&cpi.NotSupportedError{}))
+	r.RegisterHandler("snapshot_disk", ErrorHandler(&cpi.NotImplementedError{}))
+	r.RegisterHandler("delete_snapshot", ErrorHandler(&cpi.NotImplementedError{}))
+
+	return r
+}
@@ -0,0 +1,137 @@
+package actions_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/config"
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/kubecluster/fakes"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Registry", func() {
+	var registry *actions.Registry
+
+	BeforeEach(func() {
+		registry = actions.NewRegistry()
+		registry.Register("echo", func(s string) (string, error) { return s, nil })
+	})
+
+	It("dispatches requests to the registered action", func() {
+		resp, err := registry.Handle(context.Background(), &cpi.Request{Method: "echo", Args: []interface{}{"hello"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Result).To(Equal("hello"))
+	})
+
+	It("returns an error for unknown methods", func() {
+		_, err := registry.Handle(context.Background(), &cpi.Request{Method: "missing"})
+		Expect(err).To(MatchError(`Unexpected method: "missing"`))
+	})
+
+	It("lists the registered methods", func() {
+		registry.Register("another", func() error { return nil })
+		Expect(registry.Methods()).To(Equal([]string{"another", "echo"}))
+	})
+
+	It("applies middleware in order", func() {
+		var calls []string
+		trace := func(name string) actions.Middleware {
+			return func(next actions.Handler) actions.Handler {
+				return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+					calls = append(calls, name)
+					return next(ctx, req)
+				}
+			}
+		}
+		registry.Use(trace("outer"), trace("inner"))
+
+		_, err := registry.Handle(context.Background(), &cpi.Request{Method: "echo", Args: []interface{}{"hello"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(Equal([]string{"outer", "inner"}))
+	})
+
+	It("recovers panics of later middleware when Recover is used first", func() {
+		registry.Use(actions.Recover(), func(next actions.Handler) actions.Handler {
+			return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				panic("boom")
+			}
+		})
+
+		_, err := registry.Handle(context.Background(), &cpi.Request{Method: "echo", Args: []interface{}{"hello"}})
+		Expect(err).To(MatchError("echo panicked: boom"))
+	})
+
+	Describe("ResolveCloudProperties", func() {
+		It("replaces the argument before dispatching", func() {
+			handler := actions.ResolveCloudProperties(0, func(interface{}) (map[string]interface{}, error) {
+				return map[string]interface{}{"resolved": true}, nil
+			}, actions.DispatchHandler(func(props map[string]interface{}) (map[string]interface{}, error) {
+				return props, nil
+			}))
+
+			resp, err := handler(context.Background(), &cpi.Request{Args: []interface{}{map[string]interface{}{}}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(Equal(map[string]interface{}{"resolved": true}))
+		})
+
+		It("names the argument when resolving fails", func() {
+			handler := actions.ResolveCloudProperties(1, func(interface{}) (map[string]interface{}, error) {
+				return nil, errors.New(`profile "large" is not defined`)
+			}, nil)
+
+			_, err := handler(context.Background(), &cpi.Request{Args: []interface{}{"a", "b"}})
+			Expect(err).To(MatchError(`Invalid argument 1: profile "large" is not defined`))
+		})
+	})
+
+	Describe("NewDefaultRegistry", func() {
+		BeforeEach(func() {
+			registry = actions.NewDefaultRegistry(&actions.Dependencies{
+				AgentConfig:    &config.Agent{},
+				ClientProvider: &fakes.ClientProvider{},
+			})
+		})
+
+		It("registers every CPI method", func() {
+			Expect(registry.Methods()).To(ConsistOf(
+				"create_stemcell", "delete_stemcell", "info",
+				"create_vm", "delete_vm", "has_vm", "set_vm_metadata",
+				"create_disk", "attach_disk", "has_disk", "delete_disk", "detach_disk", "get_disks", "set_disk_metadata",
+				"configure_networks", "reboot_vm", "snapshot_disk", "delete_snapshot",
+			))
+		})
+
+		It("records the context of the clients in the request info", func() {
+			fakeClient := fakes.NewClient()
+			fakeClient.ContextReturns("bosh")
+			fakeProvider := &fakes.ClientProvider{}
+			fakeProvider.NewReturns(fakeClient, nil)
+
+			registry = actions.NewDefaultRegistry(&actions.Dependencies{
+				AgentConfig:    &config.Agent{},
+				ClientProvider: fakeProvider,
+			})
+
+			ctx, info := actions.WithRequestInfo(context.Background())
+			_, err := registry.Handle(ctx, &cpi.Request{Method: "has_vm", Args: []interface{}{"bosh:1234"}})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(info.ContextName()).To(Equal("bosh"))
+		})
+
+		It("shares the request info of outer middleware", func() {
+			ctx, outer := actions.WithRequestInfo(context.Background())
+			_, inner := actions.WithRequestInfo(ctx)
+			Expect(inner).To(BeIdenticalTo(outer))
+		})
+
+		It("responds with the Bosh error type of unsupported methods", func() {
+			resp, err := registry.Handle(context.Background(), &cpi.Request{Method: "reboot_vm"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Error).To(Equal(&cpi.ResponseError{Type: "Bosh::Clouds::NotSupported", Message: "Not supported"}))
+		})
+	})
+})
@@ -0,0 +1,21 @@
+package actions
+
+import "github.com/evoila/kubernetes-cpi/cpi"
+
+// NetworkCloudProperties are the network cloud properties understood by the
+// CPI. Pods are attached to the cluster network, so there are none.
+type NetworkCloudProperties struct{}
+
+// CloudPropertiesSchema returns a JSON Schema document that defines the
+// cloud properties of vm types, disk types, networks and stemcells.
+func CloudPropertiesSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"$schema": cpi.SchemaDraft,
+		"definitions": map[string]interface{}{
+			"vm_type":   cpi.Schema(VMCloudProperties{}),
+			"disk_type": cpi.Schema(CreateDiskCloudProperties{}),
+			"network":   cpi.Schema(NetworkCloudProperties{}),
+			"stemcell":  cpi.Schema(StemcellCloudProperties{}),
+		},
+	}
+}
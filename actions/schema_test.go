@@ -0,0 +1,45 @@
+package actions_test
+
+import (
+	"github.com/evoila/kubernetes-cpi/actions"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CloudPropertiesSchema", func() {
+	var definitions map[string]interface{}
+
+	BeforeEach(func() {
+		schema := actions.CloudPropertiesSchema()
+		Expect(schema).To(HaveKeyWithValue("$schema", "http://json-schema.org/draft-07/schema#"))
+		definitions = schema["definitions"].(map[string]interface{})
+	})
+
+	It("defines every cloud property type", func() {
+		Expect(definitions).To(HaveKey("vm_type"))
+		Expect(definitions).To(HaveKey("disk_type"))
+		Expect(definitions).To(HaveKey("network"))
+		Expect(definitions).To(HaveKey("stemcell"))
+	})
+
+	It("does not allow unknown vm type properties", func() {
+		vmType := definitions["vm_type"].(map[string]interface{})
+		Expect(vmType).To(HaveKeyWithValue("additionalProperties", false))
+
+		properties := vmType["properties"].(map[string]interface{})
+		Expect(properties).To(HaveKey("ephemeral_disk_size"))
+		Expect(properties["image_pull_policy"]).To(HaveKeyWithValue("enum", []interface{}{"Always", "IfNotPresent", "Never"}))
+	})
+
+	It("lists the supported volume modes", func() {
+		diskType := definitions["disk_type"].(map[string]interface{})
+		properties := diskType["properties"].(map[string]interface{})
+		Expect(properties["volume_mode"]).To(HaveKeyWithValue("enum", []interface{}{"Filesystem", "Block"}))
+	})
+
+	It("allows the stemcell manifest fields", func() {
+		stemcell := definitions["stemcell"].(map[string]interface{})
+		Expect(stemcell).NotTo(HaveKey("additionalProperties"))
+	})
+})
@@ -1,61 +1,61 @@
 package actions
 
 import (
-	"encoding/json"
-	"strings"
+	"context"
+	"fmt"
 
 	"github.com/evoila/kubernetes-cpi/cpi"
 	"github.com/evoila/kubernetes-cpi/kubecluster"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/strategicpatch"
-	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 type DiskMetadataSetter struct {
 	ClientProvider kubecluster.ClientProvider
+	Events         *EventRecorder
 }
 
-func (v *DiskMetadataSetter) SetDiskMetadata(diskcid cpi.DiskCID, metadata map[string]string) error {
-	context, diskID := ParseDiskCID(diskcid)
-
-	client, err := v.ClientProvider.New(context)
+func (v *DiskMetadataSetter) SetDiskMetadata(ctx context.Context, diskcid cpi.DiskCID, metadata map[string]string) error {
+	cid, err := diskcid.Parse()
 	if err != nil {
 		return err
 	}
+	diskID := cid.ID
 
-	coreClient := client.Core()
-	volume, err := coreClient.PersistentVolumeClaims(client.Namespace()).Get("disk-"+diskID, metav1.GetOptions{})
+	client, err := newClient(ctx, v.ClientProvider, cid.Context, cid.Namespace)
 	if err != nil {
 		return err
 	}
 
-	old, err := json.Marshal(volume)
+	coreClient := client.Core()
+	volume, err := coreClient.PersistentVolumeClaims(client.Namespace()).Get("disk-"+diskID, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
 
-	for k, v := range metadata {
-		k = "bosh.cloudfoundry.org/" + strings.ToLower(k)
-		if len(validation.IsQualifiedName(k)) == 0 && len(validation.IsValidLabelValue(v)) == 0 {
-			volume.ObjectMeta.Labels[k] = v
-		}
-	}
-
-	new, err := json.Marshal(volume)
+	patch, err := metadataPatch(metadata)
 	if err != nil {
 		return err
 	}
 
-	patch, err := strategicpatch.CreateTwoWayMergePatch(old, new, volume)
+	_, err = coreClient.PersistentVolumeClaims(client.Namespace()).Patch(volume.Name, types.MergePatchType, patch)
 	if err != nil {
 		return err
 	}
 
-	_, err = coreClient.PersistentVolumeClaims(client.Namespace()).Patch(volume.Name, types.StrategicMergePatchType, patch)
-	if err != nil {
-		return err
+	// Claims that are still pending have no volume yet. Patching volumes
+	// needs a cluster-scoped permission the CPI may not have been granted;
+	// the claim carries the metadata either way, so the event notes it.
+	var skipped string
+	if volume.Spec.VolumeName != "" {
+		_, err = coreClient.PersistentVolumes().Patch(volume.Spec.VolumeName, types.MergePatchType, patch)
+		if isForbiddenStatusError(err) {
+			skipped = fmt.Sprintf("; persistent volume %s not updated: %s", volume.Spec.VolumeName, err)
+		} else if err != nil && !isNotFoundStatusError(err) {
+			return err
+		}
 	}
 
+	v.Events.Record(client, objectReference("PersistentVolumeClaim", volume.ObjectMeta), EventReasonMetadataUpdated, "Set metadata of disk %s: %s%s", diskcid, metadataKeys(metadata), skipped)
 	return nil
 }
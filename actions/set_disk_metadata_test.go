@@ -0,0 +1,165 @@
+package actions_test
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/kubecluster/fakes"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	kubeerrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/testing"
+)
+
+var _ = Describe("SetDiskMetadata", func() {
+	var (
+		fakeClient   *fakes.Client
+		fakeProvider *fakes.ClientProvider
+		diskCID      cpi.DiskCID
+		metadata     map[string]string
+
+		diskMetadataSetter *actions.DiskMetadataSetter
+	)
+
+	BeforeEach(func() {
+		fakeClient = fakes.NewClient()
+		fakeClient.ContextReturns("bosh")
+		fakeClient.NamespaceReturns("bosh-namespace")
+
+		fakeProvider = &fakes.ClientProvider{}
+		fakeProvider.NewReturns(fakeClient, nil)
+
+		diskCID = cpi.NewDiskCID("bosh", "", "disk-id")
+		metadata = map[string]string{
+			"director":         "bosh-init",
+			"attached_at":      "2017-06-12T10:27:15Z",
+			"invalid key name": "good-value",
+		}
+
+		fakeClient.SetObjects(
+			&v1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{Name: "disk-disk-id", Namespace: "bosh-namespace"},
+				Spec:       v1.PersistentVolumeClaimSpec{VolumeName: "pv-name"},
+			},
+			&v1.PersistentVolume{ObjectMeta: metav1.ObjectMeta{Name: "pv-name"}},
+		)
+
+		diskMetadataSetter = &actions.DiskMetadataSetter{ClientProvider: fakeProvider}
+	})
+
+	It("gets a client for the appropriate context", func() {
+		err := diskMetadataSetter.SetDiskMetadata(context.Background(), diskCID, metadata)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(fakeProvider.NewCallCount()).To(Equal(1))
+		_, contextName := fakeProvider.NewArgsForCall(0)
+		Expect(contextName).To(Equal("bosh"))
+	})
+
+	It("labels and annotates the claim and omits invalid keys", func() {
+		err := diskMetadataSetter.SetDiskMetadata(context.Background(), diskCID, metadata)
+		Expect(err).NotTo(HaveOccurred())
+
+		claim, err := fakeClient.Core().PersistentVolumeClaims("bosh-namespace").Get("disk-disk-id", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claim.Labels).To(Equal(map[string]string{
+			"bosh.cloudfoundry.org/director": "bosh-init",
+		}))
+		Expect(claim.Annotations).To(Equal(map[string]string{
+			"bosh.cloudfoundry.org/attached_at": "2017-06-12T10:27:15Z",
+		}))
+	})
+
+	It("labels and annotates the bound volume", func() {
+		err := diskMetadataSetter.SetDiskMetadata(context.Background(), diskCID, metadata)
+		Expect(err).NotTo(HaveOccurred())
+
+		matches := fakeClient.MatchingActions("patch", "persistentvolumes")
+		Expect(matches).To(HaveLen(1))
+		Expect(matches[0].(testing.PatchActionImpl).GetName()).To(Equal("pv-name"))
+
+		volume, err := fakeClient.Core().PersistentVolumes().Get("pv-name", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(volume.Labels).To(Equal(map[string]string{
+			"bosh.cloudfoundry.org/director": "bosh-init",
+		}))
+		Expect(volume.Annotations).To(Equal(map[string]string{
+			"bosh.cloudfoundry.org/attached_at": "2017-06-12T10:27:15Z",
+		}))
+	})
+
+	Context("when the claim is not bound", func() {
+		BeforeEach(func() {
+			fakeClient.SetObjects(
+				&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "disk-disk-id", Namespace: "bosh-namespace"}},
+			)
+		})
+
+		It("only patches the claim", func() {
+			err := diskMetadataSetter.SetDiskMetadata(context.Background(), diskCID, metadata)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(fakeClient.MatchingActions("patch", "persistentvolumeclaims")).To(HaveLen(1))
+			Expect(fakeClient.MatchingActions("patch", "persistentvolumes")).To(BeEmpty())
+		})
+	})
+
+	Context("when getting the claim fails", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("get", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("get-pvcs-welp")
+			})
+		})
+
+		It("returns an error", func() {
+			err := diskMetadataSetter.SetDiskMetadata(context.Background(), diskCID, metadata)
+			Expect(err).To(MatchError("get-pvcs-welp"))
+		})
+	})
+
+	Context("when the CPI may not patch volumes", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("patch", "persistentvolumes", func(action testing.Action) (bool, runtime.Object, error) {
+				gr := schema.GroupResource{Group: "", Resource: "persistentvolumes"}
+				return true, nil, kubeerrors.NewForbidden(gr, "pv-name", errors.New("no cluster role"))
+			})
+			diskMetadataSetter.Events = &actions.EventRecorder{Clock: fakeclock.NewFakeClock(time.Now())}
+		})
+
+		It("labels the claim and notes the volume in the event", func() {
+			err := diskMetadataSetter.SetDiskMetadata(context.Background(), diskCID, metadata)
+			Expect(err).NotTo(HaveOccurred())
+
+			claim, err := fakeClient.Core().PersistentVolumeClaims("bosh-namespace").Get("disk-disk-id", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(claim.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/director", "bosh-init"))
+
+			matches := fakeClient.MatchingActions("create", "events")
+			Expect(matches).To(HaveLen(1))
+			event := matches[0].(testing.CreateAction).GetObject().(*v1.Event)
+			Expect(event.Message).To(HavePrefix("Set metadata of disk " + string(diskCID) + ": attached_at, director, invalid key name; persistent volume pv-name not updated: "))
+		})
+	})
+
+	Context("when patching the volume fails", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("patch", "persistentvolumes", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("patch-pvs-welp")
+			})
+		})
+
+		It("returns an error", func() {
+			err := diskMetadataSetter.SetDiskMetadata(context.Background(), diskCID, metadata)
+			Expect(err).To(MatchError("patch-pvs-welp"))
+		})
+	})
+})
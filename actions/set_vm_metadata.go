@@ -1,60 +1,51 @@
 package actions
 
 import (
-	"encoding/json"
-	"strings"
+	"context"
 
 	"github.com/evoila/kubernetes-cpi/cpi"
 	"github.com/evoila/kubernetes-cpi/kubecluster"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/strategicpatch"
-	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 type VMMetadataSetter struct {
 	ClientProvider kubecluster.ClientProvider
+	Events         *EventRecorder
 }
 
-func (v *VMMetadataSetter) SetVMMetadata(vmcid cpi.VMCID, metadata map[string]string) error {
-	context, agentID := ParseVMCID(vmcid)
-
-	client, err := v.ClientProvider.New(context)
+func (v *VMMetadataSetter) SetVMMetadata(ctx context.Context, vmcid cpi.VMCID, metadata map[string]string) error {
+	cid, err := vmcid.Parse()
 	if err != nil {
 		return err
 	}
+	vm := vmObjectsFor(cid)
 
-	pod, err := client.Pods().Get("agent-"+agentID, metav1.GetOptions{})
+	client, err := newClient(ctx, v.ClientProvider, cid.Context, cid.Namespace)
 	if err != nil {
 		return err
 	}
 
-	old, err := json.Marshal(pod)
+	pod, err := client.Pods().Get(vm.Pod(), metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
 
-	for k, v := range metadata {
-		k = "bosh.cloudfoundry.org/" + strings.ToLower(k)
-		if len(validation.IsQualifiedName(k)) == 0 && len(validation.IsValidLabelValue(v)) == 0 {
-			pod.ObjectMeta.Labels[k] = v
-		}
-	}
-
-	new, err := json.Marshal(pod)
+	patch, err := metadataPatch(metadata)
 	if err != nil {
 		return err
 	}
 
-	patch, err := strategicpatch.CreateTwoWayMergePatch(old, new, pod)
+	_, err = client.Pods().Patch(pod.Name, types.MergePatchType, patch)
 	if err != nil {
 		return err
 	}
 
-	_, err = client.Pods().Patch(pod.Name, types.StrategicMergePatchType, patch)
+	err = patchVMObjects(client, vm, patch)
 	if err != nil {
 		return err
 	}
 
+	v.Events.Record(client, objectReference("Pod", pod.ObjectMeta), EventReasonMetadataUpdated, "Set metadata of VM %s: %s", vmcid, metadataKeys(metadata))
 	return nil
 }
@@ -1,6 +1,7 @@
 package actions_test
 
 import (
+	"context"
 	"errors"
 
 	"github.com/evoila/kubernetes-cpi/actions"
@@ -11,7 +12,6 @@ import (
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/testing"
 )
 
@@ -33,7 +33,7 @@ var _ = Describe("SetVMMetadata", func() {
 		fakeProvider = &fakes.ClientProvider{}
 		fakeProvider.NewReturns(fakeClient, nil)
 
-		vmcid = actions.NewVMCID("bosh", "agent-id")
+		vmcid = cpi.NewVMCID("bosh", "", "agent-id")
 		metadata = map[string]string{
 			"deployment":       "kube-test-bosh",
 			"director":         "bosh-init",
@@ -43,7 +43,7 @@ var _ = Describe("SetVMMetadata", func() {
 			"valid-key-name":   "***invalid value***",
 		}
 
-		fakeClient.Clientset = *fake.NewSimpleClientset(
+		fakeClient.SetObjects(
 			&v1.Pod{ObjectMeta: metav1.ObjectMeta{
 				Name:      "agent-agent-id",
 				Namespace: "bosh-namespace",
@@ -57,15 +57,16 @@ var _ = Describe("SetVMMetadata", func() {
 	})
 
 	It("gets a client for the appropriate context", func() {
-		err := vmMetadataSetter.SetVMMetadata(vmcid, metadata)
+		err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, metadata)
 		Expect(err).NotTo(HaveOccurred())
 
 		Expect(fakeProvider.NewCallCount()).To(Equal(1))
-		Expect(fakeProvider.NewArgsForCall(0)).To(Equal("bosh"))
+		_, contextName := fakeProvider.NewArgsForCall(0)
+		Expect(contextName).To(Equal("bosh"))
 	})
 
 	It("retrieves the pod", func() {
-		err := vmMetadataSetter.SetVMMetadata(vmcid, metadata)
+		err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, metadata)
 		Expect(err).NotTo(HaveOccurred())
 
 		matches := fakeClient.MatchingActions("get", "pods")
@@ -74,8 +75,8 @@ var _ = Describe("SetVMMetadata", func() {
 		Expect(matches[0].(testing.GetAction).GetName()).To(Equal("agent-agent-id"))
 	})
 
-	It("patches the pod with prefixed labels and omits invalid labels", func() {
-		err := vmMetadataSetter.SetVMMetadata(vmcid, metadata)
+	It("patches the pod with prefixed labels and annotations and omits invalid keys", func() {
+		err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, metadata)
 		Expect(err).NotTo(HaveOccurred())
 
 		matches := fakeClient.MatchingActions("patch", "pods")
@@ -86,14 +87,155 @@ var _ = Describe("SetVMMetadata", func() {
 		Expect(patch.GetPatch()).To(MatchJSON(`{
 				"metadata": {
 					"labels": {
-						"bosh.cloudfoundry.org/deployment": "kube-test-bosh",
 						"bosh.cloudfoundry.org/director": "bosh-init",
 						"bosh.cloudfoundry.org/index": "0",
-						"bosh.cloudfoundry.org/job": "bosh"
+						"bosh.cloudfoundry.org/job": "bosh",
+						"bosh.cloudfoundry.org/valid-key-name": null
+					},
+					"annotations": {
+						"bosh.cloudfoundry.org/director": null,
+						"bosh.cloudfoundry.org/index": null,
+						"bosh.cloudfoundry.org/job": null,
+						"bosh.cloudfoundry.org/valid-key-name": "***invalid value***"
 					}
 				}
 			}`,
 		))
+
+		pod, err := fakeClient.Core().Pods("bosh-namespace").Get("agent-agent-id", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Labels).To(Equal(map[string]string{
+			"key":                            "value",
+			"bosh.cloudfoundry.org/director": "bosh-init",
+			"bosh.cloudfoundry.org/index":    "0",
+			"bosh.cloudfoundry.org/job":      "bosh",
+		}))
+		Expect(pod.Annotations).To(Equal(map[string]string{
+			"bosh.cloudfoundry.org/valid-key-name": "***invalid value***",
+		}))
+	})
+
+	It("applies the metadata to the settings, ephemeral disk and services of the VM", func() {
+		fakeClient.SetObjects(
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"}},
+			&v1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"}},
+			&v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"}},
+			&v1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "var-vcap-agent-id", Namespace: "bosh-namespace"}},
+			&v1.Service{ObjectMeta: metav1.ObjectMeta{
+				Name:      "service-name",
+				Namespace: "bosh-namespace",
+				Labels:    map[string]string{"bosh.cloudfoundry.org/agent-id": "agent-id"},
+			}},
+			&v1.Service{ObjectMeta: metav1.ObjectMeta{
+				Name:      "other-service",
+				Namespace: "bosh-namespace",
+				Labels:    map[string]string{"bosh.cloudfoundry.org/agent-id": "other-agent-id"},
+			}},
+		)
+
+		err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, metadata)
+		Expect(err).NotTo(HaveOccurred())
+
+		pod, err := fakeClient.Core().Pods("bosh-namespace").Get("agent-agent-id", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/job", "bosh"))
+
+		secret, err := fakeClient.Core().Secrets("bosh-namespace").Get("agent-agent-id", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(secret.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/job", "bosh"))
+		Expect(secret.Annotations).To(HaveKeyWithValue("bosh.cloudfoundry.org/valid-key-name", "***invalid value***"))
+
+		configMap, err := fakeClient.Core().ConfigMaps("bosh-namespace").Get("agent-agent-id", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(configMap.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/job", "bosh"))
+
+		claim, err := fakeClient.Core().PersistentVolumeClaims("bosh-namespace").Get("var-vcap-agent-id", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(claim.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/job", "bosh"))
+		Expect(claim.Annotations).To(HaveKeyWithValue("bosh.cloudfoundry.org/valid-key-name", "***invalid value***"))
+
+		service, err := fakeClient.Core().Services("bosh-namespace").Get("service-name", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(service.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/job", "bosh"))
+		Expect(service.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/agent-id", "agent-id"))
+
+		other, err := fakeClient.Core().Services("bosh-namespace").Get("other-service", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(other.Labels).NotTo(HaveKey("bosh.cloudfoundry.org/job"))
+	})
+
+	It("moves a value that is no longer a valid label to the annotations", func() {
+		fakeClient.SetObjects(
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:      "agent-agent-id",
+				Namespace: "bosh-namespace",
+				Labels:    map[string]string{"bosh.cloudfoundry.org/director": "bosh-init"},
+			}},
+		)
+
+		err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, map[string]string{"director": "not a label value"})
+		Expect(err).NotTo(HaveOccurred())
+
+		pod, err := fakeClient.Core().Pods("bosh-namespace").Get("agent-agent-id", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Labels).NotTo(HaveKey("bosh.cloudfoundry.org/director"))
+		Expect(pod.Annotations).To(HaveKeyWithValue("bosh.cloudfoundry.org/director", "not a label value"))
+	})
+
+	It("leaves the labels and annotations of the CPI alone", func() {
+		labels := map[string]string{
+			actions.DeploymentLabel:          "cf",
+			actions.InstanceGroupLabel:       "database",
+			actions.DirectorUUIDLabel:        "director-uuid",
+			"bosh.cloudfoundry.org/agent-id": "agent-id",
+		}
+		annotations := map[string]string{"bosh.cloudfoundry.org/ip-address": "10.0.0.5"}
+		fakeClient.SetObjects(
+			&v1.Pod{ObjectMeta: metav1.ObjectMeta{
+				Name:        "agent-agent-id",
+				Namespace:   "bosh-namespace",
+				Labels:      labels,
+				Annotations: annotations,
+			}},
+		)
+
+		err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, map[string]string{
+			"deployment":     "not a label value",
+			"instance-group": "other",
+			"director-uuid":  "other-uuid",
+			"agent-id":       "other-agent",
+			"ip-address":     "10.0.0.6",
+			"job":            "bosh",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		pod, err := fakeClient.Core().Pods("bosh-namespace").Get("agent-agent-id", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Labels).To(Equal(map[string]string{
+			actions.DeploymentLabel:          "cf",
+			actions.InstanceGroupLabel:       "database",
+			actions.DirectorUUIDLabel:        "director-uuid",
+			"bosh.cloudfoundry.org/agent-id": "agent-id",
+			"bosh.cloudfoundry.org/job":      "bosh",
+		}))
+		Expect(pod.Annotations).To(Equal(annotations))
+	})
+
+	Context("when the pod has no labels", func() {
+		BeforeEach(func() {
+			fakeClient.SetObjects(
+				&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"}},
+			)
+		})
+
+		It("labels the pod", func() {
+			err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, metadata)
+			Expect(err).NotTo(HaveOccurred())
+
+			pod, err := fakeClient.Core().Pods("bosh-namespace").Get("agent-agent-id", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pod.Labels).To(HaveKeyWithValue("bosh.cloudfoundry.org/job", "bosh"))
+		})
 	})
 
 	Context("when getting the client fails", func() {
@@ -102,7 +244,7 @@ var _ = Describe("SetVMMetadata", func() {
 		})
 
 		It("gets a client for the appropriate context", func() {
-			err := vmMetadataSetter.SetVMMetadata(vmcid, metadata)
+			err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, metadata)
 			Expect(err).To(MatchError("boom"))
 		})
 	})
@@ -115,12 +257,25 @@ var _ = Describe("SetVMMetadata", func() {
 		})
 
 		It("returns an error", func() {
-			err := vmMetadataSetter.SetVMMetadata(vmcid, metadata)
+			err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, metadata)
 			Expect(err).To(MatchError("get-pods-welp"))
 			Expect(fakeClient.MatchingActions("get", "pods")).To(HaveLen(1))
 		})
 	})
 
+	Context("when patching the settings fails", func() {
+		BeforeEach(func() {
+			fakeClient.PrependReactor("patch", "secrets", func(action testing.Action) (bool, runtime.Object, error) {
+				return true, nil, errors.New("patch-secrets-welp")
+			})
+		})
+
+		It("returns an error", func() {
+			err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, metadata)
+			Expect(err).To(MatchError("patch-secrets-welp"))
+		})
+	})
+
 	Context("when patching the pod fails", func() {
 		BeforeEach(func() {
 			fakeClient.PrependReactor("patch", "pods", func(action testing.Action) (bool, runtime.Object, error) {
@@ -129,7 +284,7 @@ var _ = Describe("SetVMMetadata", func() {
 		})
 
 		It("returns an error", func() {
-			err := vmMetadataSetter.SetVMMetadata(vmcid, metadata)
+			err := vmMetadataSetter.SetVMMetadata(context.Background(), vmcid, metadata)
 			Expect(err).To(MatchError("patch-pods-welp"))
 			Expect(fakeClient.MatchingActions("patch", "pods")).To(HaveLen(1))
 		})
@@ -8,6 +8,15 @@ type StemcellCloudProperties struct {
 	Image string `json:"image"`
 }
 
+// Validate requires the image reference. Stemcell cloud properties also
+// carry the fields of the stemcell manifest, so unknown fields are allowed.
+func (c StemcellCloudProperties) Validate() error {
+	if c.Image == "" {
+		return cpi.NewFieldError("image", "is required")
+	}
+	return nil
+}
+
 func CreateStemcell(image string, cloudProps StemcellCloudProperties) (cpi.StemcellCID, error) {
 	return cpi.StemcellCID(cloudProps.Image), nil
 }
@@ -25,6 +25,18 @@ var _ = Describe("Stemcell", func() {
 		})
 	})
 
+	Describe("StemcellCloudProperties", func() {
+		It("requires an image", func() {
+			err := actions.StemcellCloudProperties{}.Validate()
+			Expect(err).To(MatchError("image: is required"))
+		})
+
+		It("accepts an image", func() {
+			err := actions.StemcellCloudProperties{Image: "stemcell:1"}.Validate()
+			Expect(err).NotTo(HaveOccurred())
+		})
+	})
+
 	Describe("DeleteStemcell", func() {
 		var stemcellCID cpi.StemcellCID
 
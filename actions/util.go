@@ -1,28 +1,26 @@
 package actions
 
 import (
-	"strings"
+	"context"
 
 	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/kubecluster"
 	uuid "github.com/nu7hatch/gouuid"
 )
 
-func NewVMCID(context, agentID string) cpi.VMCID {
-	return cpi.VMCID(context + ":" + agentID)
+// diskCIDFor returns the CID of a disk created with client.
+func diskCIDFor(client kubecluster.Client, diskID string) cpi.DiskCID {
+	return cpi.NewDiskCID(client.Context(), kubecluster.ExplicitNamespace(client), diskID)
 }
 
-func ParseVMCID(vmcid cpi.VMCID) (context, agentID string) {
-	parts := strings.SplitN(string(vmcid), ":", 2)
-	return parts[0], parts[1]
-}
-
-func NewDiskCID(context, diskID string) cpi.DiskCID {
-	return cpi.DiskCID(context + ":" + diskID)
-}
-
-func ParseDiskCID(diskCID cpi.DiskCID) (context, diskID string) {
-	parts := strings.SplitN(string(diskCID), ":", 2)
-	return parts[0], parts[1]
+// newClient returns a client for a context that works in namespace. The
+// namespace of the context is used when namespace is empty.
+func newClient(ctx context.Context, provider kubecluster.ClientProvider, kubeContext, namespace string) (kubecluster.Client, error) {
+	client, err := provider.New(ctx, kubeContext)
+	if err != nil {
+		return nil, err
+	}
+	return kubecluster.InNamespace(client, namespace), nil
 }
 
 func CreateGUID() (string, error) {
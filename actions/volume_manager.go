@@ -2,6 +2,7 @@ package actions
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -17,13 +18,13 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/watch"
-	"k8s.io/client-go/kubernetes/scheme"
 	core "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/tools/remotecommand"
 )
 
 type VolumeManager struct {
 	ClientProvider kubecluster.ClientProvider
+	Events         *EventRecorder
 
 	Clock             clock.Clock
 	PodReadyTimeout   time.Duration
@@ -37,19 +38,22 @@ const (
 	Remove
 )
 
-func (v *VolumeManager) AttachDisk(vmcid cpi.VMCID, diskCID cpi.DiskCID) error {
-	vmContext, agentID := ParseVMCID(vmcid)
-	context, diskID := ParseDiskCID(diskCID)
-	if context != vmContext {
-		return fmt.Errorf("Kubernetes disk and resource pool contexts must be the same: disk: %q, resource pool: %q", context, vmContext)
+// BlockDevicePrefix is the directory in the bosh-job container where disks
+// with a Block volume mode are exposed as raw devices.
+const BlockDevicePrefix = "/dev/bosh/"
+
+func (v *VolumeManager) AttachDisk(ctx context.Context, vmcid cpi.VMCID, diskCID cpi.DiskCID) error {
+	vm, disk, err := parseVolumeCIDs(vmcid, diskCID)
+	if err != nil {
+		return err
 	}
 
-	client, err := v.ClientProvider.New(context)
+	client, err := newClient(ctx, v.ClientProvider, disk.Context, disk.Namespace)
 	if err != nil {
 		return err
 	}
 
-	err = v.recreatePod(client, Add, agentID, diskID)
+	err = v.recreatePod(ctx, client, Add, vmcid, diskCID, vmObjectsFor(vm), disk.ID)
 	if err != nil {
 		return err
 	}
@@ -57,19 +61,18 @@ func (v *VolumeManager) AttachDisk(vmcid cpi.VMCID, diskCID cpi.DiskCID) error {
 	return nil
 }
 
-func (v *VolumeManager) DetachDisk(vmcid cpi.VMCID, diskCID cpi.DiskCID) error {
-	vmContext, agentID := ParseVMCID(vmcid)
-	context, diskID := ParseDiskCID(diskCID)
-	if context != vmContext {
-		return fmt.Errorf("Kubernetes disk and resource pool contexts must be the same: disk: %q, resource pool: %q", context, vmContext)
+func (v *VolumeManager) DetachDisk(ctx context.Context, vmcid cpi.VMCID, diskCID cpi.DiskCID) error {
+	vm, disk, err := parseVolumeCIDs(vmcid, diskCID)
+	if err != nil {
+		return err
 	}
 
-	client, err := v.ClientProvider.New(context)
+	client, err := newClient(ctx, v.ClientProvider, disk.Context, disk.Namespace)
 	if err != nil {
 		return err
 	}
 
-	err = v.recreatePod(client, Remove, agentID, diskID)
+	err = v.recreatePod(ctx, client, Remove, vmcid, diskCID, vmObjectsFor(vm), disk.ID)
 	if err != nil {
 		return err
 	}
@@ -77,19 +80,58 @@ func (v *VolumeManager) DetachDisk(vmcid cpi.VMCID, diskCID cpi.DiskCID) error {
 	return nil
 }
 
-func (v *VolumeManager) recreatePod(client kubecluster.Client, op Operation, agentID string, diskID string) error {
+// parseVolumeCIDs parses the CIDs of a VM and a disk that is attached to or
+// detached from it. Both must live in the same context and namespace.
+func parseVolumeCIDs(vmcid cpi.VMCID, diskCID cpi.DiskCID) (vm, disk cpi.CID, err error) {
+	vm, err = vmcid.Parse()
+	if err != nil {
+		return cpi.CID{}, cpi.CID{}, err
+	}
+	disk, err = diskCID.Parse()
+	if err != nil {
+		return cpi.CID{}, cpi.CID{}, err
+	}
+
+	if disk.Context != vm.Context {
+		return cpi.CID{}, cpi.CID{}, fmt.Errorf("Kubernetes disk and resource pool contexts must be the same: disk: %q, resource pool: %q", disk.Context, vm.Context)
+	}
+	if disk.Namespace != vm.Namespace {
+		return cpi.CID{}, cpi.CID{}, fmt.Errorf("Kubernetes disk and VM namespaces must be the same: disk: %q, VM: %q", disk.Namespace, vm.Namespace)
+	}
+	return vm, disk, nil
+}
+
+// recreatePod adds or removes a disk from the pod of a VM. The disk is
+// recorded in the agent settings under the CID the director knows it by.
+func (v *VolumeManager) recreatePod(ctx context.Context, client kubecluster.Client, op Operation, vmcid cpi.VMCID, diskCID cpi.DiskCID, vm vmObjects, diskID string) error {
 	podService := client.Pods()
-	pod, err := podService.Get("agent-"+agentID, metav1.GetOptions{})
+	pod, err := podService.Get(vm.Pod(), metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
 
-	err = updateConfigMapDisks(client, op, agentID, diskID)
+	_, span := startStep(ctx, "update_settings")
+	block := false
+	if op == Add {
+		block, err = isBlockVolume(client, diskID)
+	}
+	if err == nil {
+		err = updateSettingsDisks(ctx, client, op, vm, diskCID, diskID, block)
+	}
+	endStep(span, err)
 	if err != nil {
 		return err
 	}
 
-	updateVolumes(op, &pod.Spec, diskID)
+	settings := v1.ObjectReference{Kind: "Secret", APIVersion: "v1", Name: vm.Settings()}
+	if op == Add {
+		v.Events.Record(client, settings, EventReasonSettingsUpdated, "Added disk %s to the agent settings of VM %s", diskCID, vmcid)
+	} else {
+		v.Events.Record(client, settings, EventReasonSettingsUpdated, "Removed disk %s from the agent settings of VM %s", diskCID, vmcid)
+	}
+
+	updateVolumes(op, &pod.Spec, diskID, block)
+	useSettingsSecret(&pod.Spec, vm)
 
 	if pod.Annotations == nil {
 		pod.Annotations = map[string]string{}
@@ -107,57 +149,99 @@ func (v *VolumeManager) recreatePod(client kubecluster.Client, op Operation, age
 	}
 	pod.Status = v1.PodStatus{}
 
-	err = podService.Delete("agent-"+agentID, &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
-	if err != nil {
+	// Once the pod is deleted it has to be recreated, even if the request
+	// is cancelled in between, so both calls use a client of their own
+	// that keeps the span of the request but is never cancelled.
+	if err := ctx.Err(); err != nil {
 		return err
 	}
 
-	updated, err := podService.Create(pod)
+	recreateCtx, span := startStep(withoutCancel(ctx), "recreate_pod")
+	recreateClient, err := newClient(recreateCtx, v.ClientProvider, client.Context(), kubecluster.ExplicitNamespace(client))
+	if err == nil {
+		err = recreateClient.Pods().Delete(vm.Pod(), &metav1.DeleteOptions{GracePeriodSeconds: int64Ptr(0)})
+	}
+	var updated *v1.Pod
+	if err == nil {
+		updated, err = recreateClient.Pods().Create(pod)
+	}
+	endStep(span, err)
 	if err != nil {
 		return err
 	}
 
-	ready, err := v.waitForPod(podService, agentID, updated.ResourceVersion)
+	// The old pod and its events are gone, so the events of the operation
+	// are recorded against the recreated pod.
+	if op == Add {
+		v.Events.Record(client, objectReference("Pod", updated.ObjectMeta), EventReasonDiskAttached, "Recreated the pod of VM %s to attach disk %s", vmcid, diskCID)
+		v.Events.RecordClaim(client, "disk-"+diskID, EventReasonDiskAttached, "Attached disk %s to VM %s", diskCID, vmcid)
+	} else {
+		v.Events.Record(client, objectReference("Pod", updated.ObjectMeta), EventReasonDiskDetached, "Recreated the pod of VM %s to detach disk %s", vmcid, diskCID)
+		v.Events.RecordClaim(client, "disk-"+diskID, EventReasonDiskDetached, "Detached disk %s from VM %s", diskCID, vmcid)
+	}
+
+	// The recreated pod reads its settings from the secret so a config map
+	// left behind by the old layout is no longer needed.
+	_, span = startStep(ctx, "delete_config_map")
+	err = deleteConfigMap(client.ConfigMaps(), vm.Settings())
+	endStep(span, err)
 	if err != nil {
 		return err
 	}
 
-	if !ready {
-		return errors.New("Pod recreate failed with a timeout")
+	waitCtx, span := startStep(ctx, "wait_for_pod")
+	start := v.Clock.Now()
+	ready, err := v.waitForPod(waitCtx, podService, vm, updated.ResourceVersion)
+	if err == nil && !ready {
+		err = errors.New("Pod recreate failed with a timeout")
+	}
+	observeWait(ctx, PhasePodReady, client.Context(), v.Clock.Since(start), err)
+	endStep(span, err)
+	if err != nil {
+		return err
 	}
 
-	v.WaitForPostPodDelay(agentID, client)
+	// Failing to reach the agent is not fatal; a cancelled request is.
+	waitCtx, span = startStep(ctx, "wait_for_agent")
+	err = v.WaitForPostPodDelay(waitCtx, vm.Pod(), client)
+	endStep(span, err)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
 
 	return nil
 }
 
-func (v *VolumeManager) WaitForPostPodDelay(agentID string, client kubecluster.Client) error {
+// WaitForPostPodDelay gives the agent of a recreated pod PostRecreateDelay
+// to start and then waits until it accepts connections.
+func (v *VolumeManager) WaitForPostPodDelay(ctx context.Context, podName string, client kubecluster.Client) error {
+	if v.PostRecreateDelay > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Waiting for the agent of pod %q: %s", podName, ctx.Err())
+		case <-v.Clock.After(v.PostRecreateDelay):
+		}
+	}
+
 	podService := client.Pods()
 	var (
 		execOut bytes.Buffer
 		execErr bytes.Buffer
 	)
 
-	pod, err := podService.Get("agent-"+agentID, metav1.GetOptions{})
+	pod, err := podService.Get(podName, metav1.GetOptions{})
 	if err != nil {
 		return err
 	}
 
-	restConfig, err := v.ClientProvider.GetRestConfig(client.Context())
-	if err != nil {
-		return err
-	}
-
-	req := client.Core().RESTClient().Post().Resource("pods").Name(pod.Name).
-		Namespace(pod.Namespace).SubResource("exec")
-	req.VersionedParams(&v1.PodExecOptions{
+	execOptions := &v1.PodExecOptions{
 		Container: pod.Spec.Containers[0].Name,
 		Command:   []string{"curl", "127.0.0.1:2825", "--max-time", "1"},
 		Stdout:    true,
 		Stderr:    true,
-	}, scheme.ParameterCodec)
+	}
 
-	exec, err := remotecommand.NewSPDYExecutor(restConfig, "POST", req.URL())
+	exec, err := v.ClientProvider.NewExecutor(ctx, client.Context(), pod.Namespace, pod.Name, execOptions)
 	if err != nil {
 		return err
 	}
@@ -171,7 +255,7 @@ func (v *VolumeManager) WaitForPostPodDelay(agentID string, client kubecluster.C
 		execOut.Reset()
 		execErr.Reset()
 
-		exec, err := remotecommand.NewSPDYExecutor(restConfig, "POST", req.URL())
+		exec, err := v.ClientProvider.NewExecutor(ctx, client.Context(), pod.Namespace, pod.Name, execOptions)
 		if err != nil {
 			return err
 		}
@@ -180,35 +264,60 @@ func (v *VolumeManager) WaitForPostPodDelay(agentID string, client kubecluster.C
 			Stdout: &execOut,
 			Stderr: &execErr,
 		})
-		time.Sleep(1 * time.Second)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("Waiting for the agent of pod %q: %s", pod.Name, ctx.Err())
+		case <-time.After(1 * time.Second):
+		}
 	}
 
 	return nil
 }
 
-func updateConfigMapDisks(client kubecluster.Client, op Operation, agentID, diskID string) error {
-	configMapService := client.ConfigMaps()
-	cm, err := configMapService.Get("agent-"+agentID, metav1.GetOptions{})
+// isBlockVolume reports whether the disk's claim was created with a Block
+// volume mode. A missing claim is treated as a filesystem volume.
+func isBlockVolume(client kubecluster.Client, diskID string) (bool, error) {
+	pvc, err := client.PersistentVolumeClaims().Get("disk-"+diskID, metav1.GetOptions{})
+	if err != nil {
+		if isNotFoundStatusError(err) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return pvc.Spec.VolumeMode != nil && *pvc.Spec.VolumeMode == v1.PersistentVolumeBlock, nil
+}
+
+func updateSettingsDisks(ctx context.Context, client kubecluster.Client, op Operation, vm vmObjects, diskCID cpi.DiskCID, diskID string, block bool) error {
+	secretService := client.Secrets()
+	secret, err := getSettingsSecret(ctx, client, vm)
 	if err != nil {
 		return err
 	}
 
 	var settings agent.Settings
-	err = json.Unmarshal([]byte(cm.Data["instance_settings"]), &settings)
+	err = json.Unmarshal(secret.Data["instance_settings"], &settings)
 	if err != nil {
 		return err
 	}
 
-	diskCID := string(NewDiskCID(client.Context(), diskID))
 	if settings.Disks.Persistent == nil {
-		settings.Disks.Persistent = map[string]string{}
+		settings.Disks.Persistent = map[string]interface{}{}
 	}
 
 	switch op {
 	case Add:
-		settings.Disks.Persistent[diskCID] = "/mnt/" + diskID
+		if block {
+			settings.Disks.Persistent[string(diskCID)] = agent.DiskHint{
+				Path:     BlockDevicePrefix + diskID,
+				VolumeID: diskID,
+			}
+		} else {
+			settings.Disks.Persistent[string(diskCID)] = "/mnt/" + diskID
+		}
 	case Remove:
-		delete(settings.Disks.Persistent, diskCID)
+		delete(settings.Disks.Persistent, string(diskCID))
 	}
 
 	settingsJSON, err := json.Marshal(settings)
@@ -216,9 +325,12 @@ func updateConfigMapDisks(client kubecluster.Client, op Operation, agentID, disk
 		return err
 	}
 
-	cm.Data["instance_settings"] = string(settingsJSON)
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	secret.Data["instance_settings"] = settingsJSON
 
-	_, err = configMapService.Update(cm)
+	_, err = secretService.Update(secret)
 	if err != nil {
 		return err
 	}
@@ -226,16 +338,47 @@ func updateConfigMapDisks(client kubecluster.Client, op Operation, agentID, disk
 	return nil
 }
 
-func updateVolumes(op Operation, spec *v1.PodSpec, diskID string) {
+// getSettingsSecret retrieves the secret holding the agent settings. VMs
+// created with the old layout keep their settings in a config map; those
+// settings are copied into a new secret.
+func getSettingsSecret(ctx context.Context, client kubecluster.Client, vm vmObjects) (*v1.Secret, error) {
+	secret, err := client.Secrets().Get(vm.Settings(), metav1.GetOptions{})
+	if err == nil {
+		return secret, nil
+	}
+	if !isNotFoundStatusError(err) {
+		return nil, err
+	}
+
+	cm, err := client.ConfigMaps().Get(vm.Settings(), metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	secret = newSettingsSecret(ctx, client.Namespace(), vm, []byte(cm.Data["instance_settings"]))
+	return client.Secrets().Create(secret)
+}
+
+// useSettingsSecret points the settings volume of the pod at the secret.
+func useSettingsSecret(spec *v1.PodSpec, vm vmObjects) {
+	for i, v := range spec.Volumes {
+		if v.Name == "bosh-config" {
+			spec.Volumes[i].VolumeSource = settingsVolumeSource(vm)
+			return
+		}
+	}
+}
+
+func updateVolumes(op Operation, spec *v1.PodSpec, diskID string, block bool) {
 	switch op {
 	case Add:
-		addVolume(spec, diskID)
+		addVolume(spec, diskID, block)
 	case Remove:
 		removeVolume(spec, diskID)
 	}
 }
 
-func addVolume(spec *v1.PodSpec, diskID string) {
+func addVolume(spec *v1.PodSpec, diskID string, block bool) {
 	spec.Volumes = append(spec.Volumes, v1.Volume{
 		Name: "disk-" + diskID,
 		VolumeSource: v1.VolumeSource{
@@ -247,10 +390,17 @@ func addVolume(spec *v1.PodSpec, diskID string) {
 
 	for i, c := range spec.Containers {
 		if c.Name == "bosh-job" {
-			spec.Containers[i].VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
-				Name:      "disk-" + diskID,
-				MountPath: "/mnt/" + diskID,
-			})
+			if block {
+				spec.Containers[i].VolumeDevices = append(c.VolumeDevices, v1.VolumeDevice{
+					Name:       "disk-" + diskID,
+					DevicePath: BlockDevicePrefix + diskID,
+				})
+			} else {
+				spec.Containers[i].VolumeMounts = append(c.VolumeMounts, v1.VolumeMount{
+					Name:      "disk-" + diskID,
+					MountPath: "/mnt/" + diskID,
+				})
+			}
 			break
 		}
 	}
@@ -272,12 +422,18 @@ func removeVolume(spec *v1.PodSpec, diskID string) {
 					break
 				}
 			}
+			for j, v := range c.VolumeDevices {
+				if v.Name == "disk-"+diskID {
+					spec.Containers[i].VolumeDevices = append(c.VolumeDevices[:j], c.VolumeDevices[j+1:]...)
+					break
+				}
+			}
 		}
 	}
 }
 
-func (v *VolumeManager) waitForPod(podService core.PodInterface, agentID string, resourceVersion string) (bool, error) {
-	agentSelector := "bosh.cloudfoundry.org/agent-id=" + agentID
+func (v *VolumeManager) waitForPod(ctx context.Context, podService core.PodInterface, vm vmObjects, resourceVersion string) (bool, error) {
+	agentSelector := "bosh.cloudfoundry.org/agent-id=" + vm.AgentID
 
 	listOptions := metav1.ListOptions{
 		LabelSelector:   agentSelector,
@@ -292,11 +448,21 @@ func (v *VolumeManager) waitForPod(podService core.PodInterface, agentID string,
 	if err != nil {
 		return false, err
 	}
-	defer podWatch.Stop()
+	defer func() { podWatch.Stop() }()
 
 	for {
 		select {
-		case event := <-podWatch.ResultChan():
+		case event, ok := <-podWatch.ResultChan():
+			if !ok {
+				// The API server and proxies end watches after a while;
+				// continue from the last version that was seen.
+				podWatch, err = podService.Watch(listOptions)
+				if err != nil {
+					return false, err
+				}
+				continue
+			}
+
 			switch event.Type {
 			case watch.Modified:
 				pod, ok := event.Object.(*v1.Pod)
@@ -307,6 +473,9 @@ func (v *VolumeManager) waitForPod(podService core.PodInterface, agentID string,
 				if isAgentContainerRunning(pod) {
 					return true, nil
 				}
+				if pod.ResourceVersion != "" {
+					listOptions.ResourceVersion = pod.ResourceVersion
+				}
 
 			default:
 				return false, fmt.Errorf("Unexpected pod watch event: %s", event.Type)
@@ -314,6 +483,9 @@ func (v *VolumeManager) waitForPod(podService core.PodInterface, agentID string,
 
 		case <-timer.C():
 			return false, nil
+
+		case <-ctx.Done():
+			return false, fmt.Errorf("Waiting for pod %s: %s", vm.Pod(), ctx.Err())
 		}
 	}
 }
@@ -1,6 +1,7 @@
 package actions_test
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"time"
@@ -36,8 +37,9 @@ var _ = Describe("VolumeManager", func() {
 	)
 
 	BeforeEach(func() {
-		vmcid = actions.NewVMCID("context-name", "agent-id")
-		diskCID = actions.NewDiskCID("context-name", "disk-id")
+		// CIDs in the encoding used before they were versioned
+		vmcid = cpi.VMCID("context-name:agent-id")
+		diskCID = cpi.DiskCID("context-name:disk-id")
 
 		agentMeta = metav1.ObjectMeta{
 			Name:      "agent-agent-id",
@@ -51,6 +53,7 @@ var _ = Describe("VolumeManager", func() {
 		}
 
 		fakeProvider = &fakes.ClientProvider{}
+		fakeProvider.NewExecutorReturns(nil, errors.New("pod exec is not available in tests"))
 		fakeClock = fakeclock.NewFakeClock(time.Now())
 
 		volumeManager = &actions.VolumeManager{
@@ -100,10 +103,10 @@ var _ = Describe("VolumeManager", func() {
 			}
 
 			fakeClient = fakes.NewClient(
-				&v1.ConfigMap{
+				&v1.Secret{
 					ObjectMeta: agentMeta,
-					Data: map[string]string{
-						"instance_settings": `{}`,
+					Data: map[string][]byte{
+						"instance_settings": []byte(`{}`),
 					},
 				},
 				initialPod,
@@ -132,39 +135,68 @@ var _ = Describe("VolumeManager", func() {
 		})
 
 		It("gets a client for the appropriate context", func() {
-			err := volumeManager.AttachDisk(vmcid, diskCID)
+			err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(fakeProvider.NewCallCount()).To(Equal(1))
-			Expect(fakeProvider.NewArgsForCall(0)).To(Equal("context-name"))
+			Expect(fakeProvider.NewCallCount()).To(Equal(2))
+			_, contextName := fakeProvider.NewArgsForCall(0)
+			Expect(contextName).To(Equal("context-name"))
 		})
 
-		It("retrieves and updates the the agent config map", func() {
-			err := volumeManager.AttachDisk(vmcid, diskCID)
+		It("recreates the pod with a client that outlives the request", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			err := volumeManager.AttachDisk(ctx, vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
-			matches := fakeClient.MatchingActions("get", "configmaps")
+			requestCtx, _ := fakeProvider.NewArgsForCall(0)
+			Expect(requestCtx).To(Equal(ctx))
+
+			cancel()
+			recreateCtx, _ := fakeProvider.NewArgsForCall(1)
+			Expect(recreateCtx.Done()).To(BeNil())
+			Expect(recreateCtx.Err()).NotTo(HaveOccurred())
+		})
+
+		Context("when the request is cancelled before the pod is deleted", func() {
+			It("leaves the pod alone", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancel()
+
+				err := volumeManager.AttachDisk(ctx, vmcid, diskCID)
+				Expect(err).To(Equal(context.Canceled))
+				Expect(fakeClient.MatchingActions("delete", "pods")).To(HaveLen(0))
+			})
+		})
+
+		It("retrieves and updates the agent settings secret", func() {
+			err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("get", "secrets")
 			Expect(matches).To(HaveLen(1))
 			Expect(matches[0].(testing.GetAction).GetName()).To(Equal("agent-agent-id"))
 
-			matches = fakeClient.MatchingActions("update", "configmaps")
+			matches = fakeClient.MatchingActions("update", "secrets")
 			Expect(matches).To(HaveLen(1))
 
-			updated := matches[0].(testing.UpdateAction).GetObject().(*v1.ConfigMap)
+			updated := matches[0].(testing.UpdateAction).GetObject().(*v1.Secret)
 			Expect(updated.Name).To(Equal("agent-agent-id"))
 
 			var settings agent.Settings
-			Expect(json.Unmarshal([]byte(updated.Data["instance_settings"]), &settings)).To(Succeed())
+			Expect(json.Unmarshal(updated.Data["instance_settings"], &settings)).To(Succeed())
 			Expect(settings.Disks.Persistent).To(HaveKeyWithValue("context-name:disk-id", "/mnt/disk-id"))
 		})
 
 		It("retrieves, deletes, and recreates the pod", func() {
-			err := volumeManager.AttachDisk(vmcid, diskCID)
+			err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("get", "pods")
-			Expect(matches).To(HaveLen(1))
+			Expect(matches).To(HaveLen(2))
 			Expect(matches[0].(testing.GetAction).GetName()).To(Equal("agent-agent-id"))
+			Expect(matches[1].(testing.GetAction).GetName()).To(Equal("agent-agent-id"))
 
 			matches = fakeClient.MatchingActions("delete", "pods")
 			Expect(matches).To(HaveLen(1))
@@ -178,8 +210,48 @@ var _ = Describe("VolumeManager", func() {
 			Expect(updated.Spec).NotTo(Equal(initialPodSpec))
 		})
 
+		It("records events against the settings, the recreated pod and the claim", func() {
+			volumeManager.Events = &actions.EventRecorder{Clock: fakeClock}
+
+			err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+			Expect(err).NotTo(HaveOccurred())
+
+			matches := fakeClient.MatchingActions("create", "events")
+			Expect(matches).To(HaveLen(3))
+
+			var events []*v1.Event
+			for _, match := range matches {
+				events = append(events, match.(testing.CreateAction).GetObject().(*v1.Event))
+			}
+
+			Expect(events[0].InvolvedObject.Kind).To(Equal("Secret"))
+			Expect(events[0].Reason).To(Equal(actions.EventReasonSettingsUpdated))
+			Expect(events[0].Message).To(Equal("Added disk context-name:disk-id to the agent settings of VM context-name:agent-id"))
+
+			Expect(events[1].InvolvedObject.Kind).To(Equal("Pod"))
+			Expect(events[1].InvolvedObject.Name).To(Equal("agent-agent-id"))
+			Expect(events[1].Reason).To(Equal(actions.EventReasonDiskAttached))
+			Expect(events[1].Message).To(Equal("Recreated the pod of VM context-name:agent-id to attach disk context-name:disk-id"))
+
+			Expect(events[2].InvolvedObject.Kind).To(Equal("PersistentVolumeClaim"))
+			Expect(events[2].InvolvedObject.Name).To(Equal("disk-disk-id"))
+			Expect(events[2].Message).To(Equal("Attached disk context-name:disk-id to VM context-name:agent-id"))
+
+			for _, event := range events {
+				Expect(event.Namespace).To(Equal("bosh-namespace"))
+				Expect(event.Source.Component).To(Equal(actions.EventComponent))
+				Expect(event.Type).To(Equal(v1.EventTypeNormal))
+			}
+		})
+
+		It("records no events without a recorder", func() {
+			err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fakeClient.MatchingActions("create", "events")).To(BeEmpty())
+		})
+
 		It("carries the pod metadata forward on recreate", func() {
-			err := volumeManager.AttachDisk(vmcid, diskCID)
+			err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -191,7 +263,7 @@ var _ = Describe("VolumeManager", func() {
 		})
 
 		It("propagates the PodIP to the ip-address annotation", func() {
-			err := volumeManager.AttachDisk(vmcid, diskCID)
+			err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -204,13 +276,14 @@ var _ = Describe("VolumeManager", func() {
 		Context("when the annotation map is nil", func() {
 			BeforeEach(func() {
 				fakeClient.PrependReactor("get", "pods", func(action testing.Action) (bool, runtime.Object, error) {
-					initialPod.Annotations = nil
-					return true, initialPod, nil
+					pod := initialPod.DeepCopy()
+					pod.Annotations = nil
+					return true, pod, nil
 				})
 			})
 
 			It("propagates the PodIP to the ip-address annotation", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).NotTo(HaveOccurred())
 
 				matches := fakeClient.MatchingActions("create", "pods")
@@ -230,7 +303,7 @@ var _ = Describe("VolumeManager", func() {
 			})
 
 			It("does not overwrite the annotation", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).NotTo(HaveOccurred())
 
 				matches := fakeClient.MatchingActions("create", "pods")
@@ -242,7 +315,7 @@ var _ = Describe("VolumeManager", func() {
 		})
 
 		It("adds pvc volume for the disk to the pod", func() {
-			err := volumeManager.AttachDisk(vmcid, diskCID)
+			err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -262,7 +335,7 @@ var _ = Describe("VolumeManager", func() {
 		})
 
 		It("mounts the volume to the bosh-job container", func() {
-			err := volumeManager.AttachDisk(vmcid, diskCID)
+			err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -278,8 +351,117 @@ var _ = Describe("VolumeManager", func() {
 			))
 		})
 
+		Context("when the VM was created with the config map layout", func() {
+			BeforeEach(func() {
+				initialPod.Spec.Volumes = []v1.Volume{{
+					Name: "bosh-config",
+					VolumeSource: v1.VolumeSource{
+						ConfigMap: &v1.ConfigMapVolumeSource{
+							LocalObjectReference: v1.LocalObjectReference{Name: "agent-agent-id"},
+						},
+					},
+				}}
+				fakeClient.SetObjects(
+					&v1.ConfigMap{
+						ObjectMeta: agentMeta,
+						Data: map[string]string{
+							"instance_settings": `{}`,
+						},
+					},
+					initialPod,
+				)
+				fakeClient.PrependWatchReactor("pods", testing.DefaultWatchReactor(fakeWatch, nil))
+			})
+
+			It("moves the settings into a secret", func() {
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+				Expect(err).NotTo(HaveOccurred())
+
+				matches := fakeClient.MatchingActions("create", "secrets")
+				Expect(matches).To(HaveLen(1))
+
+				created := matches[0].(testing.CreateAction).GetObject().(*v1.Secret)
+				Expect(created.Name).To(Equal("agent-agent-id"))
+				Expect(created.Data["instance_settings"]).To(MatchJSON(`{}`))
+
+				matches = fakeClient.MatchingActions("update", "secrets")
+				Expect(matches).To(HaveLen(1))
+
+				var settings agent.Settings
+				updated := matches[0].(testing.UpdateAction).GetObject().(*v1.Secret)
+				Expect(json.Unmarshal(updated.Data["instance_settings"], &settings)).To(Succeed())
+				Expect(settings.Disks.Persistent).To(HaveKeyWithValue("context-name:disk-id", "/mnt/disk-id"))
+			})
+
+			It("mounts the secret in the recreated pod and deletes the config map", func() {
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+				Expect(err).NotTo(HaveOccurred())
+
+				matches := fakeClient.MatchingActions("create", "pods")
+				Expect(matches).To(HaveLen(1))
+
+				updated := matches[0].(testing.CreateAction).GetObject().(*v1.Pod)
+				Expect(updated.Spec.Volumes[0].Name).To(Equal("bosh-config"))
+				Expect(updated.Spec.Volumes[0].ConfigMap).To(BeNil())
+				Expect(updated.Spec.Volumes[0].Secret.SecretName).To(Equal("agent-agent-id"))
+
+				matches = fakeClient.MatchingActions("delete", "configmaps")
+				Expect(matches).To(HaveLen(1))
+				Expect(matches[0].(testing.DeleteAction).GetName()).To(Equal("agent-agent-id"))
+			})
+		})
+
+		Context("when the disk claim has a Block volume mode", func() {
+			BeforeEach(func() {
+				blockMode := v1.PersistentVolumeBlock
+				fakeClient.PrependReactor("get", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+					return true, &v1.PersistentVolumeClaim{
+						ObjectMeta: metav1.ObjectMeta{Name: "disk-disk-id", Namespace: "bosh-namespace"},
+						Spec:       v1.PersistentVolumeClaimSpec{VolumeMode: &blockMode},
+					}, nil
+				})
+			})
+
+			It("exposes the volume as a device in the bosh-job container", func() {
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+				Expect(err).NotTo(HaveOccurred())
+
+				matches := fakeClient.MatchingActions("create", "pods")
+				Expect(matches).To(HaveLen(1))
+
+				updated := matches[0].(testing.CreateAction).GetObject().(*v1.Pod)
+				Expect(updated.Spec.Containers[0].VolumeMounts).To(BeEmpty())
+				Expect(updated.Spec.Containers[0].VolumeDevices).To(ConsistOf(
+					v1.VolumeDevice{
+						Name:       "disk-disk-id",
+						DevicePath: "/dev/bosh/disk-id",
+					},
+				))
+			})
+
+			It("records the device path in the agent settings", func() {
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+				Expect(err).NotTo(HaveOccurred())
+
+				matches := fakeClient.MatchingActions("update", "secrets")
+				Expect(matches).To(HaveLen(1))
+
+				updated := matches[0].(testing.UpdateAction).GetObject().(*v1.Secret)
+				Expect(updated.Data["instance_settings"]).To(MatchJSON(`{
+					"agent_id": "",
+					"disks": {
+						"persistent": {
+							"context-name:disk-id": { "path": "/dev/bosh/disk-id", "volume_id": "disk-id" }
+						}
+					},
+					"mbus": "",
+					"vm": { "name": "" }
+				}`))
+			})
+		})
+
 		It("does not carry the pod status forward", func() {
-			err := volumeManager.AttachDisk(vmcid, diskCID)
+			err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -300,7 +482,7 @@ var _ = Describe("VolumeManager", func() {
 			})
 
 			result := make(chan error)
-			go func() { result <- volumeManager.AttachDisk(vmcid, diskCID) }()
+			go func() { result <- volumeManager.AttachDisk(context.Background(), vmcid, diskCID) }()
 
 			Eventually(func() []testing.Action {
 				return fakeClient.MatchingActions("watch", "pods")
@@ -352,7 +534,7 @@ var _ = Describe("VolumeManager", func() {
 			volumeManager.PostRecreateDelay = 5 * time.Second
 
 			result := make(chan error)
-			go func() { result <- volumeManager.AttachDisk(vmcid, diskCID) }()
+			go func() { result <- volumeManager.AttachDisk(context.Background(), vmcid, diskCID) }()
 
 			Consistently(result).ShouldNot(Receive())
 			fakeClock.Increment(3 * time.Second)
@@ -364,55 +546,68 @@ var _ = Describe("VolumeManager", func() {
 
 		Context("when the vmcid context and diskcid context are different", func() {
 			BeforeEach(func() {
-				vmcid = actions.NewVMCID("rp-ctx", "agent-id")
-				diskCID = actions.NewDiskCID("disk-ctx", "disk-id")
+				vmcid = cpi.NewVMCID("rp-ctx", "", "agent-id")
+				diskCID = cpi.NewDiskCID("disk-ctx", "", "disk-id")
 			})
 
 			It("returns an error", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).To(MatchError(`Kubernetes disk and resource pool contexts must be the same: disk: "disk-ctx", resource pool: "rp-ctx"`))
 			})
 		})
 
-		Context("when getting the config map fails", func() {
+		Context("when the vmcid namespace and diskcid namespace are different", func() {
+			BeforeEach(func() {
+				vmcid = cpi.NewVMCID("context-name", "vm-ns", "agent-id")
+				diskCID = cpi.NewDiskCID("context-name", "disk-ns", "disk-id")
+			})
+
+			It("returns an error", func() {
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+				Expect(err).To(MatchError(`Kubernetes disk and VM namespaces must be the same: disk: "disk-ns", VM: "vm-ns"`))
+				Expect(fakeProvider.NewCallCount()).To(Equal(0))
+			})
+		})
+
+		Context("when getting the settings secret fails", func() {
 			BeforeEach(func() {
-				fakeClient.PrependReactor("get", "configmaps", func(action testing.Action) (bool, runtime.Object, error) {
-					return true, nil, errors.New("get-cm-welp")
+				fakeClient.PrependReactor("get", "secrets", func(action testing.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("get-secret-welp")
 				})
 			})
 
 			It("returns an error", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
-				Expect(err).To(MatchError("get-cm-welp"))
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+				Expect(err).To(MatchError("get-secret-welp"))
 			})
 		})
 
-		Context("when updating the config map fails", func() {
+		Context("when updating the settings secret fails", func() {
 			BeforeEach(func() {
-				fakeClient.PrependReactor("update", "configmaps", func(action testing.Action) (bool, runtime.Object, error) {
-					return true, nil, errors.New("update-cm-welp")
+				fakeClient.PrependReactor("update", "secrets", func(action testing.Action) (bool, runtime.Object, error) {
+					return true, nil, errors.New("update-secret-welp")
 				})
 			})
 
 			It("returns an error", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
-				Expect(err).To(MatchError("update-cm-welp"))
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+				Expect(err).To(MatchError("update-secret-welp"))
 			})
 		})
 
 		Context("when unmarshalling the instance settings fails", func() {
 			BeforeEach(func() {
-				cm := &v1.ConfigMap{
+				secret := &v1.Secret{
 					ObjectMeta: metav1.ObjectMeta{Name: "agent-agent-id", Namespace: "bosh-namespace"},
-					Data:       map[string]string{"instance_settings": `!@$#@$#%!%`},
+					Data:       map[string][]byte{"instance_settings": []byte(`!@$#@$#%!%`)},
 				}
-				fakeClient.PrependReactor("get", "configmaps", func(action testing.Action) (bool, runtime.Object, error) {
-					return true, cm, nil
+				fakeClient.PrependReactor("get", "secrets", func(action testing.Action) (bool, runtime.Object, error) {
+					return true, secret, nil
 				})
 			})
 
 			It("returns an error", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).To(BeAssignableToTypeOf(&json.SyntaxError{}))
 			})
 		})
@@ -425,7 +620,7 @@ var _ = Describe("VolumeManager", func() {
 			})
 
 			It("returns an error", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).To(MatchError("get-pods-welp"))
 			})
 		})
@@ -438,7 +633,7 @@ var _ = Describe("VolumeManager", func() {
 			})
 
 			It("returns an error", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).To(MatchError("delete-pods-welp"))
 			})
 		})
@@ -451,7 +646,7 @@ var _ = Describe("VolumeManager", func() {
 			})
 
 			It("returns an error", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).To(MatchError("create-pods-welp"))
 			})
 		})
@@ -464,11 +659,46 @@ var _ = Describe("VolumeManager", func() {
 			})
 
 			It("returns an error", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).To(MatchError("watch-pods-welp"))
 			})
 		})
 
+		Context("when the pod watch closes before the pod is running", func() {
+			BeforeEach(func() {
+				closedWatch := watch.NewFakeWithChanSize(1, false)
+				closedWatch.Modify(&v1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:            agentMeta.Name,
+						Namespace:       agentMeta.Namespace,
+						Labels:          agentMeta.Labels,
+						ResourceVersion: "pending-resource-version",
+					},
+					Spec:   initialPodSpec,
+					Status: v1.PodStatus{Phase: v1.PodPending},
+				})
+				closedWatch.Stop()
+
+				watches := 0
+				fakeClient.PrependWatchReactor("pods", func(action testing.Action) (bool, watch.Interface, error) {
+					watches++
+					if watches == 1 {
+						return true, closedWatch, nil
+					}
+					return false, nil, nil
+				})
+			})
+
+			It("watches again from the last version it saw", func() {
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
+				Expect(err).NotTo(HaveOccurred())
+
+				matches := fakeClient.MatchingActions("watch", "pods")
+				Expect(matches).To(HaveLen(2))
+				Expect(matches[1].(testing.WatchAction).GetWatchRestrictions().ResourceVersion).To(Equal("pending-resource-version"))
+			})
+		})
+
 		Context("when the pod watch receives an unexpected object", func() {
 			BeforeEach(func() {
 				_, ok := <-fakeWatch.ResultChan()
@@ -477,7 +707,7 @@ var _ = Describe("VolumeManager", func() {
 			})
 
 			It("returns an error", func() {
-				err := volumeManager.AttachDisk(vmcid, diskCID)
+				err := volumeManager.AttachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).To(MatchError("Unexpected object type: *v1.ReplicationController"))
 			})
 		})
@@ -490,7 +720,7 @@ var _ = Describe("VolumeManager", func() {
 
 			It("returns a timeout error", func() {
 				result := make(chan error)
-				go func() { result <- volumeManager.AttachDisk(vmcid, diskCID) }()
+				go func() { result <- volumeManager.AttachDisk(context.Background(), vmcid, diskCID) }()
 
 				Consistently(result).ShouldNot(Receive())
 				fakeClock.Increment(volumeManager.PodReadyTimeout + time.Second)
@@ -540,10 +770,10 @@ var _ = Describe("VolumeManager", func() {
 			}
 
 			fakeClient = fakes.NewClient(
-				&v1.ConfigMap{
+				&v1.Secret{
 					ObjectMeta: agentMeta,
-					Data: map[string]string{
-						"instance_settings": `{ "disks": {"persistent": { "context-name:disk-id": "/mnt/disk-id" }} }`,
+					Data: map[string][]byte{
+						"instance_settings": []byte(`{ "disks": {"persistent": { "context-name:disk-id": "/mnt/disk-id" }} }`),
 					},
 				},
 				initialPod,
@@ -570,39 +800,41 @@ var _ = Describe("VolumeManager", func() {
 		})
 
 		It("gets a client for the appropriate context", func() {
-			err := volumeManager.DetachDisk(vmcid, diskCID)
+			err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(fakeProvider.NewCallCount()).To(Equal(1))
-			Expect(fakeProvider.NewArgsForCall(0)).To(Equal("context-name"))
+			Expect(fakeProvider.NewCallCount()).To(Equal(2))
+			_, contextName := fakeProvider.NewArgsForCall(0)
+			Expect(contextName).To(Equal("context-name"))
 		})
 
-		It("retrieves and updates the the agent config map without the persistent disk", func() {
-			err := volumeManager.DetachDisk(vmcid, diskCID)
+		It("retrieves and updates the agent settings secret without the persistent disk", func() {
+			err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
-			matches := fakeClient.MatchingActions("get", "configmaps")
+			matches := fakeClient.MatchingActions("get", "secrets")
 			Expect(matches).To(HaveLen(1))
 			Expect(matches[0].(testing.GetAction).GetName()).To(Equal("agent-agent-id"))
 
-			matches = fakeClient.MatchingActions("update", "configmaps")
+			matches = fakeClient.MatchingActions("update", "secrets")
 			Expect(matches).To(HaveLen(1))
 
-			updated := matches[0].(testing.UpdateAction).GetObject().(*v1.ConfigMap)
+			updated := matches[0].(testing.UpdateAction).GetObject().(*v1.Secret)
 			Expect(updated.Name).To(Equal("agent-agent-id"))
 
 			var settings agent.Settings
-			Expect(json.Unmarshal([]byte(updated.Data["instance_settings"]), &settings)).To(Succeed())
+			Expect(json.Unmarshal(updated.Data["instance_settings"], &settings)).To(Succeed())
 			Expect(settings.Disks.Persistent).NotTo(HaveKey("context-name:disk-id"))
 		})
 
 		It("retrieves, deletes, and recreates the pod", func() {
-			err := volumeManager.DetachDisk(vmcid, diskCID)
+			err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("get", "pods")
-			Expect(matches).To(HaveLen(1))
+			Expect(matches).To(HaveLen(2))
 			Expect(matches[0].(testing.GetAction).GetName()).To(Equal("agent-agent-id"))
+			Expect(matches[1].(testing.GetAction).GetName()).To(Equal("agent-agent-id"))
 
 			matches = fakeClient.MatchingActions("delete", "pods")
 			Expect(matches).To(HaveLen(1))
@@ -617,7 +849,7 @@ var _ = Describe("VolumeManager", func() {
 		})
 
 		It("carries the pod metadata forward on recreate", func() {
-			err := volumeManager.DetachDisk(vmcid, diskCID)
+			err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -629,7 +861,7 @@ var _ = Describe("VolumeManager", func() {
 		})
 
 		It("propagates the PodIP to the ip-address annotation", func() {
-			err := volumeManager.DetachDisk(vmcid, diskCID)
+			err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -642,13 +874,14 @@ var _ = Describe("VolumeManager", func() {
 		Context("when the annotation map is nil", func() {
 			BeforeEach(func() {
 				fakeClient.PrependReactor("get", "pods", func(action testing.Action) (bool, runtime.Object, error) {
-					initialPod.Annotations = nil
-					return true, initialPod, nil
+					pod := initialPod.DeepCopy()
+					pod.Annotations = nil
+					return true, pod, nil
 				})
 			})
 
 			It("propagates the PodIP to the ip-address annotation", func() {
-				err := volumeManager.DetachDisk(vmcid, diskCID)
+				err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).NotTo(HaveOccurred())
 
 				matches := fakeClient.MatchingActions("create", "pods")
@@ -668,7 +901,7 @@ var _ = Describe("VolumeManager", func() {
 			})
 
 			It("does not overwrite the annotation", func() {
-				err := volumeManager.DetachDisk(vmcid, diskCID)
+				err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).NotTo(HaveOccurred())
 
 				matches := fakeClient.MatchingActions("create", "pods")
@@ -680,7 +913,7 @@ var _ = Describe("VolumeManager", func() {
 		})
 
 		It("removes the pvc volume for the disk from the pod", func() {
-			err := volumeManager.DetachDisk(vmcid, diskCID)
+			err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -700,7 +933,7 @@ var _ = Describe("VolumeManager", func() {
 		})
 
 		It("removes the mount for the the volume from the bosh-job container", func() {
-			err := volumeManager.DetachDisk(vmcid, diskCID)
+			err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -717,7 +950,7 @@ var _ = Describe("VolumeManager", func() {
 		})
 
 		It("does not carry the pod status forward", func() {
-			err := volumeManager.DetachDisk(vmcid, diskCID)
+			err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 			Expect(err).NotTo(HaveOccurred())
 
 			matches := fakeClient.MatchingActions("create", "pods")
@@ -738,7 +971,7 @@ var _ = Describe("VolumeManager", func() {
 			})
 
 			result := make(chan error)
-			go func() { result <- volumeManager.DetachDisk(vmcid, diskCID) }()
+			go func() { result <- volumeManager.DetachDisk(context.Background(), vmcid, diskCID) }()
 
 			Eventually(func() []testing.Action {
 				return fakeClient.MatchingActions("watch", "pods")
@@ -790,7 +1023,7 @@ var _ = Describe("VolumeManager", func() {
 			volumeManager.PostRecreateDelay = 5 * time.Second
 
 			result := make(chan error)
-			go func() { result <- volumeManager.DetachDisk(vmcid, diskCID) }()
+			go func() { result <- volumeManager.DetachDisk(context.Background(), vmcid, diskCID) }()
 
 			Consistently(result).ShouldNot(Receive())
 			fakeClock.Increment(3 * time.Second)
@@ -802,12 +1035,12 @@ var _ = Describe("VolumeManager", func() {
 
 		Context("when the vmcid context and diskcid context are different", func() {
 			BeforeEach(func() {
-				vmcid = actions.NewVMCID("rp-ctx", "agent-id")
-				diskCID = actions.NewDiskCID("disk-ctx", "disk-id")
+				vmcid = cpi.NewVMCID("rp-ctx", "", "agent-id")
+				diskCID = cpi.NewDiskCID("disk-ctx", "", "disk-id")
 			})
 
 			It("returns an error", func() {
-				err := volumeManager.DetachDisk(vmcid, diskCID)
+				err := volumeManager.DetachDisk(context.Background(), vmcid, diskCID)
 				Expect(err).To(MatchError(`Kubernetes disk and resource pool contexts must be the same: disk: "disk-ctx", resource pool: "rp-ctx"`))
 			})
 		})
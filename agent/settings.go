@@ -14,7 +14,14 @@ type Settings struct {
 }
 
 type Disks struct {
-	Persistent map[string]string `json:"persistent,omitempty"`
+	Persistent map[string]interface{} `json:"persistent,omitempty"`
+}
+
+// DiskHint locates a persistent disk that is delivered to the agent as a raw
+// block device. Filesystem disks use a plain mount path string instead.
+type DiskHint struct {
+	Path     string `json:"path"`
+	VolumeID string `json:"volume_id,omitempty"`
 }
 
 type Network struct {
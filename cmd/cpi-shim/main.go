@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/server"
+)
+
+var addressFlag = flag.String(
+	"address",
+	"",
+	"Unix socket (unix:PATH) or TCP address of the CPI server",
+)
+
+// The shim keeps the stdin/stdout contract of the director and forwards the
+// request to a CPI started with -listen.
+func main() {
+	flag.Parse()
+
+	var req cpi.Request
+	err := json.NewDecoder(os.Stdin).Decode(&req)
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Closing the connection cancels the request on the server.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	client := &server.Client{Address: *addressFlag}
+	result, err := client.Do(ctx, &req)
+	if err != nil {
+		result = cpi.NewErrorResponse(err)
+	}
+
+	response, err := json.Marshal(result)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("%s", response)
+}
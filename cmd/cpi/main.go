@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"code.cloudfoundry.org/clock"
@@ -14,11 +19,13 @@ import (
 	"github.com/evoila/kubernetes-cpi/config"
 	"github.com/evoila/kubernetes-cpi/cpi"
 	"github.com/evoila/kubernetes-cpi/kubecluster"
-)
-
-const (
-	DefaultPostRecreateDelay = 15 * time.Second
-	DefaultPodReadyTimeout   = 300 * time.Second
+	"github.com/evoila/kubernetes-cpi/metrics"
+	"github.com/evoila/kubernetes-cpi/recorder"
+	"github.com/evoila/kubernetes-cpi/server"
+	"github.com/evoila/kubernetes-cpi/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel"
 )
 
 var agentConfigFlag = flag.String(
@@ -27,173 +34,378 @@ var agentConfigFlag = flag.String(
 	"Path to serialized agent configuration data",
 )
 
+var cpiConfigFlag = flag.String(
+	"cpiConfig",
+	"",
+	"Path to the CPI configuration with defaults and VM profiles",
+)
+
 var kubeConfigFlag = flag.String(
 	"kubeConfig",
 	"",
-	"Path to the serialized kubernetes configuration file",
+	"Path to the serialized kubernetes configuration or a kubeconfig file",
 )
 
 var debugFlag = flag.Bool(
 	"debug",
 	false,
-	"Write CPI requests and responses to os.Stderr",
+	"Write redacted CPI requests and responses to os.Stderr",
+)
+
+var traceFileFlag = flag.String(
+	"traceFile",
+	"",
+	"File to append a redacted JSON line for every request to; overrides trace_file of the CPI configuration",
+)
+
+var schemaFlag = flag.Bool(
+	"schema",
+	false,
+	"Print the JSON Schema of the cloud properties and exit",
+)
+
+var listenFlag = flag.String(
+	"listen",
+	"",
+	"Serve CPI requests on a Unix socket (unix:PATH) or loopback TCP address instead of reading one from os.Stdin; "+
+		"requests are not authenticated and run with the cluster credentials of the CPI, so restrict access to the socket",
+)
+
+var recordFlag = flag.String(
+	"record",
+	"",
+	"Directory to record requests, responses and Kubernetes API interactions in; recordings contain credentials",
+)
+
+var metricsTextfileFlag = flag.String(
+	"metricsTextfile",
+	"",
+	"File to add the Prometheus metrics of a request to for the node exporter textfile collector",
+)
+
+var metricsPushURLFlag = flag.String(
+	"metricsPushURL",
+	"",
+	"Pushgateway URL to push the Prometheus metrics of a request to, in a group of its own",
+)
+
+var metricsListenFlag = flag.String(
+	"metricsListen",
+	"",
+	"TCP address to serve Prometheus metrics on at /metrics when serving with -listen",
+)
+
+var spanFileFlag = flag.String(
+	"spanFile",
+	"",
+	"File to append OpenTelemetry spans to as JSON",
+)
+
+var replayFlag = flag.String(
+	"replay",
+	"",
+	"Replay the session recorded in a directory against a fake cluster and exit",
 )
 
 func main() {
 	flag.Parse()
 
-	kubeConf, err := loadKubeConfig(*kubeConfigFlag)
-	if err != nil {
-		panic(err)
+	if *schemaFlag {
+		schema, err := json.MarshalIndent(actions.CloudPropertiesSchema(), "", "  ")
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("%s\n", schema)
+		return
 	}
 
-	agentConf, err := loadAgentConfig(*agentConfigFlag)
+	if *replayFlag != "" {
+		matched, err := replay(*replayFlag)
+		if err != nil {
+			panic(err)
+		}
+		if !matched {
+			os.Exit(1)
+		}
+		return
+	}
+
+	kubeConf, connectionOptions, err := config.LoadKubeConfig(*kubeConfigFlag)
 	if err != nil {
 		panic(err)
 	}
 
-	payload, err := ioutil.ReadAll(os.Stdin)
+	agentConf, err := loadAgentConfig(*agentConfigFlag)
 	if err != nil {
 		panic(err)
 	}
 
-	debugJSON("request", payload)
-
-	var req cpi.Request
-	err = json.Unmarshal(payload, &req)
+	cpiConf, err := loadCPIConfig(*cpiConfigFlag)
 	if err != nil {
 		panic(err)
 	}
 
 	provider := &kubecluster.Provider{
-		Config: kubeConf.ClientConfig(),
+		Config:  kubeConf,
+		Options: connectionOptions,
 	}
 
-	var result *cpi.Response
-	switch req.Method {
-
-	// Stemcell Management
-	case "create_stemcell":
-		result, err = cpi.Dispatch(&req, actions.CreateStemcell)
-
-	case "delete_stemcell":
-		result, err = cpi.Dispatch(&req, actions.DeleteStemcell)
-
-	case "info":
-		result, err = cpi.Dispatch(&req, actions.Info)
+	var rec *recorder.Recorder
+	if *recordFlag != "" {
+		rec = &recorder.Recorder{Dir: *recordFlag, Clock: clock.NewClock()}
+		provider.WrapTransport = rec.WrapTransport
+	}
 
-	// VM management
-	case "create_vm":
-		vmCreator := &actions.VMCreator{
-			AgentConfig:    agentConf,
-			ClientProvider: provider,
+	tracingOptions := tracing.Options{}
+	if *spanFileFlag != "" {
+		spanFile, err := os.OpenFile(*spanFileFlag, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			panic(err)
 		}
-		result, err = cpi.Dispatch(&req, vmCreator.Create)
+		defer spanFile.Close()
+		tracingOptions.File = spanFile
+	}
 
-	case "delete_vm":
-		vmDeleter := &actions.VMDeleter{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, vmDeleter.Delete)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracingOptions)
+	if err != nil {
+		panic(err)
+	}
+	tracingEnabled := tracingOptions.File != nil
+	if tracingEnabled {
+		provider.WrapTransport = chainWrapTransport(provider.WrapTransport, tracing.WrapTransport)
+	}
 
-	case "has_vm":
-		vmFinder := &actions.VMFinder{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, vmFinder.HasVM)
+	// A server handles many requests, so connections are kept per context.
+	var clientProvider kubecluster.ClientProvider = provider
+	if *listenFlag != "" {
+		clientProvider = &kubecluster.CachingProvider{Provider: provider}
+	}
 
-	case "set_vm_metadata":
-		vmMetadataSetter := actions.VMMetadataSetter{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, vmMetadataSetter.SetVMMetadata)
+	clk := clock.NewClock()
+	registry := actions.NewDefaultRegistry(&actions.Dependencies{
+		AgentConfig:       agentConf,
+		CPIConfig:         cpiConf,
+		ClientProvider:    clientProvider,
+		Clock:             clk,
+		GUIDGeneratorFunc: actions.CreateGUID,
+	})
+
+	// Recover is the outermost middleware, so a panic in any of the others
+	// still ends in a response.
+	registry.Use(actions.Recover())
+
+	if rec != nil {
+		registry.Use(rec.Middleware())
+	}
+	if tracingEnabled {
+		registry.Use(tracing.Middleware(otel.Tracer("github.com/evoila/kubernetes-cpi")))
+	}
 
-	// Disk management
-	case "create_disk":
-		diskCreator := actions.DiskCreator{
-			ClientProvider:    provider,
-			GUIDGeneratorFunc: actions.CreateGUID,
-		}
-		result, err = cpi.Dispatch(&req, diskCreator.CreateDisk)
-
-	case "attach_disk":
-		volumeManager := actions.VolumeManager{
-			ClientProvider:    provider,
-			Clock:             clock.NewClock(),
-			PodReadyTimeout:   DefaultPodReadyTimeout,
-			PostRecreateDelay: DefaultPostRecreateDelay,
-		}
-		result, err = cpi.Dispatch(&req, volumeManager.AttachDisk)
-
-	case "has_disk":
-		diskFinder := actions.DiskFinder{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, diskFinder.HasDisk)
-
-	case "delete_disk":
-		diskDeleter := actions.DiskDeleter{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, diskDeleter.DeleteDisk)
-
-	case "detach_disk":
-		volumeManager := actions.VolumeManager{
-			ClientProvider:    provider,
-			Clock:             clock.NewClock(),
-			PodReadyTimeout:   DefaultPodReadyTimeout,
-			PostRecreateDelay: DefaultPostRecreateDelay,
+	traceFile := cpiConf.TraceFile
+	if *traceFileFlag != "" {
+		traceFile = *traceFileFlag
+	}
+	if traceFile != "" {
+		trace, err := os.OpenFile(traceFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			panic(err)
 		}
-		result, err = cpi.Dispatch(&req, volumeManager.DetachDisk)
-
-	case "get_disks":
-		diskGetter := actions.DiskGetter{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, diskGetter.GetDisks)
-
-	case "set_disk_metadata":
-		diskMetadataSetter := actions.DiskMetadataSetter{ClientProvider: provider}
-		result, err = cpi.Dispatch(&req, diskMetadataSetter.SetDiskMetadata)
+		defer trace.Close()
+		registry.Use(actions.Trace(trace, clk))
+	}
+	if *debugFlag {
+		registry.Use(actions.LogRequests(os.Stderr))
+		registry.Use(actions.Timing(clk, func(method string, duration time.Duration, err error) {
+			fmt.Fprintf(os.Stderr, "%s completed in %s\n", method, duration)
+		}))
+	}
 
-	// Not implemented
-	case "configure_networks":
-		result, err = nil, &cpi.NotSupportedError{}
+	// Metrics are observed for every request. A server exposes them on
+	// -metricsListen; a single request writes or pushes them when it is done.
+	cpiMetrics := metrics.New()
+	gatherer := prometheus.NewRegistry()
+	err = cpiMetrics.Register(gatherer)
+	if err != nil {
+		panic(err)
+	}
+	registry.Use(cpiMetrics.Middleware(clk))
+
+	registry.Use(actions.Timeout(cpiConf.RequestTimeout.Duration))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The director stops a CPI call with SIGTERM; cancel the request so it
+	// fails cleanly instead of being killed in the middle of an operation.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	if *listenFlag != "" {
+		listener, err := server.Listen(*listenFlag)
+		if err != nil {
+			panic(err)
+		}
 
-	case "reboot_vm":
-		result, err = nil, &cpi.NotSupportedError{}
+		// Metrics are served on an address of their own, so scraping them
+		// does not need access to the CPI socket.
+		if *metricsListenFlag != "" {
+			metricsListener, err := net.Listen("tcp", *metricsListenFlag)
+			if err != nil {
+				panic(err)
+			}
+
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+			go func() {
+				err := serve(ctx, metricsListener, metricsMux)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "Serving metrics: %s\n", err)
+				}
+			}()
+		}
 
-	case "snapshot_disk":
-		result, err = nil, &cpi.NotImplementedError{}
+		mux := http.NewServeMux()
+		mux.Handle(server.RequestPath, server.Handler(registry))
 
-	case "delete_snapshot":
-		result, err = nil, &cpi.NotImplementedError{}
+		err = serve(ctx, listener, mux)
+		shutdownTracing(context.Background())
+		if err != nil {
+			panic(err)
+		}
+		return
+	}
 
-	default:
-		err = fmt.Errorf("Unexpected method: %q", req.Method)
+	payload, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		panic(err)
 	}
 
+	var req cpi.Request
+	err = json.Unmarshal(payload, &req)
 	if err != nil {
 		panic(err)
 	}
 
+	result, err := registry.Handle(ctx, &req)
+	if err != nil {
+		result = cpi.NewErrorResponse(err)
+	}
+
 	response, err := json.Marshal(result)
 	if err != nil {
 		panic(err)
 	}
 
-	debugJSON("response", response)
 	fmt.Printf("%s", response)
+
+	err = shutdownTracing(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Exporting spans: %s\n", err)
+	}
+
+	// Failing to report metrics must not fail the request.
+	if *metricsTextfileFlag != "" {
+		err = metrics.WriteTextfile(*metricsTextfileFlag, gatherer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Writing metrics: %s\n", err)
+		}
+	}
+	if *metricsPushURLFlag != "" {
+		err = metrics.Push(*metricsPushURLFlag, "bosh_cpi", pushInstance(&req), gatherer)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+		}
+	}
 }
 
-func debugJSON(stem string, payload []byte) {
-	if *debugFlag {
-		fmt.Fprintf(os.Stderr, `{ "%s": %s }%c`, stem, payload, '\n')
+// chainWrapTransport applies the transport wrappers of a provider in order.
+// Nil wrappers are skipped.
+func chainWrapTransport(wrappers ...func(string, http.RoundTripper) http.RoundTripper) func(string, http.RoundTripper) http.RoundTripper {
+	return func(contextName string, rt http.RoundTripper) http.RoundTripper {
+		for _, wrap := range wrappers {
+			if wrap != nil {
+				rt = wrap(contextName, rt)
+			}
+		}
+		return rt
+	}
+}
+
+// pushInstance names the Pushgateway group of a CPI call: the request ID
+// the director sent, or else the host and process of the call.
+func pushInstance(req *cpi.Request) string {
+	if req.Context.RequestID != "" {
+		return req.Context.RequestID
 	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
 }
 
-func loadKubeConfig(path string) (*config.Kubernetes, error) {
-	kubeConfigFile, err := os.Open(path)
+// serve handles HTTP requests on listener until ctx is cancelled.
+func serve(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	httpServer := &http.Server{Handler: handler}
+	go func() {
+		<-ctx.Done()
+		httpServer.Close()
+	}()
+
+	err := httpServer.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// replay runs a recorded session against a fake cluster and prints how
+// each response compares to the recording. It reports whether all matched.
+func replay(dir string) (bool, error) {
+	calls, err := recorder.Load(dir)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	defer kubeConfigFile.Close()
 
-	var kubeConf config.Kubernetes
-	err = json.NewDecoder(kubeConfigFile).Decode(&kubeConf)
+	agentConf := &config.Agent{}
+	if *agentConfigFlag != "" {
+		agentConf, err = loadAgentConfig(*agentConfigFlag)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	cpiConf, err := loadCPIConfig(*cpiConfigFlag)
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	return &kubeConf, nil
+	provider, err := recorder.NewFakeProvider(calls)
+	if err != nil {
+		return false, err
+	}
+
+	registry := actions.NewDefaultRegistry(&actions.Dependencies{
+		AgentConfig:       agentConf,
+		CPIConfig:         cpiConf,
+		ClientProvider:    provider,
+		Clock:             clock.NewClock(),
+		GUIDGeneratorFunc: recorder.GUIDs(calls),
+	})
+	registry.Use(actions.Recover())
+
+	matched := true
+	encoder := json.NewEncoder(os.Stdout)
+	for _, result := range recorder.Replay(context.Background(), calls, registry.Handle) {
+		matched = matched && result.Match
+		err = encoder.Encode(result)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return matched, nil
 }
 
 func loadAgentConfig(path string) (*config.Agent, error) {
@@ -211,3 +423,28 @@ func loadAgentConfig(path string) (*config.Agent, error) {
 
 	return &agentConf, nil
 }
+
+func loadCPIConfig(path string) (*config.CPI, error) {
+	cpiConf := config.DefaultCPI()
+	if path == "" {
+		return cpiConf, nil
+	}
+
+	cpiConfigFile, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer cpiConfigFile.Close()
+
+	err = json.NewDecoder(cpiConfigFile).Decode(cpiConf)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cpiConf.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cpiConf, nil
+}
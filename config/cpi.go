@@ -0,0 +1,196 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	DefaultPostRecreateDelay = 15 * time.Second
+	DefaultPodReadyTimeout   = 300 * time.Second
+)
+
+// CPI holds the settings of the CPI itself and the cloud properties every
+// vm_type and disk_type starts from.
+//
+// The cloud properties of a vm_type are resolved in three layers: the
+// vm_defaults, then the profile named by the vm_type's "profile" property,
+// and finally the vm_type's own properties. Objects are merged key by key at
+// every level; any other value, including lists, replaces the value of the
+// layer below. Disk types are resolved the same way from disk_defaults.
+type CPI struct {
+	PodReadyTimeout   Duration `json:"pod_ready_timeout"`
+	PostRecreateDelay Duration `json:"post_recreate_delay"`
+
+	// RequestTimeout bounds the time spent on a single CPI request. No limit
+	// is applied when it is zero.
+	RequestTimeout Duration `json:"request_timeout,omitempty"`
+
+	// TraceFile is appended a redacted line of JSON for every request when
+	// it is set.
+	TraceFile string `json:"trace_file,omitempty"`
+
+	// DisableEvents stops the CPI from recording Kubernetes Events about
+	// the objects of VMs and disks.
+	DisableEvents bool `json:"disable_events,omitempty"`
+
+	// AdoptUnlabeledObjects migrates VMs and disks created before objects
+	// were labelled with the UUID of their director. Without it they are
+	// invisible to has_vm, has_disk and get_disks. When it is set they are
+	// visible to every director and labelled by the first one to find
+	// them, so it must only be enabled while a single director uses a
+	// namespace.
+	AdoptUnlabeledObjects bool `json:"adopt_unlabeled_objects,omitempty"`
+
+	// ReadablePodNames names the pod, settings secret and ephemeral disk
+	// of new VMs after their deployment and instance group, e.g.
+	// "cf-database-1a2b3c4d", instead of "agent-<agent id>". The name is
+	// recorded in the VM CID.
+	ReadablePodNames bool `json:"readable_pod_names,omitempty"`
+
+	// Namespaces controls the namespaces VMs are placed in and how the
+	// CPI creates them.
+	Namespaces Namespaces `json:"namespaces,omitempty"`
+
+	VMDefaults   map[string]interface{}            `json:"vm_defaults,omitempty"`
+	DiskDefaults map[string]interface{}            `json:"disk_defaults,omitempty"`
+	Profiles     map[string]map[string]interface{} `json:"profiles,omitempty"`
+}
+
+// Namespaces configures per-deployment namespaces.
+//
+// A VM is placed in the namespace named by its "namespace" cloud property.
+// Without one, Template is rendered with the BOSH group, deployment and
+// director names from the VM's environment, e.g. "bosh-{{.Deployment}}".
+// VMs use the namespace of their context when neither is set. Disks go to
+// the namespace of their VM; a disk created without a VM needs the
+// "namespace" cloud property when Template is set.
+type Namespaces struct {
+	Template string `json:"template,omitempty"`
+
+	// Labels are added to namespaces created by the CPI.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// PodSecurity is the Pod Security Admission level enforced in
+	// namespaces created by the CPI. Only privileged is accepted: the pods
+	// of BOSH VMs are privileged and run as root, which the baseline and
+	// restricted levels reject.
+	PodSecurity string `json:"pod_security,omitempty"`
+
+	// ResourceQuota holds the hard limits of the quota created in new
+	// namespaces, e.g. {"requests.cpu": "8", "pods": "20"}.
+	ResourceQuota map[string]string `json:"resource_quota,omitempty"`
+
+	// LimitRange holds the container limits created in new namespaces.
+	LimitRange LimitRange `json:"limit_range,omitempty"`
+}
+
+// LimitRange holds the container limits of a namespace by resource name.
+type LimitRange struct {
+	Default        map[string]string `json:"default,omitempty"`
+	DefaultRequest map[string]string `json:"default_request,omitempty"`
+	Max            map[string]string `json:"max,omitempty"`
+	Min            map[string]string `json:"min,omitempty"`
+}
+
+// IsEmpty reports whether no limits are configured.
+func (l LimitRange) IsEmpty() bool {
+	return len(l.Default) == 0 && len(l.DefaultRequest) == 0 && len(l.Max) == 0 && len(l.Min) == 0
+}
+
+// PodSecurityPrivileged is the Pod Security Admission level BOSH VMs run
+// under.
+const PodSecurityPrivileged = "privileged"
+
+// Validate checks the settings that would otherwise only fail once a VM is
+// created.
+func (c *CPI) Validate() error {
+	switch c.Namespaces.PodSecurity {
+	case "", PodSecurityPrivileged:
+		return nil
+	case "baseline", "restricted":
+		return fmt.Errorf("namespaces.pod_security: %q does not admit the privileged pods of BOSH VMs; use %q", c.Namespaces.PodSecurity, PodSecurityPrivileged)
+	default:
+		return fmt.Errorf("namespaces.pod_security: %q is not one of privileged, baseline or restricted", c.Namespaces.PodSecurity)
+	}
+}
+
+// DefaultCPI returns the configuration used when no CPI configuration file is
+// provided. Loaded configuration files are decoded on top of it.
+func DefaultCPI() *CPI {
+	return &CPI{
+		PodReadyTimeout:   Duration{DefaultPodReadyTimeout},
+		PostRecreateDelay: Duration{DefaultPostRecreateDelay},
+	}
+}
+
+// VMCloudProperties resolves the cloud properties of a vm_type against the
+// defaults and the profile it names.
+func (c *CPI) VMCloudProperties(cloudProps interface{}) (map[string]interface{}, error) {
+	inline, err := propertyMap(cloudProps)
+	if err != nil {
+		return nil, err
+	}
+
+	result := merge(nil, c.VMDefaults)
+	if name, ok := inline["profile"]; ok {
+		profileName, ok := name.(string)
+		if !ok {
+			return nil, fmt.Errorf("profile must be a string: %v", name)
+		}
+
+		profile, ok := c.Profiles[profileName]
+		if !ok {
+			return nil, fmt.Errorf("profile %q is not defined", profileName)
+		}
+		result = merge(result, profile)
+	}
+
+	return merge(result, inline), nil
+}
+
+// DiskCloudProperties resolves the cloud properties of a disk_type against
+// the disk defaults.
+func (c *CPI) DiskCloudProperties(cloudProps interface{}) (map[string]interface{}, error) {
+	inline, err := propertyMap(cloudProps)
+	if err != nil {
+		return nil, err
+	}
+
+	return merge(merge(nil, c.DiskDefaults), inline), nil
+}
+
+func propertyMap(cloudProps interface{}) (map[string]interface{}, error) {
+	switch props := cloudProps.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return props, nil
+	default:
+		return nil, fmt.Errorf("cloud properties must be an object: %v", cloudProps)
+	}
+}
+
+// merge returns a copy of base with overlay merged into it. Nested objects
+// are merged recursively; all other values from overlay replace those in
+// base.
+func merge(base, overlay map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for k, v := range base {
+		result[k] = v
+	}
+
+	for k, v := range overlay {
+		overlayMap, overlayIsMap := v.(map[string]interface{})
+		baseMap, baseIsMap := result[k].(map[string]interface{})
+		if overlayIsMap && baseIsMap {
+			result[k] = merge(baseMap, overlayMap)
+		} else if overlayIsMap {
+			result[k] = merge(nil, overlayMap)
+		} else {
+			result[k] = v
+		}
+	}
+
+	return result
+}
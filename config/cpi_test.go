@@ -0,0 +1,117 @@
+package config_test
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/evoila/kubernetes-cpi/config"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CPI Config", func() {
+	var cpiConf *config.CPI
+
+	BeforeEach(func() {
+		cpiConf = config.DefaultCPI()
+		err := json.Unmarshal([]byte(`{
+			"post_recreate_delay": "5s",
+			"vm_defaults": {
+				"context": "bosh",
+				"image_pull_policy": "IfNotPresent",
+				"resources": { "requests": { "cpu": "100m", "memory": "256Mi" } }
+			},
+			"disk_defaults": { "context": "bosh" },
+			"profiles": {
+				"large": {
+					"resources": { "requests": { "memory": "4Gi" }, "limits": { "memory": "8Gi" } },
+					"services": [ { "name": "one" } ]
+				}
+			}
+		}`), cpiConf)
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("keeps defaults that are not configured", func() {
+		Expect(cpiConf.PodReadyTimeout.Duration).To(Equal(config.DefaultPodReadyTimeout))
+		Expect(cpiConf.PostRecreateDelay.Duration).To(Equal(5 * time.Second))
+	})
+
+	Describe("VMCloudProperties", func() {
+		It("applies the defaults", func() {
+			props, err := cpiConf.VMCloudProperties(map[string]interface{}{"image_pull_policy": "Always"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Marshal(props)).To(MatchJSON(`{
+				"context": "bosh",
+				"image_pull_policy": "Always",
+				"resources": { "requests": { "cpu": "100m", "memory": "256Mi" } }
+			}`))
+		})
+
+		It("merges the profile between the defaults and the inline properties", func() {
+			props, err := cpiConf.VMCloudProperties(map[string]interface{}{
+				"profile":   "large",
+				"resources": map[string]interface{}{"limits": map[string]interface{}{"cpu": "2"}},
+				"services":  []interface{}{map[string]interface{}{"name": "two"}},
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(json.Marshal(props)).To(MatchJSON(`{
+				"context": "bosh",
+				"profile": "large",
+				"image_pull_policy": "IfNotPresent",
+				"resources": {
+					"requests": { "cpu": "100m", "memory": "4Gi" },
+					"limits": { "cpu": "2", "memory": "8Gi" }
+				},
+				"services": [ { "name": "two" } ]
+			}`))
+		})
+
+		It("does not modify the configured defaults", func() {
+			_, err := cpiConf.VMCloudProperties(map[string]interface{}{"profile": "large"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cpiConf.VMDefaults["resources"]).To(Equal(map[string]interface{}{
+				"requests": map[string]interface{}{"cpu": "100m", "memory": "256Mi"},
+			}))
+		})
+
+		Context("when the profile does not exist", func() {
+			It("returns an error", func() {
+				_, err := cpiConf.VMCloudProperties(map[string]interface{}{"profile": "huge"})
+				Expect(err).To(MatchError(`profile "huge" is not defined`))
+			})
+		})
+
+		Context("when the cloud properties are not an object", func() {
+			It("returns an error", func() {
+				_, err := cpiConf.VMCloudProperties("nope")
+				Expect(err).To(MatchError("cloud properties must be an object: nope"))
+			})
+		})
+	})
+
+	Describe("DiskCloudProperties", func() {
+		It("applies the disk defaults", func() {
+			props, err := cpiConf.DiskCloudProperties(nil)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(props).To(Equal(map[string]interface{}{"context": "bosh"}))
+		})
+	})
+
+	Describe("Validate", func() {
+		It("accepts the privileged pod security level", func() {
+			cpiConf.Namespaces.PodSecurity = "privileged"
+			Expect(cpiConf.Validate()).To(Succeed())
+		})
+
+		It("rejects levels that do not admit privileged pods", func() {
+			cpiConf.Namespaces.PodSecurity = "restricted"
+			Expect(cpiConf.Validate()).To(MatchError(`namespaces.pod_security: "restricted" does not admit the privileged pods of BOSH VMs; use "privileged"`))
+		})
+
+		It("rejects unknown levels", func() {
+			cpiConf.Namespaces.PodSecurity = "Privileged"
+			Expect(cpiConf.Validate()).To(MatchError(`namespaces.pod_security: "Privileged" is not one of privileged, baseline or restricted`))
+		})
+	})
+})
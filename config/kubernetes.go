@@ -1,25 +1,92 @@
 package config
 
-import clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+// InClusterName is the name of the cluster, user and context generated for
+// the mounted service account when in_cluster is set.
+const InClusterName = "in-cluster"
+
+// ServiceAccountDir is where Kubernetes mounts the service account token,
+// CA bundle and namespace of a pod.
+var ServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
 
 type Cluster struct {
 	Server                   string `json:"server"`
 	InsecureSkipTLSVerify    bool   `json:"insecure_skip_tls_verify,omitempty"`
+	CertificateAuthority     string `json:"certificate_authority,omitempty"`
 	CertificateAuthorityData string `json:"certificate_authority_data"`
+	ProxyURL                 string `json:"proxy_url,omitempty"`
+	TLSServerName            string `json:"tls_server_name,omitempty"`
 }
 
 type AuthInfo struct {
-	ClientCertificateData string `json:"client_certificate_data,omitempty"`
-	ClientKeyData         string `json:"client_key_data,omitempty"`
-	Token                 string `json:"token,omitempty"`
-	Username              string `json:"username,omitempty"`
-	Password              string `json:"password,omitempty"`
+	ClientCertificateData string              `json:"client_certificate_data,omitempty"`
+	ClientKeyData         string              `json:"client_key_data,omitempty"`
+	Token                 string              `json:"token,omitempty"`
+	TokenFile             string              `json:"token_file,omitempty"`
+	Username              string              `json:"username,omitempty"`
+	Password              string              `json:"password,omitempty"`
+	Exec                  *ExecConfig         `json:"exec,omitempty"`
+	AuthProvider          *AuthProviderConfig `json:"auth_provider,omitempty"`
+}
+
+// DefaultExecAPIVersion is the credential plugin API used when an exec
+// configuration does not name one.
+const DefaultExecAPIVersion = "client.authentication.k8s.io/v1alpha1"
+
+// ExecConfig runs an external command to obtain credentials.
+type ExecConfig struct {
+	Command    string            `json:"command"`
+	Args       []string          `json:"args,omitempty"`
+	Env        map[string]string `json:"env,omitempty"`
+	APIVersion string            `json:"api_version,omitempty"`
+}
+
+// AuthProviderConfig selects a client-go auth provider plugin such as oidc
+// or gcp.
+type AuthProviderConfig struct {
+	Name   string            `json:"name"`
+	Config map[string]string `json:"config,omitempty"`
 }
 
 type Context struct {
-	Cluster   string `json:"cluster"`
-	AuthInfo  string `json:"user"`
-	Namespace string `json:"namespace"`
+	Cluster   string   `json:"cluster"`
+	AuthInfo  string   `json:"user"`
+	Namespace string   `json:"namespace"`
+	QPS       float32  `json:"qps,omitempty"`
+	Burst     int      `json:"burst,omitempty"`
+	Timeout   Duration `json:"timeout,omitempty"`
+
+	// The identity the requests of this context are made as when the user
+	// is allowed to impersonate it.
+	ImpersonateUser   string   `json:"impersonate_user,omitempty"`
+	ImpersonateGroups []string `json:"impersonate_groups,omitempty"`
+}
+
+// ConnectionOptions are the settings of a context that have no place in a
+// kubeconfig file. They are applied to the rest.Config of the context.
+type ConnectionOptions struct {
+	ProxyURL      string
+	TLSServerName string
+	QPS           float32
+	Burst         int
+	Timeout       time.Duration
+
+	ImpersonateUser   string
+	ImpersonateGroups []string
 }
 
 type Kubernetes struct {
@@ -27,6 +94,109 @@ type Kubernetes struct {
 	AuthInfos      map[string]*AuthInfo `json:"users"`
 	Contexts       map[string]*Context  `json:"contexts"`
 	CurrentContext string               `json:"current_context"`
+
+	// InCluster adds a context for the service account of the pod the
+	// CPI is running in.
+	InCluster bool `json:"in_cluster,omitempty"`
+}
+
+// ParseKubeConfig accepts the CPI's own kubernetes configuration as well as
+// a regular kubeconfig file in YAML or JSON. Connection options are only
+// available in the CPI's configuration.
+func ParseKubeConfig(data []byte) (clientcmdapi.Config, map[string]ConnectionOptions, error) {
+	if isKubeConfigFile(data) {
+		cc, err := clientcmd.Load(data)
+		if err != nil {
+			return clientcmdapi.Config{}, nil, err
+		}
+		return *cc, map[string]ConnectionOptions{}, nil
+	}
+
+	var kubeConf Kubernetes
+	err := json.Unmarshal(data, &kubeConf)
+	if err != nil {
+		return clientcmdapi.Config{}, nil, err
+	}
+
+	cc := kubeConf.ClientConfig()
+	if kubeConf.InCluster {
+		err = addInClusterConfig(&cc)
+		if err != nil {
+			return clientcmdapi.Config{}, nil, err
+		}
+	}
+
+	return cc, kubeConf.ConnectionOptions(), nil
+}
+
+// LoadKubeConfig reads a configuration ParseKubeConfig accepts from path.
+// Relative file references in a kubeconfig file are resolved against its
+// directory, the way kubectl does.
+func LoadKubeConfig(path string) (clientcmdapi.Config, map[string]ConnectionOptions, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return clientcmdapi.Config{}, nil, err
+	}
+
+	if !isKubeConfigFile(data) {
+		return ParseKubeConfig(data)
+	}
+
+	cc, err := clientcmd.LoadFromFile(path)
+	if err != nil {
+		return clientcmdapi.Config{}, nil, err
+	}
+	err = clientcmd.ResolveLocalPaths(cc)
+	if err != nil {
+		return clientcmdapi.Config{}, nil, err
+	}
+	return *cc, map[string]ConnectionOptions{}, nil
+}
+
+// isKubeConfigFile reports whether data is a kubeconfig file. Anything that
+// is not JSON is assumed to be a YAML kubeconfig.
+func isKubeConfigFile(data []byte) bool {
+	var header struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return true
+	}
+	return header.Kind == "Config" || header.APIVersion != ""
+}
+
+func addInClusterConfig(cc *clientcmdapi.Config) error {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return errors.New("in_cluster requires KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT")
+	}
+
+	namespace, err := ioutil.ReadFile(filepath.Join(ServiceAccountDir, "namespace"))
+	if err != nil {
+		return err
+	}
+
+	cluster := clientcmdapi.NewCluster()
+	cluster.Server = "https://" + net.JoinHostPort(host, port)
+	cluster.CertificateAuthority = filepath.Join(ServiceAccountDir, "ca.crt")
+	cc.Clusters[InClusterName] = cluster
+
+	authInfo := clientcmdapi.NewAuthInfo()
+	authInfo.TokenFile = filepath.Join(ServiceAccountDir, "token")
+	cc.AuthInfos[InClusterName] = authInfo
+
+	context := clientcmdapi.NewContext()
+	context.Cluster = InClusterName
+	context.AuthInfo = InClusterName
+	context.Namespace = strings.TrimSpace(string(namespace))
+	cc.Contexts[InClusterName] = context
+
+	if cc.CurrentContext == "" {
+		cc.CurrentContext = InClusterName
+	}
+
+	return nil
 }
 
 func (k Kubernetes) ClientConfig() clientcmdapi.Config {
@@ -45,9 +215,31 @@ func (k Kubernetes) ClientConfig() clientcmdapi.Config {
 	return *cc
 }
 
+// ConnectionOptions returns the connection options of every context,
+// combining the settings of the context with those of its cluster.
+func (k Kubernetes) ConnectionOptions() map[string]ConnectionOptions {
+	options := map[string]ConnectionOptions{}
+	for name, context := range k.Contexts {
+		opts := ConnectionOptions{
+			QPS:               context.QPS,
+			Burst:             context.Burst,
+			Timeout:           context.Timeout.Duration,
+			ImpersonateUser:   context.ImpersonateUser,
+			ImpersonateGroups: context.ImpersonateGroups,
+		}
+		if cluster, ok := k.Clusters[context.Cluster]; ok {
+			opts.ProxyURL = cluster.ProxyURL
+			opts.TLSServerName = cluster.TLSServerName
+		}
+		options[name] = opts
+	}
+	return options
+}
+
 func (a *AuthInfo) api() *clientcmdapi.AuthInfo {
 	info := clientcmdapi.NewAuthInfo()
 	info.Token = a.Token
+	info.TokenFile = a.TokenFile
 	info.Username = a.Username
 	info.Password = a.Password
 	if len(a.ClientCertificateData) != 0 {
@@ -56,13 +248,45 @@ func (a *AuthInfo) api() *clientcmdapi.AuthInfo {
 	if len(a.ClientKeyData) != 0 {
 		info.ClientKeyData = []byte(a.ClientKeyData)
 	}
+	if a.Exec != nil {
+		info.Exec = a.Exec.api()
+	}
+	if a.AuthProvider != nil {
+		info.AuthProvider = &clientcmdapi.AuthProviderConfig{
+			Name:   a.AuthProvider.Name,
+			Config: a.AuthProvider.Config,
+		}
+	}
 	return info
 }
 
+func (e *ExecConfig) api() *clientcmdapi.ExecConfig {
+	exec := &clientcmdapi.ExecConfig{
+		Command:    e.Command,
+		Args:       e.Args,
+		APIVersion: e.APIVersion,
+	}
+	if exec.APIVersion == "" {
+		exec.APIVersion = DefaultExecAPIVersion
+	}
+
+	names := make([]string, 0, len(e.Env))
+	for name := range e.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		exec.Env = append(exec.Env, clientcmdapi.ExecEnvVar{Name: name, Value: e.Env[name]})
+	}
+
+	return exec
+}
+
 func (c *Cluster) api() *clientcmdapi.Cluster {
 	cluster := clientcmdapi.NewCluster()
 	cluster.Server = c.Server
 	cluster.InsecureSkipTLSVerify = c.InsecureSkipTLSVerify
+	cluster.CertificateAuthority = c.CertificateAuthority
 	if len(c.CertificateAuthorityData) != 0 {
 		cluster.CertificateAuthorityData = []byte(c.CertificateAuthorityData)
 	}
@@ -2,6 +2,10 @@ package config_test
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
@@ -33,6 +37,7 @@ var _ = Describe("Kubernetes Config", func() {
 			}
 		}`)
 
+		kubeConf = config.Kubernetes{}
 		err := json.Unmarshal([]byte(configData), &kubeConf)
 		Expect(err).NotTo(HaveOccurred())
 	})
@@ -155,4 +160,222 @@ var _ = Describe("Kubernetes Config", func() {
 			}))
 		})
 	})
+
+	Describe("ConnectionOptions", func() {
+		BeforeEach(func() {
+			kubeConf = config.Kubernetes{}
+			err := json.Unmarshal([]byte(`{
+				"clusters": {
+					"proxied": {
+						"server": "https://10.0.0.1:6443",
+						"certificate_authority": "/var/vcap/jobs/cpi/config/ca.crt",
+						"proxy_url": "http://proxy.example.com:3128",
+						"tls_server_name": "kubernetes.example.com"
+					}
+				},
+				"contexts": {
+					"tuned": {
+						"cluster": "proxied", "user": "bosh", "qps": 20, "burst": 40, "timeout": "45s",
+						"impersonate_user": "system:serviceaccount:tenant-a:bosh",
+						"impersonate_groups": ["tenant-a"]
+					}
+				}
+			}`), &kubeConf)
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		It("combines the cluster and context settings", func() {
+			Expect(kubeConf.ConnectionOptions()).To(Equal(map[string]config.ConnectionOptions{
+				"tuned": {
+					ProxyURL:      "http://proxy.example.com:3128",
+					TLSServerName: "kubernetes.example.com",
+					QPS:           20,
+					Burst:         40,
+					Timeout:       45 * time.Second,
+
+					ImpersonateUser:   "system:serviceaccount:tenant-a:bosh",
+					ImpersonateGroups: []string{"tenant-a"},
+				},
+			}))
+		})
+
+		It("carries the certificate authority file into the api config", func() {
+			cc := kubeConf.ClientConfig()
+			Expect(cc.Clusters["proxied"].CertificateAuthority).To(Equal("/var/vcap/jobs/cpi/config/ca.crt"))
+		})
+	})
+
+	Describe("AuthInfo credential plugins", func() {
+		BeforeEach(func() {
+			kubeConf = config.Kubernetes{
+				AuthInfos: map[string]*config.AuthInfo{
+					"exec-user": &config.AuthInfo{
+						Exec: &config.ExecConfig{
+							Command: "aws-iam-authenticator",
+							Args:    []string{"token", "-i", "cluster"},
+							Env:     map[string]string{"B": "2", "A": "1"},
+						},
+					},
+					"oidc-user": &config.AuthInfo{
+						AuthProvider: &config.AuthProviderConfig{
+							Name:   "oidc",
+							Config: map[string]string{"idp-issuer-url": "https://issuer.example.com"},
+						},
+					},
+					"file-user": &config.AuthInfo{TokenFile: "/var/run/token"},
+				},
+			}
+		})
+
+		It("carries exec, auth provider and token file settings into the api config", func() {
+			cc := kubeConf.ClientConfig()
+			Expect(cc.AuthInfos["exec-user"].Exec).To(Equal(&clientcmdapi.ExecConfig{
+				Command:    "aws-iam-authenticator",
+				Args:       []string{"token", "-i", "cluster"},
+				APIVersion: "client.authentication.k8s.io/v1alpha1",
+				Env: []clientcmdapi.ExecEnvVar{
+					{Name: "A", Value: "1"},
+					{Name: "B", Value: "2"},
+				},
+			}))
+			Expect(cc.AuthInfos["oidc-user"].AuthProvider).To(Equal(&clientcmdapi.AuthProviderConfig{
+				Name:   "oidc",
+				Config: map[string]string{"idp-issuer-url": "https://issuer.example.com"},
+			}))
+			Expect(cc.AuthInfos["file-user"].TokenFile).To(Equal("/var/run/token"))
+		})
+	})
+
+	Describe("ParseKubeConfig", func() {
+		It("parses the CPI configuration", func() {
+			cc, _, err := config.ParseKubeConfig(configData)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cc).To(Equal(kubeConf.ClientConfig()))
+		})
+
+		It("parses a kubeconfig file", func() {
+			cc, _, err := config.ParseKubeConfig([]byte(`
+apiVersion: v1
+kind: Config
+clusters:
+- name: minikube
+  cluster:
+    server: https://192.168.64.17:8443
+    certificate-authority: /home/user/.minikube/ca.crt
+users:
+- name: minikube
+  user:
+    client-certificate: /home/user/.minikube/client.crt
+    client-key: /home/user/.minikube/client.key
+contexts:
+- name: minikube
+  context:
+    cluster: minikube
+    user: minikube
+    namespace: bosh
+current-context: minikube
+`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cc.CurrentContext).To(Equal("minikube"))
+			Expect(cc.Clusters["minikube"].Server).To(Equal("https://192.168.64.17:8443"))
+			Expect(cc.Clusters["minikube"].CertificateAuthority).To(Equal("/home/user/.minikube/ca.crt"))
+			Expect(cc.AuthInfos["minikube"].ClientCertificate).To(Equal("/home/user/.minikube/client.crt"))
+			Expect(cc.Contexts["minikube"].Namespace).To(Equal("bosh"))
+		})
+
+		It("resolves relative paths in a kubeconfig file against its directory", func() {
+			dir, err := ioutil.TempDir("", "kubeconfig")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "config")
+			Expect(ioutil.WriteFile(path, []byte(`
+apiVersion: v1
+kind: Config
+clusters:
+- name: minikube
+  cluster:
+    server: https://192.168.64.17:8443
+    certificate-authority: certs/ca.crt
+users:
+- name: minikube
+  user:
+    client-certificate: certs/client.crt
+    client-key: /home/user/.minikube/client.key
+    tokenFile: token
+contexts:
+- name: minikube
+  context:
+    cluster: minikube
+    user: minikube
+current-context: minikube
+`), 0600)).To(Succeed())
+
+			cc, _, err := config.LoadKubeConfig(path)
+			Expect(err).NotTo(HaveOccurred())
+
+			base, err := filepath.Abs(dir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cc.Clusters["minikube"].CertificateAuthority).To(Equal(filepath.Join(base, "certs", "ca.crt")))
+			Expect(cc.AuthInfos["minikube"].ClientCertificate).To(Equal(filepath.Join(base, "certs", "client.crt")))
+			Expect(cc.AuthInfos["minikube"].ClientKey).To(Equal("/home/user/.minikube/client.key"))
+			Expect(cc.AuthInfos["minikube"].TokenFile).To(Equal(filepath.Join(base, "token")))
+		})
+
+		Context("when in_cluster is set", func() {
+			var saDir, oldDir string
+
+			BeforeEach(func() {
+				var err error
+				saDir, err = ioutil.TempDir("", "serviceaccount")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(ioutil.WriteFile(filepath.Join(saDir, "namespace"), []byte("bosh-ns\n"), 0644)).To(Succeed())
+
+				oldDir = config.ServiceAccountDir
+				config.ServiceAccountDir = saDir
+				os.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+				os.Setenv("KUBERNETES_SERVICE_PORT", "443")
+			})
+
+			AfterEach(func() {
+				config.ServiceAccountDir = oldDir
+				os.Unsetenv("KUBERNETES_SERVICE_HOST")
+				os.Unsetenv("KUBERNETES_SERVICE_PORT")
+				os.RemoveAll(saDir)
+			})
+
+			It("uses the mounted service account", func() {
+				cc, _, err := config.ParseKubeConfig([]byte(`{ "in_cluster": true }`))
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(cc.CurrentContext).To(Equal("in-cluster"))
+				Expect(cc.Clusters["in-cluster"].Server).To(Equal("https://10.0.0.1:443"))
+				Expect(cc.Clusters["in-cluster"].CertificateAuthority).To(Equal(filepath.Join(saDir, "ca.crt")))
+				Expect(cc.AuthInfos["in-cluster"].TokenFile).To(Equal(filepath.Join(saDir, "token")))
+				Expect(cc.Contexts["in-cluster"]).To(Equal(&clientcmdapi.Context{
+					Cluster:    "in-cluster",
+					AuthInfo:   "in-cluster",
+					Namespace:  "bosh-ns",
+					Extensions: map[string]runtime.Object{},
+				}))
+			})
+
+			It("keeps an explicit current context", func() {
+				cc, _, err := config.ParseKubeConfig([]byte(`{ "in_cluster": true, "current_context": "other" }`))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(cc.CurrentContext).To(Equal("other"))
+			})
+
+			Context("when the service environment is missing", func() {
+				BeforeEach(func() {
+					os.Unsetenv("KUBERNETES_SERVICE_HOST")
+				})
+
+				It("returns an error", func() {
+					_, _, err := config.ParseKubeConfig([]byte(`{ "in_cluster": true }`))
+					Expect(err).To(MatchError("in_cluster requires KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT"))
+				})
+			})
+		})
+	})
 })
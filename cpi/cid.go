@@ -0,0 +1,123 @@
+package cpi
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// CIDVersion prefixes CIDs in the current encoding.
+const CIDVersion = "v1"
+
+// Kinds of Kubernetes objects a CID can name.
+const (
+	KindPod                   = "pod"
+	KindPersistentVolumeClaim = "pvc"
+)
+
+// CID holds the parts of a VM or disk CID.
+//
+// CIDs are encoded as "v1:<context>:<namespace>:<kind>:<id>[:<name>]" with
+// every part query escaped. Older CIDs of the form "<context>:<id>" are
+// still parsed; they have no namespace and no kind.
+type CID struct {
+	Context string
+
+	// Namespace is empty when the object lives in the namespace of its
+	// context.
+	Namespace string
+
+	Kind string
+	ID   string
+
+	// Name is the name of the object when it is not derived from the ID.
+	Name string
+}
+
+// String returns the current encoding of the CID.
+func (c CID) String() string {
+	parts := []string{
+		CIDVersion,
+		url.QueryEscape(c.Context),
+		url.QueryEscape(c.Namespace),
+		url.QueryEscape(c.Kind),
+		url.QueryEscape(c.ID),
+	}
+	if c.Name != "" {
+		parts = append(parts, url.QueryEscape(c.Name))
+	}
+	return strings.Join(parts, ":")
+}
+
+// LegacyString returns the encoding used before CIDs were versioned. It is
+// only meant for objects that were created with such a CID.
+func (c CID) LegacyString() string {
+	return c.Context + ":" + c.ID
+}
+
+// ParseCID parses a CID in the current or an older encoding.
+func ParseCID(s string) (CID, error) {
+	cid, err := splitCID(s)
+	if err != nil {
+		return CID{}, fmt.Errorf("invalid CID %q: %s", s, err)
+	}
+	return cid, nil
+}
+
+func splitCID(s string) (CID, error) {
+	parts := strings.Split(s, ":")
+
+	var cid CID
+	switch {
+	case (len(parts) == 5 || len(parts) == 6) && parts[0] == CIDVersion:
+		targets := []*string{&cid.Context, &cid.Namespace, &cid.Kind, &cid.ID, &cid.Name}
+		for i, part := range parts[1:] {
+			part, err := url.QueryUnescape(part)
+			if err != nil {
+				return CID{}, err
+			}
+			*targets[i] = part
+		}
+	case len(parts) == 2:
+		cid = CID{Context: parts[0], ID: parts[1]}
+	default:
+		return CID{}, errors.New("unknown format")
+	}
+
+	if cid.ID == "" {
+		return CID{}, errors.New("missing ID")
+	}
+	return cid, nil
+}
+
+// NewVMCID returns the CID of the VM of an agent.
+func NewVMCID(context, namespace, agentID string) VMCID {
+	return VMCID(CID{Context: context, Namespace: namespace, Kind: KindPod, ID: agentID}.String())
+}
+
+// Parse returns the parts of a VM CID.
+func (v VMCID) Parse() (CID, error) {
+	return parseCID(string(v), KindPod, "VM")
+}
+
+// NewDiskCID returns the CID of a disk.
+func NewDiskCID(context, namespace, diskID string) DiskCID {
+	return DiskCID(CID{Context: context, Namespace: namespace, Kind: KindPersistentVolumeClaim, ID: diskID}.String())
+}
+
+// Parse returns the parts of a disk CID.
+func (d DiskCID) Parse() (CID, error) {
+	return parseCID(string(d), KindPersistentVolumeClaim, "disk")
+}
+
+func parseCID(s, kind, what string) (CID, error) {
+	cid, err := splitCID(s)
+	if err == nil && cid.Kind != "" && cid.Kind != kind {
+		err = fmt.Errorf("%q is not the kind of a %s", cid.Kind, what)
+	}
+	if err != nil {
+		return CID{}, fmt.Errorf("invalid %s CID %q: %s", what, s, err)
+	}
+	return cid, nil
+}
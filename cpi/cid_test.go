@@ -0,0 +1,97 @@
+package cpi_test
+
+import (
+	"github.com/evoila/kubernetes-cpi/cpi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CID", func() {
+	It("encodes the version, context, namespace, kind and ID", func() {
+		cid := cpi.CID{Context: "bosh", Namespace: "deployment", Kind: cpi.KindPod, ID: "agent-id"}
+		Expect(cid.String()).To(Equal("v1:bosh:deployment:pod:agent-id"))
+	})
+
+	It("escapes the parts", func() {
+		cid := cpi.CID{Context: "arn:aws:eks:cluster/bosh", Kind: cpi.KindPod, ID: "agent-id"}
+		Expect(cid.String()).To(Equal("v1:arn%3Aaws%3Aeks%3Acluster%2Fbosh::pod:agent-id"))
+
+		parsed, err := cpi.ParseCID(cid.String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed).To(Equal(cid))
+	})
+
+	It("encodes the name of the object when it has one", func() {
+		cid := cpi.CID{Context: "bosh", Kind: cpi.KindPod, ID: "agent-id", Name: "cf-database-1a2b"}
+		Expect(cid.String()).To(Equal("v1:bosh::pod:agent-id:cf-database-1a2b"))
+
+		parsed, err := cpi.ParseCID(cid.String())
+		Expect(err).NotTo(HaveOccurred())
+		Expect(parsed).To(Equal(cid))
+	})
+
+	It("returns the encoding used before CIDs were versioned", func() {
+		Expect(cpi.CID{Context: "bosh", ID: "disk-id"}.LegacyString()).To(Equal("bosh:disk-id"))
+	})
+
+	It("parses the current encoding", func() {
+		Expect(cpi.ParseCID("v1:bosh:ns:pvc:disk-id")).To(Equal(cpi.CID{Context: "bosh", Namespace: "ns", Kind: cpi.KindPersistentVolumeClaim, ID: "disk-id"}))
+		Expect(cpi.ParseCID("v1:bosh::pod:agent-id")).To(Equal(cpi.CID{Context: "bosh", Kind: cpi.KindPod, ID: "agent-id"}))
+	})
+
+	It("parses the encoding used before CIDs were versioned", func() {
+		Expect(cpi.ParseCID("bosh:agent-id")).To(Equal(cpi.CID{Context: "bosh", ID: "agent-id"}))
+		Expect(cpi.ParseCID("v1:agent-id")).To(Equal(cpi.CID{Context: "v1", ID: "agent-id"}))
+	})
+
+	It("returns errors for malformed CIDs instead of panicking", func() {
+		malformed := map[string]string{
+			"":                         `invalid CID "": unknown format`,
+			"agent-id":                 `invalid CID "agent-id": unknown format`,
+			"a:b:c":                    `invalid CID "a:b:c": unknown format`,
+			"a:b:c:d":                  `invalid CID "a:b:c:d": unknown format`,
+			"bosh:":                    `invalid CID "bosh:": missing ID`,
+			"v1:bosh::pod:":            `invalid CID "v1:bosh::pod:": missing ID`,
+			"v1:bosh%zz::pod:agent-id": `invalid CID "v1:bosh%zz::pod:agent-id": invalid URL escape "%zz"`,
+		}
+
+		for s, message := range malformed {
+			Expect(func() {
+				_, err := cpi.ParseCID(s)
+				Expect(err).To(MatchError(message))
+			}).NotTo(Panic())
+		}
+	})
+
+	Describe("VMCID", func() {
+		It("round trips", func() {
+			cid, err := cpi.NewVMCID("bosh", "ns", "agent-id").Parse()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cid).To(Equal(cpi.CID{Context: "bosh", Namespace: "ns", Kind: cpi.KindPod, ID: "agent-id"}))
+		})
+
+		It("rejects the CID of a disk", func() {
+			_, err := cpi.VMCID(cpi.NewDiskCID("bosh", "", "disk-id")).Parse()
+			Expect(err).To(MatchError(`invalid VM CID "v1:bosh::pvc:disk-id": "pvc" is not the kind of a VM`))
+		})
+
+		It("returns an error for a malformed CID", func() {
+			_, err := cpi.VMCID("agent-id").Parse()
+			Expect(err).To(MatchError(`invalid VM CID "agent-id": unknown format`))
+		})
+	})
+
+	Describe("DiskCID", func() {
+		It("round trips", func() {
+			cid, err := cpi.NewDiskCID("bosh", "", "disk-id").Parse()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cid).To(Equal(cpi.CID{Context: "bosh", Kind: cpi.KindPersistentVolumeClaim, ID: "disk-id"}))
+		})
+
+		It("parses old CIDs", func() {
+			cid, err := cpi.DiskCID("bosh:disk-id").Parse()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cid).To(Equal(cpi.CID{Context: "bosh", ID: "disk-id"}))
+		})
+	})
+})
@@ -1,6 +1,7 @@
 package cpi
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -26,11 +27,28 @@ type ResponseError struct {
 }
 
 func Dispatch(req *Request, actionFunc interface{}) (*Response, error) {
+	return DispatchContext(context.Background(), req, actionFunc)
+}
+
+// DispatchContext calls an action function with the request arguments. When
+// the first parameter of the action is a context.Context, ctx is passed in
+// its place and the request arguments fill the remaining parameters. The
+// context carries the Context of the request; see FromContext.
+func DispatchContext(ctx context.Context, req *Request, actionFunc interface{}) (*Response, error) {
+	ctx = NewContext(ctx, req.Context)
+
 	actionValue := reflect.ValueOf(actionFunc)
 	actionType := actionValue.Type()
 
+	var args []reflect.Value
+	offset := 0
+	if actionType.NumIn() > 0 && actionType.In(0) == contextType {
+		args = append(args, reflect.ValueOf(ctx))
+		offset = 1
+	}
+
 	argCount := len(req.Args)
-	requiredArgCount := actionType.NumIn()
+	requiredArgCount := actionType.NumIn() - offset
 
 	if actionType.IsVariadic() {
 		requiredArgCount--
@@ -44,17 +62,10 @@ func Dispatch(req *Request, actionFunc interface{}) (*Response, error) {
 		return nil, fmt.Errorf("Too many arguments: have %d, want %d", argCount, requiredArgCount)
 	}
 
-	var args []reflect.Value
 	for i, arg := range req.Args {
-		bytes, err := json.Marshal(arg)
-		if err != nil {
-			return nil, err
-		}
-
-		argValue := newArgValue(actionType, i)
-		err = json.Unmarshal(bytes, argValue.Interface())
+		argValue, err := decodeArg(arg, newArgValue(actionType, i+offset))
 		if err != nil {
-			return nil, err
+			return nil, &ArgumentError{Index: i, Err: err}
 		}
 
 		args = append(args, reflect.Indirect(argValue))
@@ -65,6 +76,56 @@ func Dispatch(req *Request, actionFunc interface{}) (*Response, error) {
 	return newResponse(actionResult)
 }
 
+// decodeArg unmarshals an argument into argValue. Types that implement
+// StrictFields are checked for unknown fields first and types that
+// implement Validator are validated after decoding.
+func decodeArg(arg interface{}, argValue reflect.Value) (reflect.Value, error) {
+	if _, ok := argValue.Interface().(StrictFields); ok {
+		err := checkFields(arg, argValue.Type(), "")
+		if err != nil {
+			return argValue, err
+		}
+	}
+
+	bytes, err := json.Marshal(arg)
+	if err != nil {
+		return argValue, err
+	}
+
+	err = json.Unmarshal(bytes, argValue.Interface())
+	if err != nil {
+		if typeErr, ok := err.(*json.UnmarshalTypeError); ok && typeErr.Field != "" {
+			return argValue, &FieldError{Path: typeErr.Field, Err: fmt.Errorf("cannot use %s as %s", typeErr.Value, typeErr.Type)}
+		}
+		return argValue, err
+	}
+
+	if validator, ok := argValue.Interface().(Validator); ok {
+		err = validator.Validate()
+		if err != nil {
+			return argValue, err
+		}
+	}
+
+	return argValue, nil
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
+type requestContextKey struct{}
+
+// NewContext returns a copy of ctx that carries the Context of a request.
+// DispatchContext passes it to the action.
+func NewContext(ctx context.Context, requestContext Context) context.Context {
+	return context.WithValue(ctx, requestContextKey{}, requestContext)
+}
+
+// FromContext returns the Context of the request carried by ctx.
+func FromContext(ctx context.Context) (Context, bool) {
+	requestContext, ok := ctx.Value(requestContextKey{}).(Context)
+	return requestContext, ok
+}
+
 func newArgValue(actionType reflect.Type, index int) reflect.Value {
 	argCount := actionType.NumIn()
 
@@ -109,7 +170,7 @@ func newResponse(result []reflect.Value) (*Response, error) {
 
 	if errValue.IsValid() && !errValue.IsNil() {
 		err := errValue.Interface().(error)
-		resp.Error = &ResponseError{Message: err.Error()}
+		resp.Error = NewErrorResponse(err).Error
 	}
 
 	return resp, nil
@@ -1,7 +1,9 @@
 package cpi_test
 
 import (
+	"context"
 	"errors"
+	"fmt"
 
 	"github.com/evoila/kubernetes-cpi/cpi"
 	. "github.com/onsi/ginkgo"
@@ -52,6 +54,7 @@ var _ = Describe("Dispatch", func() {
 			Expect(resp.Result).To(BeNil())
 			Expect(resp.Error).NotTo(BeNil())
 			Expect(resp.Error.Message).To(Equal("welp"))
+			Expect(resp.Error.Type).To(Equal("Bosh::Clouds::CloudError"))
 
 			Expect(delegate.CallCount).To(Equal(1))
 		})
@@ -186,8 +189,169 @@ var _ = Describe("Dispatch", func() {
 			})
 		})
 	})
+
+	Context("when the action takes a context", func() {
+		BeforeEach(func() {
+			req.Args = []interface{}{"hello"}
+		})
+
+		It("passes the request context before the arguments", func() {
+			ctx := context.WithValue(context.Background(), contextKey{}, "value")
+			resp, err := cpi.DispatchContext(ctx, req, delegate.ContextAndString)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(Equal("value:hello"))
+		})
+
+		It("uses a background context when dispatched without one", func() {
+			resp, err := cpi.Dispatch(req, delegate.ContextAndString)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(resp.Result).To(Equal("<nil>:hello"))
+		})
+
+		It("carries the Context of the request", func() {
+			req.Context = cpi.Context{DirectorUUID: "director-uuid"}
+
+			var requestContext cpi.Context
+			_, err := cpi.Dispatch(req, func(ctx context.Context, s string) error {
+				requestContext, _ = cpi.FromContext(ctx)
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(requestContext.DirectorUUID).To(Equal("director-uuid"))
+		})
+
+		It("does not count the context as an argument", func() {
+			req.Args = []interface{}{}
+			_, err := cpi.Dispatch(req, delegate.ContextAndString)
+			Expect(err).To(MatchError("Not enough arguments: have 0, want 1"))
+		})
+	})
+
+	Context("when an argument requires strict fields", func() {
+		BeforeEach(func() {
+			req.Args = []interface{}{
+				map[string]interface{}{
+					"name": "hello",
+					"nested": map[string]interface{}{
+						"count": 1,
+					},
+				},
+			}
+		})
+
+		It("calls the action when all fields are known", func() {
+			_, err := cpi.Dispatch(req, delegate.StrictArg)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(delegate.CallCount).To(Equal(1))
+		})
+
+		Context("and the argument contains an unknown field", func() {
+			BeforeEach(func() {
+				req.Args = []interface{}{
+					"ignored",
+					map[string]interface{}{
+						"name": "hello",
+						"nested": map[string]interface{}{
+							"cuont": 1,
+						},
+					},
+				}
+			})
+
+			It("names the argument and the path of the field", func() {
+				_, err := cpi.Dispatch(req, delegate.StringAndStrictArg)
+				Expect(err).To(MatchError("Invalid argument 1: nested.cuont: unknown field"))
+				Expect(delegate.CallCount).To(Equal(0))
+			})
+		})
+
+		Context("and a list element contains an unknown field", func() {
+			BeforeEach(func() {
+				req.Args = []interface{}{
+					map[string]interface{}{
+						"items": []interface{}{
+							map[string]interface{}{"count": 1},
+							map[string]interface{}{"size": 1},
+						},
+					},
+				}
+			})
+
+			It("includes the index in the path", func() {
+				_, err := cpi.Dispatch(req, delegate.StrictArg)
+				Expect(err).To(MatchError("Invalid argument 0: items[1].size: unknown field"))
+			})
+		})
+
+		Context("and a field has the wrong type", func() {
+			BeforeEach(func() {
+				req.Args = []interface{}{
+					map[string]interface{}{
+						"nested": map[string]interface{}{"count": "one"},
+					},
+				}
+			})
+
+			It("names the path of the field", func() {
+				_, err := cpi.Dispatch(req, delegate.StrictArg)
+				Expect(err).To(MatchError("Invalid argument 0: nested.count: cannot use string as int"))
+			})
+		})
+
+		Context("and the argument fails validation", func() {
+			BeforeEach(func() {
+				req.Args = []interface{}{
+					map[string]interface{}{
+						"nested": map[string]interface{}{"count": -1},
+					},
+				}
+			})
+
+			It("returns the validation error", func() {
+				_, err := cpi.Dispatch(req, delegate.StrictArg)
+				Expect(err).To(MatchError("Invalid argument 0: nested.count: must not be negative"))
+				Expect(delegate.CallCount).To(Equal(0))
+			})
+		})
+	})
 })
 
+type Nested struct {
+	Count int `json:"count"`
+}
+
+type StrictProperties struct {
+	Name   string   `json:"name"`
+	Nested Nested   `json:"nested"`
+	Items  []Nested `json:"items"`
+}
+
+func (StrictProperties) StrictFields() {}
+
+func (s StrictProperties) Validate() error {
+	if s.Nested.Count < 0 {
+		return cpi.NewFieldError("nested.count", "must not be negative")
+	}
+	return nil
+}
+
+func (d *Delegate) StrictArg(s StrictProperties) error {
+	d.CallCount++
+	return nil
+}
+
+func (d *Delegate) StringAndStrictArg(s string, p StrictProperties) error {
+	d.CallCount++
+	return nil
+}
+
+type contextKey struct{}
+
+func (d *Delegate) ContextAndString(ctx context.Context, s string) (string, error) {
+	d.CallCount++
+	return fmt.Sprintf("%v:%s", ctx.Value(contextKey{}), s), nil
+}
+
 type Delegate struct {
 	CallCount int
 }
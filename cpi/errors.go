@@ -14,3 +14,21 @@ type DiskNotAttachedError struct{}
 
 func (e DiskNotAttachedError) Type() string  { return "Bosh::Clouds::DiskNotAttached" }
 func (e DiskNotAttachedError) Error() string { return "Disk not attached" }
+
+// CloudErrorType is reported for errors that do not name a Bosh error type.
+const CloudErrorType = "Bosh::Clouds::CloudError"
+
+// NewErrorResponse returns a response that reports err to the director.
+// Errors that implement Type() string report their own Bosh error type.
+func NewErrorResponse(err error) *Response {
+	errType := CloudErrorType
+	if typed, ok := err.(interface {
+		Type() string
+	}); ok {
+		errType = typed.Type()
+	}
+
+	return &Response{
+		Error: &ResponseError{Type: errType, Message: err.Error()},
+	}
+}
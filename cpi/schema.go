@@ -0,0 +1,129 @@
+package cpi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// SchemaDraft identifies the JSON Schema version produced by Schema.
+const SchemaDraft = "http://json-schema.org/draft-07/schema#"
+
+// Schema returns a JSON Schema for the JSON encoding of v. Objects of
+// types that implement StrictFields do not allow additional properties.
+// String fields may list their allowed values in an enum tag:
+//
+//	Type string `json:"type" enum:"ClusterIP,NodePort"`
+//
+// CheckEnum validates a field against the same tag.
+func Schema(v interface{}) map[string]interface{} {
+	return typeSchema(reflect.TypeOf(v))
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem())}
+
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem())}
+
+	case reflect.Struct:
+		return structSchema(t)
+
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func structSchema(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	addProperties(properties, t)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if reflect.PtrTo(t).Implements(reflect.TypeOf((*StrictFields)(nil)).Elem()) {
+		schema["additionalProperties"] = false
+	}
+	return schema
+}
+
+func addProperties(properties map[string]interface{}, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addProperties(properties, field.Type)
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldSchema := typeSchema(field.Type)
+		if enum := field.Tag.Get("enum"); enum != "" {
+			var values []interface{}
+			for _, value := range strings.Split(enum, ",") {
+				values = append(values, value)
+			}
+			fieldSchema["enum"] = values
+		}
+		properties[name] = fieldSchema
+	}
+}
+
+// CheckEnum returns an error unless the string field of the struct v named
+// field is empty or one of the values listed in its enum tag.
+func CheckEnum(v interface{}, field string) error {
+	value := reflect.Indirect(reflect.ValueOf(v))
+	structField, ok := value.Type().FieldByName(field)
+	if !ok {
+		return fmt.Errorf("%s has no field %s", value.Type(), field)
+	}
+
+	actual := value.FieldByIndex(structField.Index).String()
+	if actual == "" {
+		return nil
+	}
+
+	values := strings.Split(structField.Tag.Get("enum"), ",")
+	for _, allowed := range values {
+		if actual == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("%q is not one of %s", actual, strings.Join(values, ", "))
+}
@@ -0,0 +1,74 @@
+package cpi_test
+
+import (
+	"github.com/evoila/kubernetes-cpi/cpi"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+type SchemaItem struct {
+	Size uint `json:"size"`
+}
+
+type SchemaEmbedded struct {
+	Zone string `json:"zone"`
+}
+
+type SchemaProperties struct {
+	SchemaEmbedded
+
+	Name     string            `json:"name"`
+	Mode     string            `json:"mode,omitempty" enum:"A,B"`
+	Enabled  bool              `json:"enabled"`
+	Ratio    float64           `json:"ratio"`
+	Items    []SchemaItem      `json:"items"`
+	Labels   map[string]string `json:"labels"`
+	Ignored  string            `json:"-"`
+	internal string
+}
+
+func (SchemaProperties) StrictFields() {}
+
+var _ = Describe("Schema", func() {
+	It("describes the JSON encoding of a type", func() {
+		Expect(cpi.Schema(SchemaProperties{})).To(Equal(map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": false,
+			"properties": map[string]interface{}{
+				"zone":    map[string]interface{}{"type": "string"},
+				"name":    map[string]interface{}{"type": "string"},
+				"mode":    map[string]interface{}{"type": "string", "enum": []interface{}{"A", "B"}},
+				"enabled": map[string]interface{}{"type": "boolean"},
+				"ratio":   map[string]interface{}{"type": "number"},
+				"items": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"size": map[string]interface{}{"type": "integer", "minimum": 0},
+						},
+					},
+				},
+				"labels": map[string]interface{}{
+					"type":                 "object",
+					"additionalProperties": map[string]interface{}{"type": "string"},
+				},
+			},
+		}))
+	})
+
+	Describe("CheckEnum", func() {
+		It("accepts the values of the enum tag and the empty string", func() {
+			Expect(cpi.CheckEnum(SchemaProperties{Mode: "B"}, "Mode")).To(Succeed())
+			Expect(cpi.CheckEnum(&SchemaProperties{}, "Mode")).To(Succeed())
+		})
+
+		It("rejects other values", func() {
+			Expect(cpi.CheckEnum(SchemaProperties{Mode: "C"}, "Mode")).To(MatchError(`"C" is not one of A, B`))
+		})
+
+		It("rejects unknown fields", func() {
+			Expect(cpi.CheckEnum(SchemaProperties{}, "Missing")).To(MatchError("cpi_test.SchemaProperties has no field Missing"))
+		})
+	})
+})
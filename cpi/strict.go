@@ -0,0 +1,157 @@
+package cpi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// Validator is implemented by argument types that check their values before
+// the action is called.
+type Validator interface {
+	Validate() error
+}
+
+// StrictFields is implemented by argument types that may only contain the
+// fields they declare. Unknown fields in the request are rejected.
+type StrictFields interface {
+	StrictFields()
+}
+
+// ArgumentError reports an argument that could not be decoded or is invalid.
+type ArgumentError struct {
+	Index int
+	Err   error
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("Invalid argument %d: %s", e.Index, e.Err)
+}
+
+// FieldError reports a problem with the value at a JSON path.
+type FieldError struct {
+	Path string
+	Err  error
+}
+
+func (e *FieldError) Error() string {
+	if e.Path == "" {
+		return e.Err.Error()
+	}
+	return e.Path + ": " + e.Err.Error()
+}
+
+// NewFieldError returns a FieldError for the path with a formatted message.
+func NewFieldError(path string, format string, args ...interface{}) *FieldError {
+	return &FieldError{Path: path, Err: fmt.Errorf(format, args...)}
+}
+
+// JoinPath appends a field name to a JSON path.
+func JoinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+	return path + "." + field
+}
+
+var unmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+// checkFields walks a decoded JSON value alongside the Go type it will be
+// unmarshaled into and returns an error for the first field the type does
+// not declare.
+func checkFields(value interface{}, t reflect.Type, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		fields := jsonFields(t)
+		for key, v := range obj {
+			fieldType, ok := lookupField(fields, key)
+			if !ok {
+				return &FieldError{Path: JoinPath(path, key), Err: errors.New("unknown field")}
+			}
+			if err := checkFields(v, fieldType, JoinPath(path, key)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for key, v := range obj {
+			if err := checkFields(v, t.Elem(), JoinPath(path, key)); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Slice, reflect.Array:
+		list, ok := value.([]interface{})
+		if !ok {
+			return nil
+		}
+		for i, v := range list {
+			if err := checkFields(v, t.Elem(), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// jsonFields returns the types of the fields of a struct by JSON name,
+// including the fields of embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for k, v := range jsonFields(field.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+// lookupField matches keys the way encoding/json does: exactly, or else
+// without regard to case.
+func lookupField(fields map[string]reflect.Type, key string) (reflect.Type, bool) {
+	if t, ok := fields[key]; ok {
+		return t, true
+	}
+	for name, t := range fields {
+		if strings.EqualFold(name, key) {
+			return t, true
+		}
+	}
+	return nil, false
+}
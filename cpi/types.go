@@ -2,6 +2,10 @@ package cpi
 
 type Context struct {
 	DirectorUUID string `json:"director_uuid"`
+
+	// RequestID identifies the CPI call of a director task. Older
+	// directors do not send it.
+	RequestID string `json:"request_id,omitempty"`
 }
 
 type Network struct {
@@ -16,9 +20,6 @@ type Network struct {
 	CloudProperties map[string]interface{} `json:"cloud_properties"`
 }
 
-// TODO: Add methods to extract context and ID  from the disk and VM ID's.
-// TODO: Move NewVMCID and NewDiskCID from actions here
-
 type DiskCID string
 
 type Environment map[string]interface{}
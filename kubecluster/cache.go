@@ -0,0 +1,106 @@
+package kubecluster
+
+import (
+	"context"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// CachingProvider keeps one connection per context for the lifetime of the
+// process. Clients returned for the same context share the transport, with
+// its TLS sessions and credential plugins, and the rate limiter.
+type CachingProvider struct {
+	Provider *Provider
+
+	mutex    sync.Mutex
+	contexts map[string]*cachedContext
+}
+
+type cachedContext struct {
+	namespace  string
+	restConfig *rest.Config
+}
+
+var _ ClientProvider = &CachingProvider{}
+
+func (c *CachingProvider) New(ctx context.Context, contextName string) (Client, error) {
+	if contextName == DefaultContext {
+		contextName = c.Provider.Config.CurrentContext
+	}
+
+	cached, err := c.get(contextName)
+	if err != nil {
+		return nil, err
+	}
+
+	restConfig := *cached.restConfig
+	return newClient(ctx, contextName, cached.namespace, &restConfig)
+}
+
+func (c *CachingProvider) GetRestConfig(contextName string) (*rest.Config, error) {
+	return c.Provider.GetRestConfig(contextName)
+}
+
+func (c *CachingProvider) NewExecutor(ctx context.Context, contextName, namespace, podName string, options *v1.PodExecOptions) (remotecommand.Executor, error) {
+	return c.Provider.NewExecutor(ctx, contextName, namespace, podName, options)
+}
+
+func (c *CachingProvider) get(contextName string) (*cachedContext, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if cached, ok := c.contexts[contextName]; ok {
+		return cached, nil
+	}
+
+	restConfig, err := c.Provider.GetRestConfig(contextName)
+	if err != nil {
+		return nil, err
+	}
+
+	ns, err := c.Provider.Namespace(contextName)
+	if err != nil {
+		return nil, err
+	}
+
+	transport, err := rest.TransportFor(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	qps, burst := restConfig.QPS, restConfig.Burst
+	if qps == 0 {
+		qps = rest.DefaultQPS
+	}
+	if burst == 0 {
+		burst = rest.DefaultBurst
+	}
+
+	// The transport already carries the TLS settings and credentials, so
+	// only the request settings are kept.
+	cached := &cachedContext{
+		namespace: ns,
+		restConfig: &rest.Config{
+			Host:          restConfig.Host,
+			APIPath:       restConfig.APIPath,
+			ContentConfig: restConfig.ContentConfig,
+			UserAgent:     restConfig.UserAgent,
+			Timeout:       restConfig.Timeout,
+			QPS:           qps,
+			Burst:         burst,
+			RateLimiter:   flowcontrol.NewTokenBucketRateLimiter(qps, burst),
+			Transport:     transport,
+		},
+	}
+
+	if c.contexts == nil {
+		c.contexts = map[string]*cachedContext{}
+	}
+	c.contexts[contextName] = cached
+
+	return cached, nil
+}
@@ -0,0 +1,98 @@
+package kubecluster_test
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/evoila/kubernetes-cpi/config"
+	"github.com/evoila/kubernetes-cpi/kubecluster"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("CachingProvider", func() {
+	var server *ghttp.Server
+	var provider *kubecluster.CachingProvider
+
+	BeforeEach(func() {
+		server = ghttp.NewTLSServer()
+		kubeConf := config.Kubernetes{
+			Clusters: map[string]*config.Cluster{
+				"test_cluster": &config.Cluster{
+					InsecureSkipTLSVerify: true,
+					Server:                server.URL(),
+				},
+			},
+			AuthInfos: map[string]*config.AuthInfo{
+				"test_user": &config.AuthInfo{
+					Username: "user",
+					Password: "password",
+				},
+			},
+			Contexts: map[string]*config.Context{
+				"test_context": &config.Context{
+					Cluster:   "test_cluster",
+					AuthInfo:  "test_user",
+					Namespace: "test-context-namespace",
+				},
+			},
+			CurrentContext: "test_context",
+		}
+
+		provider = &kubecluster.CachingProvider{
+			Provider: &kubecluster.Provider{Config: kubeConf.ClientConfig()},
+		}
+
+		pod := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "podname", Namespace: "test-context-namespace"}}
+		for i := 0; i < 2; i++ {
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/v1/namespaces/test-context-namespace/pods/podname"),
+				ghttp.VerifyBasicAuth("user", "password"),
+				ghttp.RespondWithJSONEncoded(http.StatusOK, pod),
+			))
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("reuses the connection of a context across clients", func() {
+		for i := 0; i < 2; i++ {
+			client, err := provider.New(context.Background(), "")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(client.Context()).To(Equal("test_context"))
+			Expect(client.Namespace()).To(Equal("test-context-namespace"))
+
+			_, err = client.Pods().Get("podname", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+		}
+
+		Expect(server.ReceivedRequests()).To(HaveLen(2))
+		Expect(server.ReceivedRequests()[0].RemoteAddr).To(Equal(server.ReceivedRequests()[1].RemoteAddr))
+	})
+
+	It("binds each client to its own context", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancelledClient, err := provider.New(ctx, "test_context")
+		Expect(err).NotTo(HaveOccurred())
+		cancel()
+
+		client, err := provider.New(context.Background(), "test_context")
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = cancelledClient.Pods().Get("podname", metav1.GetOptions{})
+		Expect(err).To(MatchError(ContainSubstring("context canceled")))
+
+		_, err = client.Pods().Get("podname", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("returns an error for unknown contexts", func() {
+		_, err := provider.New(context.Background(), "does-not-exist")
+		Expect(err).To(HaveOccurred())
+	})
+})
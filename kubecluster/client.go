@@ -12,8 +12,10 @@ type Client interface {
 	Core() core.CoreV1Interface
 
 	ConfigMaps() core.ConfigMapInterface
+	Events() core.EventInterface
 	PersistentVolumeClaims() core.PersistentVolumeClaimInterface
 	Pods() core.PodInterface
+	Secrets() core.SecretInterface
 	Services() core.ServiceInterface
 }
 
@@ -38,6 +40,10 @@ func (c *client) ConfigMaps() core.ConfigMapInterface {
 	return c.Core().ConfigMaps(c.namespace)
 }
 
+func (c *client) Events() core.EventInterface {
+	return c.Core().Events(c.namespace)
+}
+
 func (c *client) PersistentVolumeClaims() core.PersistentVolumeClaimInterface {
 	return c.Core().PersistentVolumeClaims(c.namespace)
 }
@@ -46,6 +52,10 @@ func (c *client) Pods() core.PodInterface {
 	return c.Core().Pods(c.namespace)
 }
 
+func (c *client) Secrets() core.SecretInterface {
+	return c.Core().Secrets(c.namespace)
+}
+
 func (c *client) Services() core.ServiceInterface {
 	return c.Core().Services(c.namespace)
 }
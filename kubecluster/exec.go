@@ -0,0 +1,234 @@
+package kubecluster
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/third_party/forked/golang/netutil"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// NewExecutor returns an executor that runs a command in a pod of the
+// cluster of the named context. The connection uses the TLS settings and
+// credentials of the context and goes through its proxy. It is closed when
+// ctx is done, which ends a running stream.
+func (p *Provider) NewExecutor(ctx context.Context, contextName, namespace, podName string, options *v1.PodExecOptions) (remotecommand.Executor, error) {
+	if contextName == DefaultContext {
+		contextName = p.Config.CurrentContext
+	}
+
+	restConfig, err := p.GetRestConfig(contextName)
+	if err != nil {
+		return nil, err
+	}
+
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	req := clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(podName).SubResource("exec").
+		VersionedParams(options, scheme.ParameterCodec)
+	execURL := req.URL()
+
+	proxy := utilnet.NewProxierWithNoProxyCIDR(http.ProxyFromEnvironment)
+	if proxyURL := p.Options[contextName].ProxyURL; proxyURL != "" {
+		parsed, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(parsed)
+	}
+
+	tlsConfig, err := rest.TLSConfigFor(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	upgrader := &spdyUpgrader{ctx: ctx, proxy: proxy, tlsConfig: tlsConfig}
+	transport, err := rest.HTTPWrappersForConfig(restConfig, upgrader)
+	if err != nil {
+		return nil, err
+	}
+
+	return remotecommand.NewSPDYExecutorForTransports(transport, upgrader, http.MethodPost, execURL)
+}
+
+// spdyUpgrader upgrades a request to SPDY, directly or over a tunnel
+// through an HTTP proxy. The SPDY round tripper of client-go 7 only honours
+// the proxy environment variables and cannot be cancelled. Like that round
+// tripper, it is used for a single request.
+type spdyUpgrader struct {
+	ctx       context.Context
+	proxy     func(*http.Request) (*url.URL, error)
+	tlsConfig *tls.Config
+
+	conn net.Conn
+}
+
+func (p *spdyUpgrader) RoundTrip(req *http.Request) (*http.Response, error) {
+	clone := utilnet.CloneRequest(req)
+	clone.Header.Add(httpstream.HeaderConnection, httpstream.HeaderUpgrade)
+	clone.Header.Add(httpstream.HeaderUpgrade, spdy.HeaderSpdy31)
+
+	proxyURL, err := p.proxy(req)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := p.dial(proxyURL, req.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	err = clone.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), clone)
+	if err != nil {
+		conn.Close()
+		if p.ctx.Err() != nil {
+			return nil, p.ctx.Err()
+		}
+		return nil, err
+	}
+
+	p.conn = conn
+	return resp, nil
+}
+
+// NewConnection returns the SPDY connection of an upgraded response.
+func (p *spdyUpgrader) NewConnection(resp *http.Response) (httpstream.Connection, error) {
+	connection := strings.ToLower(resp.Header.Get(httpstream.HeaderConnection))
+	upgrade := strings.ToLower(resp.Header.Get(httpstream.HeaderUpgrade))
+	if resp.StatusCode != http.StatusSwitchingProtocols || !strings.Contains(connection, "upgrade") || upgrade != strings.ToLower(spdy.HeaderSpdy31) {
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("unable to upgrade connection: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return spdy.NewClientConnection(p.conn)
+}
+
+// dial connects to the host of target, through a tunnel when proxy is set,
+// and starts TLS on the connection for https URLs.
+func (p *spdyUpgrader) dial(proxy, target *url.URL) (net.Conn, error) {
+	targetAddr := netutil.CanonicalAddr(target)
+
+	address := targetAddr
+	if proxy != nil {
+		address = netutil.CanonicalAddr(proxy)
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(p.ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	conn = closeOnDone(p.ctx, conn)
+
+	if proxy == nil {
+		return p.startTLS(conn, target)
+	}
+
+	connect := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: targetAddr},
+		Host:   targetAddr,
+		Header: http.Header{},
+	}
+	if user := proxy.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		connect.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	err = connect.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), connect)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s refused to connect to %s: %s", proxy.Host, targetAddr, resp.Status)
+	}
+
+	return p.startTLS(conn, target)
+}
+
+// startTLS starts TLS on conn for https URLs.
+func (p *spdyUpgrader) startTLS(conn net.Conn, target *url.URL) (net.Conn, error) {
+	if target.Scheme != "https" {
+		return conn, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if p.tlsConfig != nil {
+		tlsConfig = p.tlsConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = target.Hostname()
+	}
+
+	tlsConn := tls.Client(conn, tlsConfig)
+	err := tlsConn.HandshakeContext(p.ctx)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tlsConn, nil
+}
+
+// ctxConn is a connection that is closed when its context is done.
+type ctxConn struct {
+	net.Conn
+
+	once sync.Once
+	stop chan struct{}
+}
+
+func closeOnDone(ctx context.Context, conn net.Conn) net.Conn {
+	c := &ctxConn{Conn: conn, stop: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Conn.Close()
+		case <-c.stop:
+		}
+	}()
+	return c
+}
+
+func (c *ctxConn) Close() error {
+	c.once.Do(func() { close(c.stop) })
+	return c.Conn.Close()
+}
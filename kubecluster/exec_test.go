@@ -0,0 +1,263 @@
+package kubecluster_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/evoila/kubernetes-cpi/config"
+	"github.com/evoila/kubernetes-cpi/kubecluster"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+var _ = Describe("Provider with a proxy", func() {
+	var (
+		proxy    *connectProxy
+		server   *httptest.Server
+		provider *kubecluster.Provider
+
+		mutex    sync.Mutex
+		requests []*http.Request
+		release  chan struct{}
+	)
+
+	BeforeEach(func() {
+		requests = nil
+		release = make(chan struct{})
+		server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mutex.Lock()
+			requests = append(requests, r)
+			mutex.Unlock()
+
+			switch r.URL.Path {
+			case "/api/v1/namespaces/bosh/pods/agent-1234/exec":
+				http.Error(w, "exec reached the server", http.StatusForbidden)
+				return
+			case "/api/v1/namespaces/bosh/pods/stuck-agent/exec":
+				select {
+				case <-release:
+				case <-r.Context().Done():
+				}
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent-1234", Namespace: "bosh"}})
+		}))
+		server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+		server.StartTLS()
+
+		proxy = startConnectProxy()
+
+		certPEM, keyPEM := clientCertificate("bosh-cpi")
+		provider = &kubecluster.Provider{
+			Config: clientcmdapi.Config{
+				Clusters: map[string]*clientcmdapi.Cluster{
+					"cluster": {
+						Server:                   server.URL,
+						CertificateAuthorityData: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}),
+					},
+				},
+				AuthInfos: map[string]*clientcmdapi.AuthInfo{
+					"cpi": {ClientCertificateData: certPEM, ClientKeyData: keyPEM},
+				},
+				Contexts: map[string]*clientcmdapi.Context{
+					"proxied": {Cluster: "cluster", AuthInfo: "cpi", Namespace: "bosh"},
+					"direct":  {Cluster: "cluster", AuthInfo: "cpi", Namespace: "bosh"},
+				},
+				CurrentContext: "proxied",
+			},
+			Options: map[string]config.ConnectionOptions{
+				"proxied": {ProxyURL: "http://" + proxy.Addr()},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		close(release)
+		proxy.Close()
+		server.Close()
+	})
+
+	It("keeps the TLS settings of the rest config", func() {
+		restConfig, err := provider.GetRestConfig("proxied")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restConfig.Transport).To(BeNil())
+		Expect(restConfig.TLSClientConfig.CAData).NotTo(BeEmpty())
+		Expect(restConfig.TLSClientConfig.CertData).NotTo(BeEmpty())
+		Expect(restConfig.TLSClientConfig.KeyData).NotTo(BeEmpty())
+	})
+
+	It("sends API requests through the proxy with the client certificate", func() {
+		client, err := provider.New(context.Background(), "proxied")
+		Expect(err).NotTo(HaveOccurred())
+
+		pod, err := client.Pods().Get("agent-1234", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(pod.Name).To(Equal("agent-1234"))
+
+		Expect(proxy.Targets()).To(ConsistOf(server.Listener.Addr().String()))
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].TLS.PeerCertificates[0].Subject.CommonName).To(Equal("bosh-cpi"))
+	})
+
+	It("execs in pods through the proxy with the client certificate", func() {
+		exec, err := provider.NewExecutor(context.Background(), "proxied", "bosh", "agent-1234", &v1.PodExecOptions{
+			Container: "bosh-job",
+			Command:   []string{"true"},
+			Stdout:    true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = exec.Stream(remotecommand.StreamOptions{Stdout: ioutil.Discard})
+		Expect(err).To(MatchError(ContainSubstring("exec reached the server")))
+
+		Expect(proxy.Targets()).To(ConsistOf(server.Listener.Addr().String()))
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Method).To(Equal("POST"))
+		Expect(requests[0].Header.Get("Upgrade")).To(Equal("SPDY/3.1"))
+		Expect(requests[0].URL.Query()["command"]).To(Equal([]string{"true"}))
+		Expect(requests[0].TLS.PeerCertificates[0].Subject.CommonName).To(Equal("bosh-cpi"))
+	})
+
+	It("execs in pods of contexts without a proxy", func() {
+		exec, err := provider.NewExecutor(context.Background(), "direct", "bosh", "agent-1234", &v1.PodExecOptions{
+			Container: "bosh-job",
+			Command:   []string{"true"},
+			Stdout:    true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = exec.Stream(remotecommand.StreamOptions{Stdout: ioutil.Discard})
+		Expect(err).To(MatchError(ContainSubstring("exec reached the server")))
+
+		Expect(proxy.Targets()).To(BeEmpty())
+		Expect(requests).To(HaveLen(1))
+		Expect(requests[0].Header.Get("Upgrade")).To(Equal("SPDY/3.1"))
+		Expect(requests[0].TLS.PeerCertificates[0].Subject.CommonName).To(Equal("bosh-cpi"))
+	})
+
+	It("stops the exec when the context is done", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		exec, err := provider.NewExecutor(ctx, "proxied", "bosh", "stuck-agent", &v1.PodExecOptions{
+			Container: "bosh-job",
+			Command:   []string{"true"},
+			Stdout:    true,
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		errs := make(chan error, 1)
+		go func() {
+			errs <- exec.Stream(remotecommand.StreamOptions{Stdout: ioutil.Discard})
+		}()
+
+		var streamErr error
+		Eventually(errs, 5*time.Second).Should(Receive(&streamErr))
+		Expect(streamErr).To(MatchError(ContainSubstring("context deadline exceeded")))
+	})
+})
+
+// connectProxy is an HTTP proxy that only tunnels CONNECT requests.
+type connectProxy struct {
+	listener net.Listener
+
+	mutex   sync.Mutex
+	targets []string
+}
+
+func startConnectProxy() *connectProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	Expect(err).NotTo(HaveOccurred())
+
+	proxy := &connectProxy{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go proxy.tunnel(conn)
+		}
+	}()
+	return proxy
+}
+
+func (p *connectProxy) Addr() string { return p.listener.Addr().String() }
+func (p *connectProxy) Close()       { p.listener.Close() }
+
+func (p *connectProxy) Targets() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.targets
+}
+
+func (p *connectProxy) tunnel(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	req, err := http.ReadRequest(reader)
+	if err != nil || req.Method != http.MethodConnect {
+		fmt.Fprint(conn, "HTTP/1.1 405 Method Not Allowed\r\n\r\n")
+		return
+	}
+
+	p.mutex.Lock()
+	p.targets = append(p.targets, req.Host)
+	p.mutex.Unlock()
+
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		fmt.Fprint(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		return
+	}
+	defer target.Close()
+
+	fmt.Fprint(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go io.Copy(target, reader)
+	io.Copy(conn, target)
+}
+
+// clientCertificate returns a self-signed client certificate and its key.
+func clientCertificate(commonName string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).NotTo(HaveOccurred())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	Expect(err).NotTo(HaveOccurred())
+
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
@@ -1,6 +1,10 @@
 package fakes
 
 import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
 	"github.com/evoila/kubernetes-cpi/kubecluster"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/fake"
@@ -15,10 +19,16 @@ type clientContext interface {
 }
 
 func NewClient(objects ...runtime.Object) *Client {
-	return &Client{
-		ClientContext: ClientContext{},
-		Clientset:     *fake.NewSimpleClientset(objects...),
-	}
+	client := &Client{ClientContext: ClientContext{}}
+	client.SetObjects(objects...)
+	return client
+}
+
+// SetObjects replaces the clientset of the client with one that holds
+// objects.
+func (c *Client) SetObjects(objects ...runtime.Object) {
+	c.Clientset = *fake.NewSimpleClientset(objects...)
+	c.PrependReactor("patch", "*", c.patch)
 }
 
 var _ kubecluster.Client = NewClient()
@@ -38,6 +48,10 @@ func (c *Client) Services() core.ServiceInterface {
 	return c.Core().Services(c.Namespace())
 }
 
+func (c *Client) Events() core.EventInterface {
+	return c.Core().Events(c.Namespace())
+}
+
 func (c *Client) PersistentVolumeClaims() core.PersistentVolumeClaimInterface {
 	return c.Core().PersistentVolumeClaims(c.Namespace())
 }
@@ -46,6 +60,10 @@ func (c *Client) Pods() core.PodInterface {
 	return c.Core().Pods(c.Namespace())
 }
 
+func (c *Client) Secrets() core.SecretInterface {
+	return c.Core().Secrets(c.Namespace())
+}
+
 func (c *Client) MatchingActions(verb, resource string) []testing.Action {
 	result := []testing.Action{}
 	for _, action := range c.Actions() {
@@ -55,3 +73,77 @@ func (c *Client) MatchingActions(verb, resource string) []testing.Action {
 	}
 	return result
 }
+
+// patch applies a JSON merge patch, the kind the CPI sends, to an object
+// of the clientset, which does not handle patches itself.
+func (c *Client) patch(action testing.Action) (bool, runtime.Object, error) {
+	patch := action.(testing.PatchAction)
+	resource, ns := action.GetResource(), action.GetNamespace()
+
+	obj, err := c.react(testing.NewGetAction(resource, ns, patch.GetName()))
+	if err != nil {
+		return true, nil, err
+	}
+
+	original, err := json.Marshal(obj)
+	if err != nil {
+		return true, nil, err
+	}
+
+	var doc, changes map[string]interface{}
+	if err := json.Unmarshal(original, &doc); err != nil {
+		return true, nil, err
+	}
+	if err := json.Unmarshal(patch.GetPatch(), &changes); err != nil {
+		return true, nil, err
+	}
+
+	patched, err := json.Marshal(mergePatch(doc, changes))
+	if err != nil {
+		return true, nil, err
+	}
+
+	result := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+	err = json.Unmarshal(patched, result)
+	if err != nil {
+		return true, nil, err
+	}
+
+	obj, err = c.react(testing.NewUpdateAction(resource, ns, result))
+	return true, obj, err
+}
+
+// mergePatch applies the changes of a JSON merge patch to doc as described
+// in RFC 7386.
+func mergePatch(doc, changes map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		doc = map[string]interface{}{}
+	}
+	for k, v := range changes {
+		switch v := v.(type) {
+		case nil:
+			delete(doc, k)
+		case map[string]interface{}:
+			nested, _ := doc[k].(map[string]interface{})
+			doc[k] = mergePatch(nested, v)
+		default:
+			doc[k] = v
+		}
+	}
+	return doc
+}
+
+// react runs action through the reactors of the clientset without
+// recording it. It is meant for reactors, which already hold the lock of
+// the clientset.
+func (c *Client) react(action testing.Action) (runtime.Object, error) {
+	for _, reactor := range c.ReactionChain {
+		if !reactor.Handles(action) {
+			continue
+		}
+		if handled, obj, err := reactor.React(action); handled {
+			return obj, err
+		}
+	}
+	return nil, fmt.Errorf("no reaction for %s %s", action.GetVerb(), action.GetResource().Resource)
+}
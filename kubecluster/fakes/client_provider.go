@@ -2,34 +2,62 @@
 package fakes
 
 import (
+	"context"
 	"sync"
 
 	"github.com/evoila/kubernetes-cpi/kubecluster"
+	"k8s.io/api/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
 )
 
 type ClientProvider struct {
-	NewStub        func(context string) (kubecluster.Client, error)
+	NewStub        func(ctx context.Context, contextName string) (kubecluster.Client, error)
 	newMutex       sync.RWMutex
 	newArgsForCall []struct {
-		context string
+		ctx         context.Context
+		contextName string
 	}
 	newReturns struct {
 		result1 kubecluster.Client
 		result2 error
 	}
+	GetRestConfigStub        func(contextName string) (*rest.Config, error)
+	getRestConfigMutex       sync.RWMutex
+	getRestConfigArgsForCall []struct {
+		contextName string
+	}
+	getRestConfigReturns struct {
+		result1 *rest.Config
+		result2 error
+	}
+	NewExecutorStub        func(ctx context.Context, contextName, namespace, podName string, options *v1.PodExecOptions) (remotecommand.Executor, error)
+	newExecutorMutex       sync.RWMutex
+	newExecutorArgsForCall []struct {
+		ctx         context.Context
+		contextName string
+		namespace   string
+		podName     string
+		options     *v1.PodExecOptions
+	}
+	newExecutorReturns struct {
+		result1 remotecommand.Executor
+		result2 error
+	}
 	invocations      map[string][][]interface{}
 	invocationsMutex sync.RWMutex
 }
 
-func (fake *ClientProvider) New(context string) (kubecluster.Client, error) {
+func (fake *ClientProvider) New(ctx context.Context, contextName string) (kubecluster.Client, error) {
 	fake.newMutex.Lock()
 	fake.newArgsForCall = append(fake.newArgsForCall, struct {
-		context string
-	}{context})
-	fake.recordInvocation("New", []interface{}{context})
+		ctx         context.Context
+		contextName string
+	}{ctx, contextName})
+	fake.recordInvocation("New", []interface{}{ctx, contextName})
 	fake.newMutex.Unlock()
 	if fake.NewStub != nil {
-		return fake.NewStub(context)
+		return fake.NewStub(ctx, contextName)
 	} else {
 		return fake.newReturns.result1, fake.newReturns.result2
 	}
@@ -41,10 +69,10 @@ func (fake *ClientProvider) NewCallCount() int {
 	return len(fake.newArgsForCall)
 }
 
-func (fake *ClientProvider) NewArgsForCall(i int) string {
+func (fake *ClientProvider) NewArgsForCall(i int) (context.Context, string) {
 	fake.newMutex.RLock()
 	defer fake.newMutex.RUnlock()
-	return fake.newArgsForCall[i].context
+	return fake.newArgsForCall[i].ctx, fake.newArgsForCall[i].contextName
 }
 
 func (fake *ClientProvider) NewReturns(result1 kubecluster.Client, result2 error) {
@@ -55,11 +83,87 @@ func (fake *ClientProvider) NewReturns(result1 kubecluster.Client, result2 error
 	}{result1, result2}
 }
 
+func (fake *ClientProvider) GetRestConfig(contextName string) (*rest.Config, error) {
+	fake.getRestConfigMutex.Lock()
+	fake.getRestConfigArgsForCall = append(fake.getRestConfigArgsForCall, struct {
+		contextName string
+	}{contextName})
+	fake.recordInvocation("GetRestConfig", []interface{}{contextName})
+	fake.getRestConfigMutex.Unlock()
+	if fake.GetRestConfigStub != nil {
+		return fake.GetRestConfigStub(contextName)
+	} else {
+		return fake.getRestConfigReturns.result1, fake.getRestConfigReturns.result2
+	}
+}
+
+func (fake *ClientProvider) GetRestConfigCallCount() int {
+	fake.getRestConfigMutex.RLock()
+	defer fake.getRestConfigMutex.RUnlock()
+	return len(fake.getRestConfigArgsForCall)
+}
+
+func (fake *ClientProvider) GetRestConfigArgsForCall(i int) string {
+	fake.getRestConfigMutex.RLock()
+	defer fake.getRestConfigMutex.RUnlock()
+	return fake.getRestConfigArgsForCall[i].contextName
+}
+
+func (fake *ClientProvider) GetRestConfigReturns(result1 *rest.Config, result2 error) {
+	fake.GetRestConfigStub = nil
+	fake.getRestConfigReturns = struct {
+		result1 *rest.Config
+		result2 error
+	}{result1, result2}
+}
+
+func (fake *ClientProvider) NewExecutor(ctx context.Context, contextName, namespace, podName string, options *v1.PodExecOptions) (remotecommand.Executor, error) {
+	fake.newExecutorMutex.Lock()
+	fake.newExecutorArgsForCall = append(fake.newExecutorArgsForCall, struct {
+		ctx         context.Context
+		contextName string
+		namespace   string
+		podName     string
+		options     *v1.PodExecOptions
+	}{ctx, contextName, namespace, podName, options})
+	fake.recordInvocation("NewExecutor", []interface{}{ctx, contextName, namespace, podName, options})
+	fake.newExecutorMutex.Unlock()
+	if fake.NewExecutorStub != nil {
+		return fake.NewExecutorStub(ctx, contextName, namespace, podName, options)
+	} else {
+		return fake.newExecutorReturns.result1, fake.newExecutorReturns.result2
+	}
+}
+
+func (fake *ClientProvider) NewExecutorCallCount() int {
+	fake.newExecutorMutex.RLock()
+	defer fake.newExecutorMutex.RUnlock()
+	return len(fake.newExecutorArgsForCall)
+}
+
+func (fake *ClientProvider) NewExecutorArgsForCall(i int) (context.Context, string, string, string, *v1.PodExecOptions) {
+	fake.newExecutorMutex.RLock()
+	defer fake.newExecutorMutex.RUnlock()
+	return fake.newExecutorArgsForCall[i].ctx, fake.newExecutorArgsForCall[i].contextName, fake.newExecutorArgsForCall[i].namespace, fake.newExecutorArgsForCall[i].podName, fake.newExecutorArgsForCall[i].options
+}
+
+func (fake *ClientProvider) NewExecutorReturns(result1 remotecommand.Executor, result2 error) {
+	fake.NewExecutorStub = nil
+	fake.newExecutorReturns = struct {
+		result1 remotecommand.Executor
+		result2 error
+	}{result1, result2}
+}
+
 func (fake *ClientProvider) Invocations() map[string][][]interface{} {
 	fake.invocationsMutex.RLock()
 	defer fake.invocationsMutex.RUnlock()
 	fake.newMutex.RLock()
 	defer fake.newMutex.RUnlock()
+	fake.getRestConfigMutex.RLock()
+	defer fake.getRestConfigMutex.RUnlock()
+	fake.newExecutorMutex.RLock()
+	defer fake.newExecutorMutex.RUnlock()
 	return fake.invocations
 }
 
@@ -0,0 +1,60 @@
+package kubecluster
+
+import (
+	core "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// InNamespace returns a client for a namespace of the cluster of c other
+// than the namespace of its context. An empty namespace returns c.
+func InNamespace(c Client, namespace string) Client {
+	if namespace == "" {
+		return c
+	}
+	if n, ok := c.(*namespacedClient); ok {
+		c = n.Client
+	}
+	return &namespacedClient{Client: c, namespace: namespace}
+}
+
+// ExplicitNamespace returns the namespace a client was moved to with
+// InNamespace. It is empty for clients that use the namespace of their
+// context.
+func ExplicitNamespace(c Client) string {
+	if n, ok := c.(*namespacedClient); ok {
+		return n.namespace
+	}
+	return ""
+}
+
+type namespacedClient struct {
+	Client
+	namespace string
+}
+
+func (c *namespacedClient) Namespace() string {
+	return c.namespace
+}
+
+func (c *namespacedClient) ConfigMaps() core.ConfigMapInterface {
+	return c.Core().ConfigMaps(c.namespace)
+}
+
+func (c *namespacedClient) Events() core.EventInterface {
+	return c.Core().Events(c.namespace)
+}
+
+func (c *namespacedClient) PersistentVolumeClaims() core.PersistentVolumeClaimInterface {
+	return c.Core().PersistentVolumeClaims(c.namespace)
+}
+
+func (c *namespacedClient) Pods() core.PodInterface {
+	return c.Core().Pods(c.namespace)
+}
+
+func (c *namespacedClient) Secrets() core.SecretInterface {
+	return c.Core().Secrets(c.namespace)
+}
+
+func (c *namespacedClient) Services() core.ServiceInterface {
+	return c.Core().Services(c.namespace)
+}
@@ -0,0 +1,45 @@
+package kubecluster_test
+
+import (
+	"github.com/evoila/kubernetes-cpi/kubecluster"
+	"github.com/evoila/kubernetes-cpi/kubecluster/fakes"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("InNamespace", func() {
+	var fakeClient *fakes.Client
+
+	BeforeEach(func() {
+		fakeClient = fakes.NewClient()
+		fakeClient.ContextReturns("bosh")
+		fakeClient.NamespaceReturns("bosh-namespace")
+	})
+
+	It("returns the client when no namespace is given", func() {
+		client := kubecluster.InNamespace(fakeClient, "")
+		Expect(client).To(BeIdenticalTo(fakeClient))
+		Expect(kubecluster.ExplicitNamespace(client)).To(BeEmpty())
+	})
+
+	It("works in the namespace", func() {
+		client := kubecluster.InNamespace(fakeClient, "deployment")
+		Expect(client.Context()).To(Equal("bosh"))
+		Expect(client.Namespace()).To(Equal("deployment"))
+		Expect(kubecluster.ExplicitNamespace(client)).To(Equal("deployment"))
+
+		_, err := client.Pods().Create(&v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "agent-id"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = fakeClient.Core().Pods("deployment").Get("agent-id", metav1.GetOptions{})
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	It("replaces the namespace of a namespaced client", func() {
+		client := kubecluster.InNamespace(kubecluster.InNamespace(fakeClient, "first"), "second")
+		Expect(client.Namespace()).To(Equal("second"))
+	})
+})
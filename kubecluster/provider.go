@@ -1,59 +1,105 @@
 package kubecluster
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/evoila/kubernetes-cpi/config"
+	"k8s.io/api/core/v1"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"k8s.io/client-go/tools/remotecommand"
+
+	// register the oidc, gcp, azure and openstack auth provider plugins
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
 )
 
 const DefaultContext = ""
 
 //go:generate counterfeiter -o fakes/client_provider.go --fake-name ClientProvider . ClientProvider
 type ClientProvider interface {
-	New(context string) (Client, error)
+	New(ctx context.Context, contextName string) (Client, error)
 	GetRestConfig(context string) (*rest.Config, error)
+	NewExecutor(ctx context.Context, contextName, namespace, podName string, options *v1.PodExecOptions) (remotecommand.Executor, error)
 }
 
 type Provider struct {
 	clientcmdapi.Config
+
+	// Options holds connection settings by context name.
+	Options map[string]config.ConnectionOptions
+
+	// WrapTransport, when set, wraps the transport of every client to
+	// observe the requests sent to the cluster of the named context.
+	WrapTransport func(contextName string, rt http.RoundTripper) http.RoundTripper
 }
 
-func (p *Provider) New(context string) (Client, error) {
-	if context == DefaultContext {
-		context = p.Config.CurrentContext
+// New returns a client for the named context. Requests made by the client
+// are bound to ctx and abort when it is cancelled.
+func (p *Provider) New(ctx context.Context, contextName string) (Client, error) {
+	if contextName == DefaultContext {
+		contextName = p.Config.CurrentContext
 	}
 
-	kubeClientConfig := clientcmd.NewNonInteractiveClientConfig(
-		p.Config,
-		context,
-		&clientcmd.ConfigOverrides{},
-		&clientcmd.ClientConfigLoadingRules{},
-	)
-
-	restConfig, err := p.GetRestConfig(context)
+	restConfig, err := p.GetRestConfig(contextName)
 	if err != nil {
 		return nil, err
 	}
 
-	kubeClient, err := kubernetes.NewForConfig(restConfig)
+	ns, err := p.Namespace(contextName)
 	if err != nil {
 		return nil, err
 	}
 
+	return newClient(ctx, contextName, ns, restConfig)
+}
+
+// Namespace returns the namespace of the named context.
+func (p *Provider) Namespace(contextName string) (string, error) {
+	if contextName == DefaultContext {
+		contextName = p.Config.CurrentContext
+	}
+
+	kubeClientConfig := clientcmd.NewNonInteractiveClientConfig(
+		p.Config,
+		contextName,
+		&clientcmd.ConfigOverrides{},
+		&clientcmd.ClientConfigLoadingRules{},
+	)
+
 	ns, _, err := kubeClientConfig.Namespace()
+	return ns, err
+}
+
+func newClient(ctx context.Context, contextName, ns string, restConfig *rest.Config) (Client, error) {
+	wrapTransport(restConfig, func(rt http.RoundTripper) http.RoundTripper {
+		return &contextRoundTripper{ctx: ctx, rt: rt}
+	})
+
+	kubeClient, err := kubernetes.NewForConfig(restConfig)
 	if err != nil {
 		return nil, err
 	}
 
 	return &client{
-		context:   context,
+		context:   contextName,
 		namespace: ns,
 		Clientset: kubeClient,
 	}, nil
 }
 
 func (p *Provider) GetRestConfig(context string) (*rest.Config, error) {
+	if context == DefaultContext {
+		context = p.Config.CurrentContext
+	}
+
 	kubeClientConfig := clientcmd.NewNonInteractiveClientConfig(
 		p.Config,
 		context,
@@ -61,5 +107,169 @@ func (p *Provider) GetRestConfig(context string) (*rest.Config, error) {
 		&clientcmd.ClientConfigLoadingRules{},
 	)
 
-	return kubeClientConfig.ClientConfig()
+	restConfig, err := kubeClientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if opts, ok := p.Options[context]; ok {
+		err = applyConnectionOptions(restConfig, opts)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// client-go reads a token file once; re-read it on change so tokens
+	// don't expire in the middle of long running operations.
+	if authInfo := p.authInfo(context); authInfo != nil && authInfo.TokenFile != "" && authInfo.Token == "" {
+		restConfig.BearerToken = ""
+		wrapTransport(restConfig, func(rt http.RoundTripper) http.RoundTripper {
+			return newTokenFileRoundTripper(authInfo.TokenFile, rt)
+		})
+	}
+
+	if p.WrapTransport != nil {
+		wrapTransport(restConfig, func(rt http.RoundTripper) http.RoundTripper {
+			return p.WrapTransport(context, rt)
+		})
+	}
+
+	return restConfig, nil
+}
+
+func (p *Provider) authInfo(context string) *clientcmdapi.AuthInfo {
+	kubeContext, ok := p.Config.Contexts[context]
+	if !ok {
+		return nil
+	}
+	return p.Config.AuthInfos[kubeContext.AuthInfo]
+}
+
+// applyConnectionOptions configures the rate limits, timeout, TLS server
+// name, impersonated identity and proxy of a rest.Config. The timeout applies
+// to every request except watches, which last as long as their caller waits.
+func applyConnectionOptions(restConfig *rest.Config, opts config.ConnectionOptions) error {
+	if opts.QPS != 0 {
+		restConfig.QPS = opts.QPS
+	}
+	if opts.Burst != 0 {
+		restConfig.Burst = opts.Burst
+	}
+	if opts.TLSServerName != "" {
+		restConfig.TLSClientConfig.ServerName = opts.TLSServerName
+	}
+	if opts.ImpersonateUser != "" {
+		restConfig.Impersonate = rest.ImpersonationConfig{
+			UserName: opts.ImpersonateUser,
+			Groups:   opts.ImpersonateGroups,
+		}
+	}
+
+	if opts.ProxyURL != "" {
+		proxyURL, err := url.Parse(opts.ProxyURL)
+		if err != nil {
+			return err
+		}
+
+		// client-go only honours the proxy environment variables, so the
+		// proxy is set on the transport client-go builds from the TLS
+		// settings. The settings stay in the rest.Config for the exec
+		// requests of NewExecutor.
+		wrapTransport(restConfig, func(rt http.RoundTripper) http.RoundTripper {
+			return withProxy(rt, proxyURL)
+		})
+	}
+
+	// rest.Config.Timeout becomes the timeout of the http.Client, which
+	// would also end the watches, so the deadline is set per request.
+	if opts.Timeout != 0 {
+		wrapTransport(restConfig, func(rt http.RoundTripper) http.RoundTripper {
+			return &timeoutRoundTripper{timeout: opts.Timeout, rt: rt}
+		})
+	}
+
+	return nil
+}
+
+// withProxy returns a copy of an *http.Transport that connects through
+// proxyURL. Other round trippers are returned as they are.
+func withProxy(rt http.RoundTripper, proxyURL *url.URL) http.RoundTripper {
+	transport, ok := rt.(*http.Transport)
+	if !ok {
+		return rt
+	}
+
+	return utilnet.SetTransportDefaults(&http.Transport{
+		Proxy:               http.ProxyURL(proxyURL),
+		TLSClientConfig:     transport.TLSClientConfig,
+		TLSHandshakeTimeout: transport.TLSHandshakeTimeout,
+		MaxIdleConnsPerHost: transport.MaxIdleConnsPerHost,
+		Dial:                transport.Dial,
+		DialContext:         transport.DialContext,
+	})
+}
+
+// wrapTransport adds a round tripper to the ones already configured.
+func wrapTransport(restConfig *rest.Config, wrapper func(http.RoundTripper) http.RoundTripper) {
+	existing := restConfig.WrapTransport
+	if existing == nil {
+		restConfig.WrapTransport = wrapper
+		return
+	}
+	restConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+		return wrapper(existing(rt))
+	}
+}
+
+// contextRoundTripper binds every request to a context. client-go 7 does
+// not take a context on its typed clients.
+type contextRoundTripper struct {
+	ctx context.Context
+	rt  http.RoundTripper
+}
+
+func (c *contextRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return c.rt.RoundTrip(req.WithContext(c.ctx))
+}
+
+// timeoutRoundTripper gives every request but watches a deadline. The
+// deadline covers reading the body of the response.
+type timeoutRoundTripper struct {
+	timeout time.Duration
+	rt      http.RoundTripper
+}
+
+func (t *timeoutRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isWatch(req) {
+		return t.rt.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.rt.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// isWatch reports whether a request watches resources.
+func isWatch(req *http.Request) bool {
+	watch := req.URL.Query().Get("watch")
+	return watch == "true" || watch == "1" || strings.Contains(req.URL.Path, "/watch/")
+}
+
+// cancelOnClose releases the context of a request when its response body is
+// closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
 }
@@ -1,7 +1,12 @@
 package kubecluster_test
 
 import (
+	"context"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/evoila/kubernetes-cpi/config"
 	"github.com/evoila/kubernetes-cpi/kubecluster"
@@ -10,6 +15,8 @@ import (
 	"github.com/onsi/gomega/ghttp"
 	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 var _ = Describe("Provider", func() {
@@ -72,7 +79,7 @@ var _ = Describe("Provider", func() {
 		})
 
 		It("creates a kubernetes client for the default context", func() {
-			client, err := provider.New("")
+			client, err := provider.New(context.Background(), "")
 			Expect(err).NotTo(HaveOccurred())
 
 			pod, err := client.Pods().Get("podname", metav1.GetOptions{})
@@ -97,7 +104,7 @@ var _ = Describe("Provider", func() {
 		})
 
 		It("creates a kubernetes client for the defautl context", func() {
-			client, err := provider.New("test_context")
+			client, err := provider.New(context.Background(), "test_context")
 			Expect(err).NotTo(HaveOccurred())
 
 			pod, err := client.Pods().Get("podname", metav1.GetOptions{})
@@ -122,7 +129,7 @@ var _ = Describe("Provider", func() {
 		})
 
 		It("creates a kubernetes client for the default context", func() {
-			client, err := provider.New("no_namespace")
+			client, err := provider.New(context.Background(), "no_namespace")
 			Expect(err).NotTo(HaveOccurred())
 
 			pod, err := client.Pods().Get("podname", metav1.GetOptions{})
@@ -134,9 +141,207 @@ var _ = Describe("Provider", func() {
 		})
 	})
 
+	Context("when the user reads its token from a file", func() {
+		var tokenFile string
+
+		BeforeEach(func() {
+			f, err := ioutil.TempFile("", "token")
+			Expect(err).NotTo(HaveOccurred())
+			tokenFile = f.Name()
+			f.Close()
+			Expect(ioutil.WriteFile(tokenFile, []byte("first-token\n"), 0600)).To(Succeed())
+
+			provider.Config.AuthInfos["token_user"] = &clientcmdapi.AuthInfo{TokenFile: tokenFile}
+			provider.Config.Contexts["token_context"] = &clientcmdapi.Context{
+				Cluster:   "test_cluster",
+				AuthInfo:  "token_user",
+				Namespace: "token-namespace",
+			}
+
+			pod := v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "podname", Namespace: "token-namespace"}}
+			server.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v1/namespaces/token-namespace/pods/podname"),
+					ghttp.VerifyHeaderKV("Authorization", "Bearer first-token"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, pod),
+				),
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v1/namespaces/token-namespace/pods/podname"),
+					ghttp.VerifyHeaderKV("Authorization", "Bearer second-token"),
+					ghttp.RespondWithJSONEncoded(http.StatusOK, pod),
+				),
+			)
+		})
+
+		AfterEach(func() {
+			os.Remove(tokenFile)
+		})
+
+		It("picks up a rotated token", func() {
+			client, err := provider.New(context.Background(), "token_context")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = client.Pods().Get("podname", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(ioutil.WriteFile(tokenFile, []byte("second-token\n"), 0600)).To(Succeed())
+			later := time.Now().Add(time.Minute)
+			Expect(os.Chtimes(tokenFile, later, later)).To(Succeed())
+
+			_, err = client.Pods().Get("podname", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(2))
+		})
+	})
+
+	Context("when connection options are configured for the context", func() {
+		BeforeEach(func() {
+			provider.Options = map[string]config.ConnectionOptions{
+				"test_context": {
+					QPS:           25,
+					Burst:         50,
+					Timeout:       time.Minute,
+					TLSServerName: "kubernetes.example.com",
+				},
+			}
+		})
+
+		It("applies them to the rest config", func() {
+			restConfig, err := provider.GetRestConfig("test_context")
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(restConfig.QPS).To(Equal(float32(25)))
+			Expect(restConfig.Burst).To(Equal(50))
+			Expect(restConfig.Timeout).To(BeZero())
+			Expect(restConfig.TLSClientConfig.ServerName).To(Equal("kubernetes.example.com"))
+		})
+
+		It("leaves other contexts alone", func() {
+			restConfig, err := provider.GetRestConfig("default")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(restConfig.QPS).To(BeZero())
+			Expect(restConfig.TLSClientConfig.ServerName).To(BeEmpty())
+		})
+
+		Context("when a timeout is configured", func() {
+			BeforeEach(func() {
+				provider.Options["test_context"] = config.ConnectionOptions{Timeout: 100 * time.Millisecond}
+			})
+
+			It("fails requests that take longer", func() {
+				server.AppendHandlers(func(w http.ResponseWriter, r *http.Request) {
+					time.Sleep(300 * time.Millisecond)
+				})
+
+				client, err := provider.New(context.Background(), "test_context")
+				Expect(err).NotTo(HaveOccurred())
+
+				_, err = client.Pods().Get("podname", metav1.GetOptions{})
+				Expect(err).To(HaveOccurred())
+			})
+
+			It("does not limit watches", func() {
+				server.AppendHandlers(ghttp.CombineHandlers(
+					ghttp.VerifyRequest("GET", "/api/v1/namespaces/test-context-namespace/pods", "watch=true"),
+					func(w http.ResponseWriter, r *http.Request) {
+						w.Header().Set("Content-Type", "application/json")
+						w.WriteHeader(http.StatusOK)
+						w.(http.Flusher).Flush()
+
+						time.Sleep(300 * time.Millisecond)
+						fmt.Fprint(w, `{"type":"MODIFIED","object":{"kind":"Pod","apiVersion":"v1","metadata":{"name":"podname"}}}`)
+					},
+				))
+
+				client, err := provider.New(context.Background(), "test_context")
+				Expect(err).NotTo(HaveOccurred())
+
+				podWatch, err := client.Pods().Watch(metav1.ListOptions{})
+				Expect(err).NotTo(HaveOccurred())
+				defer podWatch.Stop()
+
+				var event watch.Event
+				Eventually(podWatch.ResultChan()).Should(Receive(&event))
+				Expect(event.Type).To(Equal(watch.Modified))
+			})
+		})
+
+		Context("when a proxy is configured", func() {
+			BeforeEach(func() {
+				provider.Options["test_context"] = config.ConnectionOptions{ProxyURL: "http://proxy.example.com:3128"}
+			})
+
+			It("wraps the transport so requests go through the proxy", func() {
+				restConfig, err := provider.GetRestConfig("test_context")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(restConfig.Transport).To(BeNil())
+				Expect(restConfig.Insecure).To(BeTrue())
+
+				transport, ok := restConfig.WrapTransport(&http.Transport{}).(*http.Transport)
+				Expect(ok).To(BeTrue())
+
+				req, err := http.NewRequest("GET", server.URL(), nil)
+				Expect(err).NotTo(HaveOccurred())
+				proxyURL, err := transport.Proxy(req)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(proxyURL.String()).To(Equal("http://proxy.example.com:3128"))
+			})
+
+			It("rejects invalid proxy URLs", func() {
+				provider.Options["test_context"] = config.ConnectionOptions{ProxyURL: "://proxy"}
+				_, err := provider.GetRestConfig("test_context")
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Context("when the context impersonates a tenant", func() {
+		BeforeEach(func() {
+			provider.Options = map[string]config.ConnectionOptions{
+				"test_context": {
+					ImpersonateUser:   "system:serviceaccount:tenant-a:bosh",
+					ImpersonateGroups: []string{"tenant-a"},
+				},
+			}
+
+			server.AppendHandlers(ghttp.CombineHandlers(
+				ghttp.VerifyRequest("GET", "/api/v1/namespaces/test-context-namespace/pods/podname"),
+				ghttp.VerifyBasicAuth("user", "password"),
+				ghttp.VerifyHeaderKV("Impersonate-User", "system:serviceaccount:tenant-a:bosh"),
+				ghttp.VerifyHeaderKV("Impersonate-Group", "tenant-a"),
+				ghttp.RespondWithJSONEncoded(
+					http.StatusOK,
+					v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "podname", Namespace: "test-context-namespace"}},
+				),
+			))
+		})
+
+		It("sends the impersonation headers", func() {
+			client, err := provider.New(context.Background(), "test_context")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = client.Pods().Get("podname", metav1.GetOptions{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(server.ReceivedRequests()).To(HaveLen(1))
+		})
+	})
+
+	Context("when the request context is cancelled", func() {
+		It("aborts requests made by the client", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			client, err := provider.New(ctx, "test_context")
+			Expect(err).NotTo(HaveOccurred())
+
+			cancel()
+			_, err = client.Pods().Get("podname", metav1.GetOptions{})
+			Expect(err).To(MatchError(ContainSubstring("context canceled")))
+			Expect(server.ReceivedRequests()).To(HaveLen(0))
+		})
+	})
+
 	Context("when an invalid context name is specified", func() {
 		It("raises an error", func() {
-			_, err := provider.New("does-not-exist")
+			_, err := provider.New(context.Background(), "does-not-exist")
 			Expect(err).To(MatchError("invalid configuration: no configuration has been provided"))
 
 			Expect(server.ReceivedRequests()).To(HaveLen(0))
@@ -0,0 +1,66 @@
+package kubecluster
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+// tokenFileRoundTripper authenticates requests with a bearer token read from
+// a file. The file is read again whenever it changes so tokens that are
+// rotated on disk are picked up by long running operations.
+type tokenFileRoundTripper struct {
+	path string
+	rt   http.RoundTripper
+
+	mutex   sync.Mutex
+	token   string
+	modTime time.Time
+}
+
+func newTokenFileRoundTripper(path string, rt http.RoundTripper) *tokenFileRoundTripper {
+	return &tokenFileRoundTripper{path: path, rt: rt}
+}
+
+func (t *tokenFileRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if len(req.Header.Get("Authorization")) != 0 {
+		return t.rt.RoundTrip(req)
+	}
+
+	token, err := t.currentToken()
+	if err != nil {
+		return nil, err
+	}
+
+	req = utilnet.CloneRequest(req)
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.rt.RoundTrip(req)
+}
+
+func (t *tokenFileRoundTripper) currentToken() (string, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	info, err := os.Stat(t.path)
+	if err != nil {
+		return "", err
+	}
+
+	if t.token != "" && info.ModTime().Equal(t.modTime) {
+		return t.token, nil
+	}
+
+	data, err := ioutil.ReadFile(t.path)
+	if err != nil {
+		return "", err
+	}
+
+	t.token = strings.TrimSpace(string(data))
+	t.modTime = info.ModTime()
+	return t.token, nil
+}
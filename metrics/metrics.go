@@ -0,0 +1,296 @@
+// Package metrics reports the duration and outcome of CPI requests and of
+// the phases they wait for Kubernetes in to Prometheus.
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// Outcomes of requests and waits.
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+// Buckets of the duration histograms, from 100ms to about 14 minutes.
+var Buckets = prometheus.ExponentialBuckets(0.1, 2, 14)
+
+// Metrics holds the collectors of the CPI.
+type Metrics struct {
+	Requests *prometheus.HistogramVec
+	Waits    *prometheus.HistogramVec
+}
+
+func New() *Metrics {
+	return &Metrics{
+		Requests: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "bosh_cpi",
+			Name:      "request_duration_seconds",
+			Help:      "Duration of CPI requests by method, Kubernetes context, outcome and error type.",
+			Buckets:   Buckets,
+		}, []string{"method", "context", "outcome", "error_type"}),
+
+		Waits: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "bosh_cpi",
+			Name:      "wait_duration_seconds",
+			Help:      "Time spent waiting for Kubernetes by phase, Kubernetes context and outcome.",
+			Buckets:   Buckets,
+		}, []string{"phase", "context", "outcome"}),
+	}
+}
+
+// Register registers the collectors with r.
+func (m *Metrics) Register(r prometheus.Registerer) error {
+	for _, c := range []prometheus.Collector{m.Requests, m.Waits} {
+		if err := r.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Middleware observes every request and the waits of its actions.
+func (m *Metrics) Middleware(clk clock.Clock) actions.Middleware {
+	return func(next actions.Handler) actions.Handler {
+		return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			ctx, info := actions.WithRequestInfo(ctx)
+			ctx = actions.WithWaitObserver(ctx, m.ObserveWait)
+
+			start := clk.Now()
+			resp, err := next(ctx, req)
+
+			outcome, errorType := OutcomeSuccess, ""
+			if err != nil {
+				resp := cpi.NewErrorResponse(err)
+				outcome, errorType = OutcomeError, resp.Error.Type
+			} else if resp != nil && resp.Error != nil {
+				outcome, errorType = OutcomeError, resp.Error.Type
+			}
+
+			m.Requests.WithLabelValues(req.Method, info.ContextName(), outcome, errorType).Observe(clk.Since(start).Seconds())
+			return resp, err
+		}
+	}
+}
+
+// ObserveWait records a wait phase. It is an actions.WaitObserver.
+func (m *Metrics) ObserveWait(phase, contextName string, duration time.Duration, err error) {
+	outcome := OutcomeSuccess
+	if err != nil {
+		outcome = OutcomeError
+	}
+	m.Waits.WithLabelValues(phase, contextName, outcome).Observe(duration.Seconds())
+}
+
+// WriteTextfile adds the metrics of g to the ones in the textfile at path,
+// which is in the text format read by the textfile collector of the node
+// exporter. Each CPI call is a process of its own, so counters and
+// histograms are summed with the ones earlier calls wrote. Writers take a
+// lock on path.lock, and the file is replaced atomically so the collector
+// never reads a partial file.
+func WriteTextfile(path string, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return err
+	}
+
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		return err
+	}
+
+	previous, err := readTextfile(path)
+	if err != nil {
+		return err
+	}
+	families = mergeFamilies(families, previous)
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	for _, family := range families {
+		_, err = expfmt.MetricFamilyToText(tmp, family)
+		if err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// readTextfile returns the metric families of the textfile at path. A
+// missing file has none, and a file that does not parse is replaced.
+func readTextfile(path string) (map[string]*dto.MetricFamily, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(f)
+	if err != nil {
+		return nil, nil
+	}
+	return families, nil
+}
+
+// Push replaces the metrics of the group of the job and instance on a
+// Pushgateway at url with the ones of g. Each CPI call pushes a group of
+// its own, so concurrent calls do not overwrite each other's observations;
+// queries sum over the instance label. The Pushgateway keeps the groups
+// until they are deleted.
+func Push(url, job, instance string, g prometheus.Gatherer) error {
+	err := push.New(url, job).Grouping("instance", instance).Gatherer(g).Push()
+	if err != nil {
+		return fmt.Errorf("Pushing metrics to %s: %s", url, err)
+	}
+	return nil
+}
+
+// mergeFamilies adds the counters and histograms of previous to the ones of
+// families with the same name and labels. Other metrics of families replace
+// the previous ones. Metrics and families only previous has are kept. The
+// result is sorted by name.
+func mergeFamilies(families []*dto.MetricFamily, previous map[string]*dto.MetricFamily) []*dto.MetricFamily {
+	byName := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		byName[family.GetName()] = family
+
+		old, ok := previous[family.GetName()]
+		if !ok || old.GetType() != family.GetType() {
+			continue
+		}
+
+		oldMetrics := map[string]*dto.Metric{}
+		for _, metric := range old.Metric {
+			oldMetrics[labelsKey(metric.Label)] = metric
+		}
+		for _, metric := range family.Metric {
+			key := labelsKey(metric.Label)
+			if oldMetric, ok := oldMetrics[key]; ok {
+				addMetric(family.GetType(), metric, oldMetric)
+				delete(oldMetrics, key)
+			}
+		}
+		for _, metric := range old.Metric {
+			if _, ok := oldMetrics[labelsKey(metric.Label)]; ok {
+				family.Metric = append(family.Metric, metric)
+			}
+		}
+		sort.Slice(family.Metric, func(i, j int) bool {
+			return labelsKey(family.Metric[i].Label) < labelsKey(family.Metric[j].Label)
+		})
+	}
+
+	for name, family := range previous {
+		if _, ok := byName[name]; !ok && len(family.Metric) != 0 {
+			byName[name] = family
+		}
+	}
+
+	var names []string
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	merged := make([]*dto.MetricFamily, 0, len(names))
+	for _, name := range names {
+		merged = append(merged, byName[name])
+	}
+	return merged
+}
+
+// addMetric adds the counter or histogram of old to metric. Histograms
+// whose buckets changed are not added up; metric replaces old.
+func addMetric(metricType dto.MetricType, metric, old *dto.Metric) {
+	switch metricType {
+	case dto.MetricType_COUNTER:
+		value := metric.GetCounter().GetValue() + old.GetCounter().GetValue()
+		metric.Counter.Value = &value
+
+	case dto.MetricType_HISTOGRAM:
+		histogram, oldHistogram := metric.GetHistogram(), old.GetHistogram()
+		buckets, oldBuckets := finiteBuckets(histogram.Bucket), finiteBuckets(oldHistogram.GetBucket())
+		if len(buckets) != len(oldBuckets) {
+			return
+		}
+		for i := range buckets {
+			if buckets[i].GetUpperBound() != oldBuckets[i].GetUpperBound() {
+				return
+			}
+		}
+
+		for i := range buckets {
+			count := buckets[i].GetCumulativeCount() + oldBuckets[i].GetCumulativeCount()
+			buckets[i].CumulativeCount = &count
+		}
+		sampleCount := histogram.GetSampleCount() + oldHistogram.GetSampleCount()
+		sampleSum := histogram.GetSampleSum() + oldHistogram.GetSampleSum()
+		histogram.Bucket = buckets
+		histogram.SampleCount = &sampleCount
+		histogram.SampleSum = &sampleSum
+	}
+}
+
+// finiteBuckets drops the +Inf bucket the text format has. Its count is the
+// sample count.
+func finiteBuckets(buckets []*dto.Bucket) []*dto.Bucket {
+	var finite []*dto.Bucket
+	for _, bucket := range buckets {
+		if !math.IsInf(bucket.GetUpperBound(), 1) {
+			finite = append(finite, bucket)
+		}
+	}
+	return finite
+}
+
+func labelsKey(labels []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, label := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", label.GetName(), label.GetValue()))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
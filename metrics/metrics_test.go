@@ -0,0 +1,193 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/metrics"
+	"github.com/onsi/gomega/ghttp"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		m         *metrics.Metrics
+		registry  *prometheus.Registry
+		fakeClock *fakeclock.FakeClock
+		tempDir   string
+	)
+
+	BeforeEach(func() {
+		m = metrics.New()
+		registry = prometheus.NewRegistry()
+		Expect(m.Register(registry)).To(Succeed())
+		fakeClock = fakeclock.NewFakeClock(time.Now())
+
+		var err error
+		tempDir, err = ioutil.TempDir("", "metrics")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(tempDir)
+	})
+
+	textfile := func() string {
+		path := filepath.Join(tempDir, "cpi.prom")
+		Expect(metrics.WriteTextfile(path, registry)).To(Succeed())
+
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		return string(contents)
+	}
+
+	It("observes the duration and outcome of requests", func() {
+		handler := m.Middleware(fakeClock)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			fakeClock.Increment(2 * time.Second)
+			return &cpi.Response{Result: true}, nil
+		})
+
+		_, err := handler(context.Background(), &cpi.Request{Method: "has_vm"})
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(textfile()).To(ContainSubstring(`bosh_cpi_request_duration_seconds_sum{context="",error_type="",method="has_vm",outcome="success"} 2`))
+	})
+
+	It("labels failed requests with the error type", func() {
+		handler := m.Middleware(fakeClock)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			return cpi.NewErrorResponse(&cpi.NotSupportedError{}), nil
+		})
+		handler(context.Background(), &cpi.Request{Method: "reboot_vm"})
+
+		handler = m.Middleware(fakeClock)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			return nil, errors.New("welp")
+		})
+		handler(context.Background(), &cpi.Request{Method: "delete_vm"})
+
+		contents := textfile()
+		Expect(contents).To(ContainSubstring(`bosh_cpi_request_duration_seconds_count{context="",error_type="Bosh::Clouds::NotSupported",method="reboot_vm",outcome="error"} 1`))
+		Expect(contents).To(ContainSubstring(`bosh_cpi_request_duration_seconds_count{context="",error_type="Bosh::Clouds::CloudError",method="delete_vm",outcome="error"} 1`))
+	})
+
+	It("observes wait phases", func() {
+		m.ObserveWait(actions.PhasePodReady, "bosh", 30*time.Second, nil)
+		m.ObserveWait(actions.PhaseClaimBound, "bosh", time.Minute, errors.New("timeout"))
+
+		contents := textfile()
+		Expect(contents).To(ContainSubstring(`bosh_cpi_wait_duration_seconds_sum{context="bosh",outcome="success",phase="pod_ready"} 30`))
+		Expect(contents).To(ContainSubstring(`bosh_cpi_wait_duration_seconds_sum{context="bosh",outcome="error",phase="claim_bound"} 60`))
+	})
+
+	It("adds up the metrics of processes writing the same textfile", func() {
+		path := filepath.Join(tempDir, "cpi.prom")
+
+		for i := 0; i < 2; i++ {
+			processMetrics := metrics.New()
+			processRegistry := prometheus.NewRegistry()
+			Expect(processMetrics.Register(processRegistry)).To(Succeed())
+
+			handler := processMetrics.Middleware(fakeClock)(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				fakeClock.Increment(2 * time.Second)
+				return &cpi.Response{Result: true}, nil
+			})
+			_, err := handler(context.Background(), &cpi.Request{Method: "has_vm"})
+			Expect(err).NotTo(HaveOccurred())
+			if i == 0 {
+				processMetrics.ObserveWait(actions.PhasePodReady, "bosh", 30*time.Second, nil)
+			}
+
+			Expect(metrics.WriteTextfile(path, processRegistry)).To(Succeed())
+		}
+
+		contents, err := ioutil.ReadFile(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(contents)).To(ContainSubstring(`bosh_cpi_request_duration_seconds_bucket{context="",error_type="",method="has_vm",outcome="success",le="3.2"} 2`))
+		Expect(string(contents)).To(ContainSubstring(`bosh_cpi_request_duration_seconds_bucket{context="",error_type="",method="has_vm",outcome="success",le="+Inf"} 2`))
+		Expect(string(contents)).To(ContainSubstring(`bosh_cpi_request_duration_seconds_sum{context="",error_type="",method="has_vm",outcome="success"} 4`))
+		Expect(string(contents)).To(ContainSubstring(`bosh_cpi_request_duration_seconds_count{context="",error_type="",method="has_vm",outcome="success"} 2`))
+		Expect(string(contents)).To(ContainSubstring(`bosh_cpi_wait_duration_seconds_count{context="bosh",outcome="success",phase="pod_ready"} 1`))
+	})
+
+	It("replaces a textfile that does not parse", func() {
+		path := filepath.Join(tempDir, "cpi.prom")
+		Expect(ioutil.WriteFile(path, []byte("stale"), 0644)).To(Succeed())
+
+		m.ObserveWait(actions.PhasePodReady, "bosh", time.Second, nil)
+		Expect(textfile()).NotTo(ContainSubstring("stale"))
+
+		files, err := ioutil.ReadDir(tempDir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(files).To(HaveLen(2))
+		Expect(files[0].Name()).To(Equal("cpi.prom"))
+		Expect(files[1].Name()).To(Equal("cpi.prom.lock"))
+	})
+
+	Describe("Push", func() {
+		var (
+			gateway *ghttp.Server
+			pushed  map[string]*dto.MetricFamily
+		)
+
+		BeforeEach(func() {
+			pushed = map[string]*dto.MetricFamily{}
+			gateway = ghttp.NewServer()
+		})
+
+		AfterEach(func() {
+			gateway.Close()
+		})
+
+		It("replaces the group of the instance with the metrics", func() {
+			gateway.AppendHandlers(
+				ghttp.CombineHandlers(
+					ghttp.VerifyRequest("PUT", "/metrics/job/bosh_cpi/instance/cpi-12345"),
+					func(w http.ResponseWriter, req *http.Request) {
+						decoder := expfmt.NewDecoder(req.Body, expfmt.FmtProtoDelim)
+						for {
+							family := &dto.MetricFamily{}
+							err := decoder.Decode(family)
+							if err == io.EOF {
+								break
+							}
+							Expect(err).NotTo(HaveOccurred())
+							pushed[family.GetName()] = family
+						}
+						w.WriteHeader(http.StatusAccepted)
+					},
+				),
+			)
+
+			m.ObserveWait(actions.PhasePodReady, "bosh", 10*time.Second, nil)
+			Expect(metrics.Push(gateway.URL(), "bosh_cpi", "cpi-12345", registry)).To(Succeed())
+			Expect(gateway.ReceivedRequests()).To(HaveLen(1))
+
+			waits := pushed["bosh_cpi_wait_duration_seconds"]
+			Expect(waits).NotTo(BeNil())
+			Expect(waits.Metric).To(HaveLen(1))
+			Expect(waits.Metric[0].GetHistogram().GetSampleCount()).To(Equal(uint64(1)))
+			Expect(waits.Metric[0].GetHistogram().GetSampleSum()).To(Equal(float64(10)))
+		})
+
+		It("returns an error when the push fails", func() {
+			gateway.AppendHandlers(ghttp.RespondWith(http.StatusInternalServerError, ""))
+
+			m.ObserveWait(actions.PhasePodReady, "bosh", time.Second, nil)
+			err := metrics.Push(gateway.URL(), "bosh_cpi", "cpi-12345", registry)
+			Expect(err).To(MatchError(ContainSubstring("Pushing metrics to " + gateway.URL())))
+		})
+	})
+})
@@ -0,0 +1,210 @@
+// Package recorder keeps the CPI requests of a session, their responses and
+// the Kubernetes API interactions they caused, and replays them against a
+// fake cluster.
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"code.cloudfoundry.org/clock"
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/cpi"
+)
+
+// Call is a recorded CPI request.
+type Call struct {
+	Time         time.Time     `json:"time"`
+	Request      *cpi.Request  `json:"request"`
+	Response     *cpi.Response `json:"response,omitempty"`
+	Error        string        `json:"error,omitempty"`
+	Interactions []Interaction `json:"interactions"`
+
+	mutex sync.Mutex
+}
+
+// Interaction is a request sent to the Kubernetes API and its response.
+// Watch responses are streamed, so only their status is kept.
+type Interaction struct {
+	Context      string `json:"context"`
+	Method       string `json:"method"`
+	URL          string `json:"url"`
+	RequestBody  string `json:"request_body,omitempty"`
+	StatusCode   int    `json:"status_code,omitempty"`
+	ResponseBody string `json:"response_body,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+func (c *Call) add(interaction Interaction) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.Interactions = append(c.Interactions, interaction)
+}
+
+// Recorder writes every call to a file in Dir. The files hold the request
+// arguments and the objects read from the cluster, including the agent
+// settings and their credentials.
+type Recorder struct {
+	Dir   string
+	Clock clock.Clock
+
+	// ErrorLog receives errors writing the recording. It defaults to
+	// os.Stderr.
+	ErrorLog io.Writer
+}
+
+type callKey struct{}
+
+// Middleware records the request and response of every call. It should be
+// the outermost middleware so it sees the request as it was received.
+func (r *Recorder) Middleware() actions.Middleware {
+	return func(next actions.Handler) actions.Handler {
+		return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			call := &Call{Time: r.Clock.Now(), Request: copyRequest(req), Interactions: []Interaction{}}
+
+			resp, err := next(context.WithValue(ctx, callKey{}, call), req)
+
+			call.mutex.Lock()
+			call.Response = resp
+			if err != nil {
+				call.Error = err.Error()
+			}
+			call.mutex.Unlock()
+
+			// The recording is a debugging aid; failing to write it must
+			// not change the result of the call.
+			if writeErr := r.write(call); writeErr != nil {
+				errorLog := r.ErrorLog
+				if errorLog == nil {
+					errorLog = os.Stderr
+				}
+				fmt.Fprintf(errorLog, "Recording %s: %s\n", req.Method, writeErr)
+			}
+			return resp, err
+		}
+	}
+}
+
+// WrapTransport records the Kubernetes API requests made for a call. It is
+// meant for kubecluster.Provider.WrapTransport.
+func (r *Recorder) WrapTransport(contextName string, rt http.RoundTripper) http.RoundTripper {
+	return &recordingRoundTripper{contextName: contextName, rt: rt}
+}
+
+func (r *Recorder) write(call *Call) error {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+
+	payload, err := json.MarshalIndent(call, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(r.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.json", call.Time.UTC().Format("20060102T150405.000000000"), call.Request.Method)
+	return ioutil.WriteFile(filepath.Join(r.Dir, name), payload, 0600)
+}
+
+// Load reads the calls recorded in dir in the order they were made.
+func Load(dir string) ([]*Call, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	var calls []*Call
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var call Call
+		err = json.Unmarshal(data, &call)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		calls = append(calls, &call)
+	}
+
+	return calls, nil
+}
+
+// copyRequest returns a deep copy of the request. Handlers may replace
+// arguments, for example to resolve cloud property profiles.
+func copyRequest(req *cpi.Request) *cpi.Request {
+	var cp cpi.Request
+	if err := cpi.Remarshal(req, &cp); err != nil {
+		return req
+	}
+	return &cp
+}
+
+type recordingRoundTripper struct {
+	contextName string
+	rt          http.RoundTripper
+}
+
+func (r *recordingRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	call, ok := req.Context().Value(callKey{}).(*Call)
+	if !ok {
+		return r.rt.RoundTrip(req)
+	}
+
+	interaction := Interaction{
+		Context: r.contextName,
+		Method:  req.Method,
+		URL:     req.URL.String(),
+	}
+
+	if req.Body != nil {
+		body, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		interaction.RequestBody = string(body)
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	resp, err := r.rt.RoundTrip(req)
+	if err != nil {
+		interaction.Error = err.Error()
+		call.add(interaction)
+		return resp, err
+	}
+
+	interaction.StatusCode = resp.StatusCode
+	if !isWatch(req) {
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		interaction.ResponseBody = string(body)
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	call.add(interaction)
+	return resp, nil
+}
+
+func isWatch(req *http.Request) bool {
+	watch := req.URL.Query().Get("watch")
+	return watch == "true" || watch == "1"
+}
@@ -0,0 +1,168 @@
+package recorder_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"code.cloudfoundry.org/clock/fakeclock"
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/config"
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/kubecluster"
+	"github.com/evoila/kubernetes-cpi/recorder"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("Recorder", func() {
+	var (
+		tempDir   string
+		server    *ghttp.Server
+		provider  *kubecluster.Provider
+		rec       *recorder.Recorder
+		registry  *actions.Registry
+		fakeClock *fakeclock.FakeClock
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "recorder")
+		Expect(err).NotTo(HaveOccurred())
+
+		server = ghttp.NewTLSServer()
+		kubeConf := config.Kubernetes{
+			Clusters: map[string]*config.Cluster{
+				"cluster": &config.Cluster{InsecureSkipTLSVerify: true, Server: server.URL()},
+			},
+			AuthInfos: map[string]*config.AuthInfo{
+				"user": &config.AuthInfo{Username: "user", Password: "password"},
+			},
+			Contexts: map[string]*config.Context{
+				"bosh": &config.Context{Cluster: "cluster", AuthInfo: "user", Namespace: "bosh-namespace"},
+			},
+			CurrentContext: "bosh",
+		}
+
+		fakeClock = fakeclock.NewFakeClock(time.Date(2018, 5, 1, 12, 0, 0, 0, time.UTC))
+		rec = &recorder.Recorder{Dir: filepath.Join(tempDir, "session"), Clock: fakeClock}
+		provider = &kubecluster.Provider{Config: kubeConf.ClientConfig(), WrapTransport: rec.WrapTransport}
+
+		registry = actions.NewRegistry()
+		registry.Register("has_vm", (&actions.VMFinder{ClientProvider: provider}).HasVM)
+		registry.Use(rec.Middleware())
+
+		server.AppendHandlers(ghttp.CombineHandlers(
+			ghttp.VerifyRequest("GET", "/api/v1/namespaces/bosh-namespace/pods"),
+			ghttp.RespondWithJSONEncoded(http.StatusOK, v1.PodList{
+				TypeMeta: metav1.TypeMeta{Kind: "PodList", APIVersion: "v1"},
+				Items: []v1.Pod{{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "agent-agent-id",
+						Namespace: "bosh-namespace",
+						Labels:    map[string]string{"bosh.cloudfoundry.org/agent-id": "agent-id"},
+					},
+				}},
+			}),
+		))
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(tempDir)
+	})
+
+	It("writes the request, response and API interactions of a call", func() {
+		req := &cpi.Request{Method: "has_vm", Args: []interface{}{"bosh:agent-id"}}
+		resp, err := registry.Handle(context.Background(), req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Result).To(BeTrue())
+
+		data, err := ioutil.ReadFile(filepath.Join(tempDir, "session", "20180501T120000.000000000-has_vm.json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		var call recorder.Call
+		Expect(json.Unmarshal(data, &call)).To(Succeed())
+		Expect(call.Request.Method).To(Equal("has_vm"))
+		Expect(call.Response.Result).To(BeTrue())
+		Expect(call.Interactions).To(HaveLen(1))
+		Expect(call.Interactions[0].Context).To(Equal("bosh"))
+		Expect(call.Interactions[0].Method).To(Equal("GET"))
+		Expect(call.Interactions[0].StatusCode).To(Equal(http.StatusOK))
+		Expect(call.Interactions[0].ResponseBody).To(ContainSubstring("agent-agent-id"))
+	})
+
+	It("returns the result of the call when the recording cannot be written", func() {
+		Expect(ioutil.WriteFile(filepath.Join(tempDir, "session"), []byte("not a directory"), 0600)).To(Succeed())
+		errorLog := &bytes.Buffer{}
+		rec.ErrorLog = errorLog
+
+		req := &cpi.Request{Method: "has_vm", Args: []interface{}{"bosh:agent-id"}}
+		resp, err := registry.Handle(context.Background(), req)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Result).To(BeTrue())
+		Expect(errorLog.String()).To(ContainSubstring("Recording has_vm: "))
+	})
+
+	It("replays a recorded session against a fake cluster", func() {
+		_, err := registry.Handle(context.Background(), &cpi.Request{Method: "has_vm", Args: []interface{}{"bosh:agent-id"}})
+		Expect(err).NotTo(HaveOccurred())
+
+		calls, err := recorder.Load(rec.Dir)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(calls).To(HaveLen(1))
+
+		fakeProvider, err := recorder.NewFakeProvider(calls)
+		Expect(err).NotTo(HaveOccurred())
+
+		replayRegistry := actions.NewDefaultRegistry(&actions.Dependencies{
+			AgentConfig:    &config.Agent{},
+			ClientProvider: fakeProvider,
+		})
+
+		results := recorder.Replay(context.Background(), calls, replayRegistry.Handle)
+		Expect(results).To(HaveLen(1))
+		Expect(results[0].Match).To(BeTrue())
+		Expect(results[0].Replayed.Result).To(BeTrue())
+	})
+
+	It("reports responses that differ from the recording", func() {
+		calls := []*recorder.Call{{
+			Request:  &cpi.Request{Method: "has_vm", Args: []interface{}{"bosh:missing"}},
+			Response: &cpi.Response{Result: true},
+		}}
+
+		fakeProvider, err := recorder.NewFakeProvider(calls)
+		Expect(err).NotTo(HaveOccurred())
+		replayRegistry := actions.NewDefaultRegistry(&actions.Dependencies{
+			AgentConfig:    &config.Agent{},
+			ClientProvider: fakeProvider,
+		})
+
+		results := recorder.Replay(context.Background(), calls, replayRegistry.Handle)
+		Expect(results[0].Match).To(BeFalse())
+		Expect(results[0].Replayed.Result).To(BeFalse())
+	})
+
+	Describe("GUIDs", func() {
+		It("hands out the recorded disk IDs", func() {
+			generate := recorder.GUIDs([]*recorder.Call{{
+				Request:  &cpi.Request{Method: "create_disk"},
+				Response: &cpi.Response{Result: "bosh:disk-id"},
+			}})
+
+			id, err := generate()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(id).To(Equal("disk-id"))
+		})
+	})
+})
@@ -0,0 +1,297 @@
+package recorder
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/kubecluster"
+	"github.com/evoila/kubernetes-cpi/kubecluster/fakes"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/testing"
+)
+
+// Result compares the response of a replayed call with the recording.
+type Result struct {
+	Method        string        `json:"method"`
+	Match         bool          `json:"match"`
+	Recorded      *cpi.Response `json:"recorded,omitempty"`
+	RecordedError string        `json:"recorded_error,omitempty"`
+	Replayed      *cpi.Response `json:"replayed,omitempty"`
+	ReplayedError string        `json:"replayed_error,omitempty"`
+}
+
+// Replay sends the recorded requests to handler in order.
+func Replay(ctx context.Context, calls []*Call, handler actions.Handler) []Result {
+	var results []Result
+	for _, call := range calls {
+		resp, err := handler(ctx, copyRequest(call.Request))
+
+		result := Result{
+			Method:        call.Request.Method,
+			Recorded:      call.Response,
+			RecordedError: call.Error,
+			Replayed:      resp,
+		}
+		if err != nil {
+			result.ReplayedError = err.Error()
+		}
+		result.Match = result.RecordedError == result.ReplayedError && sameJSON(result.Recorded, result.Replayed)
+
+		results = append(results, result)
+	}
+	return results
+}
+
+func sameJSON(a, b interface{}) bool {
+	var av, bv interface{}
+	if err := cpi.Remarshal(a, &av); err != nil {
+		return false
+	}
+	if err := cpi.Remarshal(b, &bv); err != nil {
+		return false
+	}
+	return reflect.DeepEqual(av, bv)
+}
+
+// GUIDs returns a generator that hands out the disk IDs of the recorded
+// create_disk calls so replayed disk CIDs match the recording.
+func GUIDs(calls []*Call) func() (string, error) {
+	var ids []string
+	for _, call := range calls {
+		if call.Request.Method != "create_disk" || call.Response == nil {
+			continue
+		}
+		if cid, ok := call.Response.Result.(string); ok {
+			disk, err := cpi.DiskCID(cid).Parse()
+			if err != nil {
+				continue
+			}
+			ids = append(ids, disk.ID)
+		}
+	}
+
+	return func() (string, error) {
+		if len(ids) == 0 {
+			return actions.CreateGUID()
+		}
+		id := ids[0]
+		ids = ids[1:]
+		return id, nil
+	}
+}
+
+// NewFakeProvider returns a client provider for a fake cluster per context.
+// Each cluster starts with the objects the recorded calls read from it but
+// did not create themselves. Claims are bound and pods are running as soon
+// as they are created.
+func NewFakeProvider(calls []*Call) (*fakes.ClientProvider, error) {
+	objects := map[string][]runtime.Object{}
+	namespaces := map[string]string{}
+	created := map[string]bool{}
+	seeded := map[string]bool{}
+
+	for _, call := range calls {
+		for _, interaction := range call.Interactions {
+			u, err := url.Parse(interaction.URL)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := namespaces[interaction.Context]; !ok {
+				if ns := namespaceOf(u.Path); ns != "" {
+					namespaces[interaction.Context] = ns
+				}
+			}
+
+			if interaction.StatusCode < 200 || interaction.StatusCode > 299 || interaction.ResponseBody == "" {
+				continue
+			}
+
+			found, err := decodeObjects(interaction.ResponseBody)
+			if err != nil {
+				continue
+			}
+
+			for _, obj := range found {
+				key, ok := objectKey(interaction.Context, obj)
+				if !ok {
+					continue
+				}
+
+				switch interaction.Method {
+				case http.MethodPost:
+					created[key] = true
+				case http.MethodGet:
+					if !created[key] && !seeded[key] {
+						seeded[key] = true
+						objects[interaction.Context] = append(objects[interaction.Context], obj)
+					}
+				}
+			}
+		}
+	}
+
+	clients := map[string]*fakes.Client{}
+	provider := &fakes.ClientProvider{}
+	provider.NewStub = func(ctx context.Context, contextName string) (kubecluster.Client, error) {
+		if client, ok := clients[contextName]; ok {
+			return client, nil
+		}
+
+		client, err := newFakeClient(objects[contextName]...)
+		if err != nil {
+			return nil, err
+		}
+		client.ContextReturns(contextName)
+		client.NamespaceReturns(namespaces[contextName])
+		clients[contextName] = client
+		return client, nil
+	}
+	provider.NewExecutorReturns(nil, errors.New("Pod exec is not available in a replay"))
+
+	return provider, nil
+}
+
+var podsResource = schema.GroupVersionResource{Version: "v1", Resource: "pods"}
+
+// newFakeClient returns a fake client backed by a tracker the replay can
+// list pods from. The tracker of the generated clientset is not exposed.
+func newFakeClient(objects ...runtime.Object) (*fakes.Client, error) {
+	tracker := testing.NewObjectTracker(scheme.Scheme, scheme.Codecs.UniversalDecoder())
+	for _, obj := range objects {
+		if err := tracker.Add(obj); err != nil {
+			return nil, err
+		}
+	}
+
+	client := fakes.NewClient()
+	client.PrependReactor("*", "*", testing.ObjectReaction(tracker))
+	client.PrependReactor("patch", "*", patchReaction(tracker))
+
+	client.PrependReactor("create", "persistentvolumeclaims", func(action testing.Action) (bool, runtime.Object, error) {
+		claim := action.(testing.CreateAction).GetObject().(*v1.PersistentVolumeClaim)
+		claim.Status.Phase = v1.ClaimBound
+		return false, nil, nil
+	})
+
+	client.PrependReactor("create", "pods", func(action testing.Action) (bool, runtime.Object, error) {
+		pod := action.(testing.CreateAction).GetObject().(*v1.Pod)
+		pod.Status = runningStatus(pod)
+		return false, nil, nil
+	})
+
+	client.PrependWatchReactor("pods", func(action testing.Action) (bool, watch.Interface, error) {
+		list, err := tracker.List(podsResource, v1.SchemeGroupVersion.WithKind("Pod"), action.GetNamespace())
+		if err != nil {
+			return true, nil, err
+		}
+
+		selector := action.(testing.WatchAction).GetWatchRestrictions().Labels
+		podList := list.(*v1.PodList)
+		w := watch.NewFakeWithChanSize(len(podList.Items), false)
+		for i := range podList.Items {
+			pod := &podList.Items[i]
+			if selector == nil || selector.Matches(labels.Set(pod.Labels)) {
+				w.Modify(pod)
+			}
+		}
+		return true, w, nil
+	})
+
+	return client, nil
+}
+
+// patchReaction applies merge patches to the objects of tracker. The fake
+// clientset does not handle patches itself.
+func patchReaction(tracker testing.ObjectTracker) testing.ReactionFunc {
+	return func(action testing.Action) (bool, runtime.Object, error) {
+		patch := action.(testing.PatchAction)
+		gvr, ns := action.GetResource(), action.GetNamespace()
+
+		obj, err := tracker.Get(gvr, ns, patch.GetName())
+		if err != nil {
+			return true, nil, err
+		}
+
+		original, err := json.Marshal(obj)
+		if err != nil {
+			return true, nil, err
+		}
+
+		patched, err := strategicpatch.StrategicMergePatch(original, patch.GetPatch(), obj)
+		if err != nil {
+			return true, nil, err
+		}
+
+		result := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
+		if err := json.Unmarshal(patched, result); err != nil {
+			return true, nil, err
+		}
+
+		if err := tracker.Update(gvr, result, ns); err != nil {
+			return true, nil, err
+		}
+		return true, result, nil
+	}
+}
+
+func runningStatus(pod *v1.Pod) v1.PodStatus {
+	status := v1.PodStatus{Phase: v1.PodRunning}
+	for _, c := range pod.Spec.Containers {
+		status.ContainerStatuses = append(status.ContainerStatuses, v1.ContainerStatus{
+			Name:  c.Name,
+			Ready: true,
+			State: v1.ContainerState{Running: &v1.ContainerStateRunning{}},
+		})
+	}
+	return status
+}
+
+func decodeObjects(body string) ([]runtime.Object, error) {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode([]byte(body), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if meta.IsListType(obj) {
+		return meta.ExtractList(obj)
+	}
+	return []runtime.Object{obj}, nil
+}
+
+func objectKey(contextName string, obj runtime.Object) (string, bool) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil || accessor.GetName() == "" {
+		return "", false
+	}
+
+	kind := reflect.TypeOf(obj).String()
+	return strings.Join([]string{contextName, kind, accessor.GetNamespace(), accessor.GetName()}, "/"), true
+}
+
+// namespaceOf returns the namespace of an API path such as
+// /api/v1/namespaces/bosh/pods.
+func namespaceOf(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 0; i < len(parts)-2; i++ {
+		if parts[i] == "namespaces" {
+			return parts[i+1]
+		}
+	}
+	return ""
+}
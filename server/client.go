@@ -0,0 +1,60 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+
+	"github.com/evoila/kubernetes-cpi/cpi"
+)
+
+// Client sends CPI requests to a server started with Listen and Handler.
+type Client struct {
+	Address string
+}
+
+// Do sends a request and returns the server's response. Cancelling ctx
+// closes the connection, which cancels the request on the server.
+func (c *Client) Do(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+	payload, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, "http://cpi"+RequestPath, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return Dial(c.Address)
+			},
+		},
+	}
+
+	httpResp, err := httpClient.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer httpResp.Body.Close()
+
+	if httpResp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(httpResp.Body)
+		return nil, fmt.Errorf("CPI server responded with %s: %s", httpResp.Status, bytes.TrimSpace(body))
+	}
+
+	var resp cpi.Response
+	err = json.NewDecoder(httpResp.Body).Decode(&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return &resp, nil
+}
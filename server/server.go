@@ -0,0 +1,103 @@
+// Package server serves CPI requests over HTTP so a single long running
+// process can handle the calls of many short lived director invocations.
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/cpi"
+)
+
+// RequestPath is the path CPI requests are posted to.
+const RequestPath = "/cpi"
+
+// Handler returns an http.Handler that serves CPI requests with the
+// registry. The request context is cancelled when the caller disconnects.
+func Handler(registry *actions.Registry) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(RequestPath, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req cpi.Request
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid CPI request: %s", err), http.StatusBadRequest)
+			return
+		}
+
+		resp, err := registry.Handle(r.Context(), &req)
+		if err != nil {
+			resp = cpi.NewErrorResponse(err)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	})
+	return mux
+}
+
+// Listen opens the listener for an address. Addresses starting with
+// "unix:" or "/" name a Unix socket; a stale socket at the path is
+// replaced, but any other file is left alone. Any other address is a TCP
+// host and port, which must be a loopback address: requests are served
+// without authentication using the cluster credentials of the CPI.
+func Listen(address string) (net.Listener, error) {
+	if path, ok := socketPath(address); ok {
+		if info, err := os.Lstat(path); err == nil {
+			if info.Mode()&os.ModeSocket == 0 {
+				return nil, fmt.Errorf("%s exists and is not a socket", path)
+			}
+			if err := os.Remove(path); err != nil {
+				return nil, err
+			}
+		}
+		return net.Listen("unix", path)
+	}
+
+	if err := checkLoopback(address); err != nil {
+		return nil, err
+	}
+	return net.Listen("tcp", address)
+}
+
+func checkLoopback(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("%s is not a loopback address; the CPI server has no authentication and only listens on loopback addresses and Unix sockets", address)
+}
+
+// Dial connects to an address accepted by Listen.
+func Dial(address string) (net.Conn, error) {
+	if path, ok := socketPath(address); ok {
+		return net.Dial("unix", path)
+	}
+	return net.Dial("tcp", address)
+}
+
+func socketPath(address string) (string, bool) {
+	if strings.HasPrefix(address, "unix:") {
+		return strings.TrimPrefix(address, "unix:"), true
+	}
+	if strings.HasPrefix(address, "/") {
+		return address, true
+	}
+	return "", false
+}
@@ -0,0 +1,125 @@
+package server_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/server"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Server", func() {
+	var (
+		tempDir    string
+		address    string
+		httpServer *http.Server
+		registry   *actions.Registry
+		cancelled  chan error
+	)
+
+	BeforeEach(func() {
+		var err error
+		tempDir, err = ioutil.TempDir("", "cpi-server")
+		Expect(err).NotTo(HaveOccurred())
+		address = "unix:" + filepath.Join(tempDir, "cpi.sock")
+
+		cancelled = make(chan error, 1)
+		registry = actions.NewRegistry()
+		registry.Register("echo", func(s string) (string, error) { return s, nil })
+		registry.Register("fail", func() error { return errors.New("welp") })
+		registry.Register("block", func(ctx context.Context) error {
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+			return ctx.Err()
+		})
+
+		listener, err := server.Listen(address)
+		Expect(err).NotTo(HaveOccurred())
+
+		httpServer = &http.Server{Handler: server.Handler(registry)}
+		go httpServer.Serve(listener)
+	})
+
+	AfterEach(func() {
+		httpServer.Close()
+		os.RemoveAll(tempDir)
+	})
+
+	It("serves requests over the socket", func() {
+		client := &server.Client{Address: address}
+		resp, err := client.Do(context.Background(), &cpi.Request{Method: "echo", Args: []interface{}{"hello"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Result).To(Equal("hello"))
+		Expect(resp.Error).To(BeNil())
+	})
+
+	It("returns action errors in the response", func() {
+		client := &server.Client{Address: address}
+		resp, err := client.Do(context.Background(), &cpi.Request{Method: "fail"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Error).To(Equal(&cpi.ResponseError{Type: "Bosh::Clouds::CloudError", Message: "welp"}))
+	})
+
+	It("responds with an error for unknown methods", func() {
+		client := &server.Client{Address: address}
+		resp, err := client.Do(context.Background(), &cpi.Request{Method: "missing"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(resp.Error.Message).To(Equal(`Unexpected method: "missing"`))
+	})
+
+	It("cancels the request when the client goes away", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		client := &server.Client{Address: address}
+
+		errCh := make(chan error, 1)
+		go func() {
+			_, err := client.Do(ctx, &cpi.Request{Method: "block"})
+			errCh <- err
+		}()
+
+		Consistently(cancelled).ShouldNot(Receive())
+		cancel()
+
+		Eventually(errCh).Should(Receive(HaveOccurred()))
+		Eventually(cancelled).Should(Receive(Equal(context.Canceled)))
+	})
+
+	It("replaces a stale socket file", func() {
+		httpServer.Close()
+
+		listener, err := server.Listen(address)
+		Expect(err).NotTo(HaveOccurred())
+		listener.Close()
+	})
+
+	It("does not replace files that are not sockets", func() {
+		path := filepath.Join(tempDir, "settings.json")
+		Expect(ioutil.WriteFile(path, []byte("{}"), 0600)).To(Succeed())
+
+		_, err := server.Listen("unix:" + path)
+		Expect(err).To(MatchError(path + " exists and is not a socket"))
+		Expect(ioutil.ReadFile(path)).To(Equal([]byte("{}")))
+	})
+
+	It("listens on loopback TCP addresses", func() {
+		listener, err := server.Listen("127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		listener.Close()
+	})
+
+	It("refuses TCP addresses other hosts can reach", func() {
+		_, err := server.Listen(":0")
+		Expect(err).To(MatchError(ContainSubstring(":0 is not a loopback address")))
+
+		_, err = server.Listen("0.0.0.0:0")
+		Expect(err).To(MatchError(ContainSubstring("0.0.0.0:0 is not a loopback address")))
+	})
+})
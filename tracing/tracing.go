@@ -0,0 +1,107 @@
+// Package tracing reports CPI requests, the steps of their actions and the
+// Kubernetes API requests they make as OpenTelemetry spans.
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/evoila/kubernetes-cpi/actions"
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ServiceName is the service the spans are reported for.
+const ServiceName = "bosh-kubernetes-cpi"
+
+// Span attributes set on the span of a CPI request.
+const (
+	MethodKey       = attribute.Key("bosh.cpi.method")
+	DirectorUUIDKey = attribute.Key("bosh.director_uuid")
+	RequestIDKey    = attribute.Key("bosh.request_id")
+	ContextKey      = attribute.Key("k8s.context")
+)
+
+// Options selects where spans are exported to.
+type Options struct {
+	// File receives the spans as JSON when set. Without it no spans are
+	// exported.
+	File io.Writer
+}
+
+// Setup installs a global tracer provider that exports spans as configured
+// by opts. The returned function flushes the pending spans and must be
+// called before the process exits.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	if opts.File == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(opts.File))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String(ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a span for every request. The steps of the actions and
+// their Kubernetes API requests are children of it.
+func Middleware(tracer trace.Tracer) actions.Middleware {
+	return func(next actions.Handler) actions.Handler {
+		return func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+			ctx, info := actions.WithRequestInfo(ctx)
+			ctx, span := tracer.Start(ctx, req.Method, trace.WithAttributes(
+				MethodKey.String(req.Method),
+				DirectorUUIDKey.String(req.Context.DirectorUUID),
+			))
+			defer span.End()
+
+			if req.Context.RequestID != "" {
+				span.SetAttributes(RequestIDKey.String(req.Context.RequestID))
+			}
+
+			resp, err := next(ctx, req)
+
+			if contextName := info.ContextName(); contextName != "" {
+				span.SetAttributes(ContextKey.String(contextName))
+			}
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			} else if resp != nil && resp.Error != nil {
+				span.SetStatus(codes.Error, resp.Error.Message)
+			}
+
+			return resp, err
+		}
+	}
+}
+
+// WrapTransport traces the requests sent to the cluster of a context. It
+// is used as the WrapTransport of a kubecluster.Provider.
+func WrapTransport(contextName string, rt http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(rt,
+		otelhttp.WithSpanNameFormatter(func(operation string, req *http.Request) string {
+			return req.Method + " " + req.URL.Path
+		}),
+		otelhttp.WithSpanOptions(trace.WithAttributes(ContextKey.String(contextName))),
+	)
+}
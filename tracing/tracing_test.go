@@ -0,0 +1,135 @@
+package tracing_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/evoila/kubernetes-cpi/cpi"
+	"github.com/evoila/kubernetes-cpi/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Tracing", func() {
+	var (
+		recorder *tracetest.SpanRecorder
+		provider *sdktrace.TracerProvider
+		req      *cpi.Request
+	)
+
+	BeforeEach(func() {
+		recorder = tracetest.NewSpanRecorder()
+		provider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		req = &cpi.Request{
+			Method:  "attach_disk",
+			Context: cpi.Context{DirectorUUID: "director-uuid", RequestID: "cpi-12345"},
+		}
+	})
+
+	Describe("Middleware", func() {
+		It("starts a span for the request", func() {
+			handler := tracing.Middleware(provider.Tracer("test"))(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				return &cpi.Response{}, nil
+			})
+
+			_, err := handler(context.Background(), req)
+			Expect(err).NotTo(HaveOccurred())
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Name()).To(Equal("attach_disk"))
+			Expect(spans[0].Attributes()).To(ContainElement(attribute.String("bosh.director_uuid", "director-uuid")))
+			Expect(spans[0].Attributes()).To(ContainElement(attribute.String("bosh.request_id", "cpi-12345")))
+			Expect(spans[0].Status().Code).To(Equal(codes.Unset))
+		})
+
+		It("records failed requests", func() {
+			handler := tracing.Middleware(provider.Tracer("test"))(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				return nil, errors.New("welp")
+			})
+
+			handler(context.Background(), req)
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(1))
+			Expect(spans[0].Status().Code).To(Equal(codes.Error))
+			Expect(spans[0].Status().Description).To(Equal("welp"))
+		})
+	})
+
+	Describe("WrapTransport", func() {
+		var previous trace.TracerProvider
+
+		BeforeEach(func() {
+			previous = otel.GetTracerProvider()
+			otel.SetTracerProvider(provider)
+		})
+
+		AfterEach(func() {
+			otel.SetTracerProvider(previous)
+		})
+
+		It("traces Kubernetes requests as children of the request span", func() {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer server.Close()
+
+			ctx, parent := provider.Tracer("test").Start(context.Background(), "attach_disk")
+			rt := tracing.WrapTransport("bosh", http.DefaultTransport)
+
+			httpReq, err := http.NewRequest("GET", server.URL+"/api/v1/pods", nil)
+			Expect(err).NotTo(HaveOccurred())
+
+			resp, err := rt.RoundTrip(httpReq.WithContext(ctx))
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			parent.End()
+
+			spans := recorder.Ended()
+			Expect(spans).To(HaveLen(2))
+			Expect(spans[0].Name()).To(Equal("GET /api/v1/pods"))
+			Expect(spans[0].Parent().SpanID()).To(Equal(parent.SpanContext().SpanID()))
+			Expect(spans[0].Attributes()).To(ContainElement(attribute.String("k8s.context", "bosh")))
+		})
+	})
+
+	Describe("Setup", func() {
+		It("exports spans to a file", func() {
+			previous := otel.GetTracerProvider()
+			defer otel.SetTracerProvider(previous)
+
+			buf := &bytes.Buffer{}
+			shutdown, err := tracing.Setup(context.Background(), tracing.Options{File: buf})
+			Expect(err).NotTo(HaveOccurred())
+
+			handler := tracing.Middleware(otel.Tracer("test"))(func(ctx context.Context, req *cpi.Request) (*cpi.Response, error) {
+				return &cpi.Response{}, nil
+			})
+			handler(context.Background(), req)
+
+			Expect(shutdown(context.Background())).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring(`"Name":"attach_disk"`))
+			Expect(buf.String()).To(ContainSubstring("cpi-12345"))
+		})
+
+		It("leaves the tracer provider alone without a file", func() {
+			previous := otel.GetTracerProvider()
+
+			shutdown, err := tracing.Setup(context.Background(), tracing.Options{})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(otel.GetTracerProvider()).To(BeIdenticalTo(previous))
+			Expect(shutdown(context.Background())).To(Succeed())
+		})
+	})
+})